@@ -0,0 +1,56 @@
+package rangecounter
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// queryBackend returns the values of keys, or an error if backend does not return one per key, so that they can be
+// indexed like keys.
+func queryBackend[V Value](ctx context.Context, backend GenericBackend[V], keys []string) ([]V, error) {
+	values, err := backend.Query(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(keys) {
+		return nil, errors.Errorf("backend returned %v results for %v keys", len(values), len(keys))
+	}
+	return values, nil
+}
+
+// queryMaxBackend returns the max of keys and whether they exist, or an error if backend does not return both per key
+func queryMaxBackend(ctx context.Context, backend ExtremumBackend, keys []string) ([]int64, []bool, error) {
+	values, exists, err := backend.QueryMax(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(values) != len(keys) || len(exists) != len(keys) {
+		return nil, nil, errors.Errorf("backend returned %v results and %v exists for %v keys", len(values), len(exists), len(keys))
+	}
+	return values, exists, nil
+}
+
+// getBackend returns the values of keys and whether they exist, or an error if backend does not return both per key
+func getBackend(ctx context.Context, backend CompareAndSetBackend, keys []string) ([]int64, []bool, error) {
+	values, exists, err := backend.Get(ctx, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(values) != len(keys) || len(exists) != len(keys) {
+		return nil, nil, errors.Errorf("backend returned %v results and %v exists for %v keys", len(values), len(exists), len(keys))
+	}
+	return values, exists, nil
+}
+
+// queryBytesBackend returns the values of keys, or an error if backend does not return one per key
+func queryBytesBackend(ctx context.Context, backend BytesBackend, keys []string) ([][]byte, error) {
+	values, err := backend.QueryBytes(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(keys) {
+		return nil, errors.Errorf("backend returned %v results for %v keys", len(values), len(keys))
+	}
+	return values, nil
+}
@@ -2,7 +2,6 @@ package rangecounter
 
 import (
 	"context"
-	"fmt"
 	"time"
 
 	"github.com/pkg/errors"
@@ -17,31 +16,28 @@ func (drange DateRange) incrementDateForce(multiple int, at time.Time) time.Time
 	return res
 }
 
-type basicDateCounter struct {
-	drange DateRange
-	backend Backend
+type basicDateCounter[V Value] struct {
+	bucketDateLayout
+	backend GenericBackend[V]
 }
 
-func NewBasicDateCounter(drange DateRange, backend Backend) DateRangeCounter {
-	return &basicDateCounter{
-		drange: drange,
+func NewBasicDateCounter(drange DateRange, backend Backend) ConditionalDateRangeCounter {
+	return NewGenericBasicDateCounter[int64](drange, backend)
+}
+
+func NewGenericBasicDateCounter[V Value](drange DateRange, backend GenericBackend[V]) GenericConditionalDateRangeCounter[V] {
+	return &basicDateCounter[V]{
+		bucketDateLayout: bucketDateLayout{
+			drange: drange,
+		},
 		backend: backend,
 	}
 }
 
-func (b *basicDateCounter) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
-	at, err := b.drange.alignDate(at)
+func (b *basicDateCounter[V]) QuerySum(ctx context.Context, at time.Time, bucketCount int) (V, error) {
+	keys, err := b.queryKeys(at, bucketCount)
 	if err != nil {
-		return 0, errors.Wrap(err, "unable to align date")
-	}
-
-	keys := []string{}
-	for i := 0;i<bucketCount;i++ {
-		keys = append(keys, b.getKey(at))
-		at, err = b.drange.incrementDate(-1, at)
-		if err != nil {
-			return 0, errors.Wrap(err, "unable to decrement date")
-		}
+		return 0, err
 	}
 
 	results, err := b.backend.Query(ctx, keys)
@@ -49,7 +45,7 @@ func (b *basicDateCounter) QuerySum(ctx context.Context, at time.Time, bucketCou
 		return 0, errors.Wrap(err, "unable to query counters")
 	}
 
-	sum := int64(0)
+	sum := V(0)
 	for _, res := range results {
 		sum = sum + res
 	}
@@ -57,19 +53,32 @@ func (b *basicDateCounter) QuerySum(ctx context.Context, at time.Time, bucketCou
 	return sum, nil
 }
 
-func (b *basicDateCounter) Increment(ctx context.Context, at time.Time, by int64) error {
+func (b *basicDateCounter[V]) Increment(ctx context.Context, at time.Time, by V) error {
 	at, err := b.drange.alignDate(at)
 	if err != nil {
 		return errors.Wrap(err, "unable to align date")
 	}
 
-	return b.backend.Increment(ctx, []string{b.getKey(at)}, []int64{by})
+	return b.backend.Increment(ctx, []string{b.getKey(at)}, []V{by})
 }
 
-func (b *basicDateCounter) getKey(at time.Time) string {
-	return fmt.Sprintf("%v:%v", b.drange, at.Unix())
+func (b *basicDateCounter[V]) IncrementIfBelow(ctx context.Context, at time.Time, by V, window int, limit V) (bool, V, error) {
+	keys, err := b.queryKeys(at, window)
+	if err != nil {
+		return false, 0, err
+	}
+	at, err = b.drange.alignDate(at)
+	if err != nil {
+		return false, 0, errors.Wrap(err, "unable to align date")
+	}
+
+	applied, sum, err := incrementIfBelow(ctx, b.backend, keys, by, limit, []string{b.getKey(at)}, []V{by})
+	if err != nil {
+		return false, 0, errors.Wrap(err, "unable to conditionally increment counter")
+	}
+	return applied, sum, nil
 }
 
-func (b *basicDateCounter) String() string {
-	return "basicDateCounter"
+func (b *basicDateCounter[V]) String() string {
+	return "basicDateCounter(" + b.drange.String() + ")"
 }
@@ -3,34 +3,64 @@ package rangecounter
 import (
 	"context"
 	"fmt"
+	"strconv"
 )
 
-type basicIntRangeCounter struct {
-	backend Backend
+type basicIntRangeCounter[V Value] struct {
+	backend GenericBackend[V]
 }
 
-func (birc *basicIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
-	keys := []string{}
-	for ; from <= to; from++ {
-		keys = append(keys, fmt.Sprint(from))
-	}
-	ints, err := birc.backend.Query(ctx, keys)
+func (birc *basicIntRangeCounter[V]) QuerySum(ctx context.Context, from, to int64) (V, error) {
+	ints, err := birc.backend.Query(ctx, birc.queryKeys(from, to))
 	if err != nil {
 		return 0, err
 	}
-	sum := int64(0)
+	sum := V(0)
 	for _, it := range ints {
 		sum += it
 	}
 	return sum, nil
 }
 
-func (birc *basicIntRangeCounter) Increment(ctx context.Context, at int64, by int64) error {
-	return birc.backend.Increment(ctx, []string{fmt.Sprint(at)}, []int64{by})
+func (birc *basicIntRangeCounter[V]) Increment(ctx context.Context, at int64, by V) error {
+	return birc.backend.Increment(ctx, []string{fmt.Sprint(at)}, []V{by})
+}
+
+func (birc *basicIntRangeCounter[V]) IncrementIfBelow(ctx context.Context, at int64, by V, from, to int64, limit V) (bool, V, error) {
+	return incrementIfBelow(ctx, birc.backend, birc.queryKeys(from, to), by, limit, []string{fmt.Sprint(at)}, []V{by})
+}
+
+// KeySpan returns the index of the bucket of key
+func (birc *basicIntRangeCounter[V]) KeySpan(key string) (int64, int64, bool) {
+	return bucketKeySpan(key)
+}
+
+func bucketKeySpan(key string) (int64, int64, bool) {
+	idx, err := strconv.ParseInt(key, 10, 64)
+	if err != nil || strconv.FormatInt(idx, 10) != key {
+		return 0, 0, false
+	}
+	return idx, idx, true
 }
 
-func NewBasicIntRangeCounter(backend Backend) IntRangeCounter {
-	return &basicIntRangeCounter{
+func (birc *basicIntRangeCounter[V]) queryKeys(from, to int64) []string {
+	keys := []string{}
+	for ; from <= to; from++ {
+		keys = append(keys, fmt.Sprint(from))
+	}
+	return keys
+}
+
+func NewBasicIntRangeCounter(backend Backend) ConditionalIntRangeCounter {
+	return NewGenericBasicIntRangeCounter[int64](backend)
+}
+
+func NewGenericBasicIntRangeCounter[V Value](backend GenericBackend[V]) GenericConditionalIntRangeCounter[V] {
+	return &basicIntRangeCounter[V]{
 		backend: backend,
 	}
 }
+
+func (birc *basicIntRangeCounter[V]) String() string {
+	return "basicIntRangeCounter"
+}
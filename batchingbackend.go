@@ -0,0 +1,124 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// BatchingBackendOptions configure NewBatchingBackend.
+type BatchingBackendOptions struct {
+	// Window is how long the first query of a batch wait for others to join it
+	Window time.Duration
+	// MaxKeys send the batch as soon as it has that many distinct keys, no limit if zero
+	MaxKeys int
+}
+
+type queryBatch struct {
+	keys   []string
+	index  map[string]int
+	flight *flight[[]int64]
+}
+
+type batchingBackend struct {
+	backend Backend
+	options BatchingBackendOptions
+
+	lock sync.Mutex
+	// batch is the one new queries join, nil until a query arrive
+	batch *queryBatch
+}
+
+// NewBatchingBackend merge the queries made within Window of each other into a single query to backend, each key
+// shared by several of them, like the upper nodes of a tree counter, being queried once. The batched query keep the
+// context values of its first query, and is only cancelled once all its queries are.
+// Increments are not batched. The capabilities of backend are kept by KeepCapabilities, their calls going straight to
+// backend.
+func NewBatchingBackend(backend Backend, options BatchingBackendOptions) Backend {
+	return KeepCapabilities(backend, &batchingBackend{
+		backend: backend,
+		options: options,
+	})
+}
+
+func (b *batchingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	if len(keys) == 0 {
+		return []int64{}, nil
+	}
+
+	b.lock.Lock()
+	batch := b.batch
+	if batch == nil {
+		batch = &queryBatch{
+			index:  map[string]int{},
+			flight: newFlight[[]int64](ctx),
+		}
+		b.batch = batch
+		time.AfterFunc(b.options.Window, func() {
+			b.send(batch)
+		})
+	}
+	positions := make([]int, len(keys))
+	for i, key := range keys {
+		position, ok := batch.index[key]
+		if !ok {
+			position = len(batch.keys)
+			batch.index[key] = position
+			batch.keys = append(batch.keys, key)
+		}
+		positions[i] = position
+	}
+	batch.flight.waiters++
+	full := b.options.MaxKeys > 0 && len(batch.keys) >= b.options.MaxKeys
+	b.lock.Unlock()
+
+	if full {
+		go b.send(batch)
+	}
+	values, err := batch.flight.wait(ctx, &b.lock, func() {
+		b.detachLocked(batch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	results := make([]int64, len(keys))
+	for i, position := range positions {
+		results[i] = values[position]
+	}
+	return results, nil
+}
+
+// detachLocked stop new queries from joining batch, returning false if it was already
+func (b *batchingBackend) detachLocked(batch *queryBatch) bool {
+	if b.batch != batch {
+		return false
+	}
+	b.batch = nil
+	return true
+}
+
+// send batch to backend, once, when its window elapse or it is full
+func (b *batchingBackend) send(batch *queryBatch) {
+	b.lock.Lock()
+	detached := b.detachLocked(batch)
+	abandoned := batch.flight.waiters == 0
+	b.lock.Unlock()
+	if !detached || abandoned {
+		return
+	}
+	batch.flight.run(func(ctx context.Context) ([]int64, error) {
+		return queryBackend(ctx, b.backend, batch.keys)
+	})
+}
+
+func (b *batchingBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	return b.backend.Increment(ctx, keys, values)
+}
+
+func (b *batchingBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	return b.backend.(QueryIncrementBackend).QueryIncrement(ctx, queryKeys, incrementKeys, values)
+}
+
+func (b *batchingBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	return b.backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+}
@@ -0,0 +1,140 @@
+package rangecounter
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// queryCalls returns a backend, and a function returning the keys of each query made since its last call
+func queryCalls() (Backend, func() [][]string) {
+	calls := [][]string{}
+	lock := sync.Mutex{}
+	backend := NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+		if call.Op == QueryOperation {
+			lock.Lock()
+			calls = append(calls, call.Keys)
+			lock.Unlock()
+		}
+		return Fault{}
+	})
+	return backend, func() [][]string {
+		lock.Lock()
+		defer lock.Unlock()
+		made := calls
+		calls = [][]string{}
+		return made
+	}
+}
+
+func TestBatchingBackend(t *testing.T) {
+	ctx := context.Background()
+	inner, calls := queryCalls()
+	assert.NoError(t, inner.Increment(ctx, []string{"a", "b", "x", "z"}, []int64{1, 2, 3, 4}))
+	backend := NewBatchingBackend(inner, BatchingBackendOptions{
+		Window:  time.Hour,
+		MaxKeys: 6,
+	})
+
+	tests := []struct {
+		keys     []string
+		expected []int64
+	}{
+		{[]string{"a", "b", "c", "x"}, []int64{1, 2, 0, 3}},
+		{[]string{"c", "b", "a", "y"}, []int64{0, 2, 1, 0}},
+		{[]string{"z", "a", "b", "c", "z"}, []int64{4, 1, 2, 0, 4}},
+	}
+	wg := sync.WaitGroup{}
+	for _, test := range tests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results, err := backend.Query(ctx, test.keys)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, results, test.keys)
+		}()
+	}
+	wg.Wait()
+
+	made := calls()
+	if assert.Len(t, made, 1, "sent once the sixth distinct key joined") {
+		sort.Strings(made[0])
+		assert.Equal(t, []string{"a", "b", "c", "x", "y", "z"}, made[0])
+	}
+
+	results, err := NewBatchingBackend(inner, BatchingBackendOptions{Window: time.Millisecond}).Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, results, "sent once the window elapsed")
+	assert.Len(t, calls(), 1)
+
+	_, ok := backend.(ConditionalIncrementBackend)
+	assert.False(t, ok, "the fault injecting backend is not conditional")
+	_, ok = NewBatchingBackend(NewInMemoryBackend(), BatchingBackendOptions{}).(ConditionalIncrementBackend)
+	assert.True(t, ok)
+}
+
+func TestBatchingBackendCancel(t *testing.T) {
+	inner, calls := queryCalls()
+	backend := NewBatchingBackend(inner, BatchingBackendOptions{
+		Window:  time.Hour,
+		MaxKeys: 2,
+	})
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := backend.Query(cancelledCtx, []string{"a"})
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	results, err := backend.Query(context.Background(), []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0, 0}, results)
+	assert.Equal(t, [][]string{{"a", "b"}}, calls(), "an abandoned batch is not joined, nor sent")
+}
+
+func TestBatchingBackendTreeCounter(t *testing.T) {
+	ctx := context.Background()
+	inner, calls := queryCalls()
+	counter := NewRangeTreeIntCounter(NewBatchingBackend(inner, BatchingBackendOptions{Window: 5 * time.Millisecond}), 8, 2)
+	reference := NewRangeTreeIntCounter(NewInMemoryBackend(), 8, 2)
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		at := random.Int63n(10000)
+		assert.NoError(t, counter.Increment(ctx, at, 1))
+		assert.NoError(t, reference.Increment(ctx, at, 1))
+	}
+
+	type query struct {
+		from int64
+		to   int64
+	}
+	queries := []query{}
+	for i := 0; i < 50; i++ {
+		from := random.Int63n(10000)
+		queries = append(queries, query{from, from + random.Int63n(5000)})
+	}
+	wg := sync.WaitGroup{}
+	for _, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expected, err := reference.QuerySum(ctx, q.from, q.to)
+			assert.NoError(t, err)
+			sum, err := counter.QuerySum(ctx, q.from, q.to)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, sum, "%v to %v", q.from, q.to)
+		}()
+	}
+	wg.Wait()
+	assert.Less(t, len(calls()), len(queries))
+}
@@ -123,6 +123,26 @@ func BenchmarkBasicDateBehaviour(b *testing.B) {
 			"tree-4-8-to-second", func(dateRange DateRange, backend Backend) DateRangeCounter {
 				return NewIntBackedDateRange(NewIntRangeTranslator(NewRangeTreeIntCounter(backend, 4, 8), dateRange, Seconds), dateRange)
 			},
+		}, {
+			"prefix-sum-overlay-basic", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", NewBasicIntRangeCounter(backend)), dateRange)
+			},
+		}, {
+			"prefix-sum-overlay-tree-8", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", NewRangeTreeIntCounter(backend, 8, 1)), dateRange)
+			},
+		}, {
+			"hybrid-8-1-2-4-6", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewHybridIntRangeCounter(backend, 8, 1, []int{2, 4, 6}), dateRange)
+			},
+		}, {
+			"hybrid-4-2-2", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewHybridIntRangeCounter(backend, 4, 2, []int{2}), dateRange)
+			},
+		}, {
+			"hybrid-4-2-2-3", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewHybridIntRangeCounter(backend, 4, 2, []int{2, 3}), dateRange)
+			},
 		},
 	}
 	for _, d := range tests {
@@ -163,3 +183,128 @@ func BenchmarkBasicDateBehaviour(b *testing.B) {
 		})
 	}
 }
+
+// BenchmarkAppendMostlyBehaviour increment mostly at the latest minute, with a few late writes, as is typical of
+// counting events as they happen.
+func BenchmarkAppendMostlyBehaviour(b *testing.B) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+	maxLateness := 10
+	latePercent := 5
+	maxQueryRange := 100
+
+	counterToTest := []struct {
+		name    string
+		factory func(dateRange DateRange, backend Backend) DateRangeCounter
+	}{
+		{
+			"dateRange", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewBasicDateCounter(dateRange, backend)
+			},
+		}, {
+			"tree-8", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewRangeTreeIntCounter(backend, 8, 1), dateRange)
+			},
+		}, {
+			"prefix-sum", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", nil), dateRange)
+			},
+		}, {
+			"prefix-sum-overlay-basic", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", NewBasicIntRangeCounter(backend)), dateRange)
+			},
+		}, {
+			"prefix-sum-overlay-tree-8", func(dateRange DateRange, backend Backend) DateRangeCounter {
+				return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", NewRangeTreeIntCounter(backend, 8, 1)), dateRange)
+			},
+		},
+	}
+	for _, counter := range counterToTest {
+		b.Run(counter.name, func(b *testing.B) {
+			rangeToTest := Minute
+			round := 10000
+
+			for bi := 0; bi < b.N; bi++ {
+				backend := NewBenchmarkBackend()
+				dateCounter := counter.factory(rangeToTest, backend)
+				rand.Seed(0)
+				ctx := context.Background()
+
+				for i := 0; i < round; i++ {
+					offset := i
+					if rand.Int()%100 < latePercent {
+						offset = max(0, i-rand.Int()%maxLateness-1)
+					}
+					err := dateCounter.Increment(ctx, rangeToTest.incrementDateForce(offset, baseDate), 1)
+					if err != nil {
+						b.Fail()
+					}
+				}
+				b.ReportMetric(float64(backend.incrementKeyTouched)/float64(round), "incrementKeyTouched")
+
+				for i := 0; i < round; i++ {
+					startOffset := rand.Int() % round
+					bucket := (rand.Int() % maxQueryRange) + 1
+					_, err := dateCounter.QuerySum(ctx, rangeToTest.incrementDateForce(startOffset, baseDate), bucket)
+					if err != nil {
+						b.Fail()
+					}
+				}
+				b.ReportMetric(float64(backend.queryKeyTouched)/float64(round), "queryKeyTouched")
+				b.ReportMetric(float64(len(backend.store))/float64(round), "keyUsed")
+			}
+		})
+	}
+}
+
+// BenchmarkSyntheticWorkloads replay generated traces of skewed time distributions against some counters.
+func BenchmarkSyntheticWorkloads(b *testing.B) {
+	// start past the widest query, as the prefix sum counter only support positive indexes
+	start, span := int64(1000), int64(100000)
+	queryWidths := map[int64]float64{5: 1, 20: 1, 100: 1}
+	distributions := []struct {
+		name         string
+		distribution IndexDistribution
+	}{
+		{"uniform", UniformIndexes(start, span)},
+		{"zipf", ZipfIndexes(start, span, 1.2)},
+		{"bursty", BurstyIndexes(start, span, 10, 60, 0.9)},
+		{"recent-heavy", RecentHeavyIndexes(start, span, 30)},
+	}
+	counterToTest := []struct {
+		name    string
+		factory func(backend Backend) IntRangeCounter
+	}{
+		{"basic", func(backend Backend) IntRangeCounter { return NewBasicIntRangeCounter(backend) }},
+		{"tree-8", func(backend Backend) IntRangeCounter { return NewRangeTreeIntCounter(backend, 8, 1) }},
+		{"hybrid-8-1-2-4-6", func(backend Backend) IntRangeCounter { return NewHybridIntRangeCounter(backend, 8, 1, []int{2, 4, 6}) }},
+		{"prefix-sum-overlay-tree-8", func(backend Backend) IntRangeCounter {
+			return NewPrefixSumIntRangeCounter(backend, "prefixsum", NewRangeTreeIntCounter(backend, 8, 1))
+		}},
+	}
+	for _, d := range distributions {
+		trace := TraceGenerator{
+			Writes:       10000,
+			Queries:      10000,
+			WriteIndexes: d.distribution,
+			QueryIndexes: d.distribution,
+			QueryWidths:  queryWidths,
+		}.Generate()
+
+		b.Run(d.name, func(b *testing.B) {
+			for _, counter := range counterToTest {
+				b.Run(counter.name, func(b *testing.B) {
+					for bi := 0; bi < b.N; bi++ {
+						report, err := ReplayIntRangeTrace(context.Background(), trace, NewBenchmarkBackend(), counter.factory)
+						if err != nil {
+							b.Fatal(err)
+						}
+						b.ReportMetric(report.WritesPerIncrement, "incrementKeyTouched")
+						b.ReportMetric(report.ReadsPerQuery, "queryKeyTouched")
+						b.ReportMetric(report.KeysPerIndex(), "keyPerIndex")
+						b.ReportMetric(float64(report.QueryLatency.P99.Nanoseconds()), "queryP99ns")
+					}
+				})
+			}
+		})
+	}
+}
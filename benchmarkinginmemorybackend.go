@@ -2,8 +2,8 @@ package rangecounter
 
 import "context"
 
-type benchmarkingInMemoryBackend struct {
-	store               map[string]int64
+type benchmarkingInMemoryBackend[V Value] struct {
+	store               map[string]V
 	queryCall           int64
 	queryCallFactor     int
 	queryKeyTouched     int64
@@ -14,9 +14,13 @@ type benchmarkingInMemoryBackend struct {
 	incrementKeyFactor  int
 }
 
-func NewBenchmarkBackend() *benchmarkingInMemoryBackend {
-	return &benchmarkingInMemoryBackend{
-		store:               map[string]int64{},
+func NewBenchmarkBackend() *benchmarkingInMemoryBackend[int64] {
+	return NewGenericBenchmarkBackend[int64]()
+}
+
+func NewGenericBenchmarkBackend[V Value]() *benchmarkingInMemoryBackend[V] {
+	return &benchmarkingInMemoryBackend[V]{
+		store:               map[string]V{},
 		queryCallFactor:     0,
 		queryKeyFactor:      0,
 		incrementCallFactor: 0,
@@ -24,14 +28,14 @@ func NewBenchmarkBackend() *benchmarkingInMemoryBackend {
 	}
 }
 
-func (b *benchmarkingInMemoryBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+func (b *benchmarkingInMemoryBackend[V]) Query(ctx context.Context, keys []string) ([]V, error) {
 	b.queryCall++
 	b.queryKeyTouched += int64(len(keys))
 
 	for i := 0; i < b.queryCallFactor; i++ {
 		load()
 	}
-	results := make([]int64, 0, len(keys))
+	results := make([]V, 0, len(keys))
 	for _, key := range keys {
 		for i := 0; i < b.queryKeyFactor; i++ {
 			load()
@@ -41,7 +45,7 @@ func (b *benchmarkingInMemoryBackend) Query(ctx context.Context, keys []string)
 	return results, nil
 }
 
-func (b *benchmarkingInMemoryBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+func (b *benchmarkingInMemoryBackend[V]) Increment(ctx context.Context, keys []string, values []V) error {
 	b.incrementCall++
 	b.incrementKeyTouched += int64(len(keys))
 
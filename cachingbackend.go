@@ -0,0 +1,157 @@
+package rangecounter
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// ClosedKeyCacheOptions configure NewClosedKeyCachingBackend.
+type ClosedKeyCacheOptions struct {
+	// KeySpan returns the time covered by a key, usually the KeySpan of the layout or counter using the backend.
+	// Keys it does not know are never cached.
+	KeySpan func(key string) (time.Time, time.Time, bool)
+	// AllowedLateness is how long after the end of its span a key can still be incremented
+	AllowedLateness time.Duration
+	// Capacity is the number of keys cached, 10000 if zero
+	Capacity int
+
+	now func() time.Time
+}
+
+type cachedValue struct {
+	key   string
+	value int64
+}
+
+type closedKeyCachingBackend struct {
+	backend Backend
+	options ClosedKeyCacheOptions
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	// recency has the most recently used entry at its front
+	recency *list.List
+	// generation change whenever a closed key start or finish being incremented, so that a query which read it
+	// meanwhile does not cache what it read
+	generation uint64
+}
+
+// NewClosedKeyCachingBackend cache the value of the keys which are closed, that is whose span ended more than
+// AllowedLateness ago, in a LRU of Capacity keys. Their values cannot change anymore, so they are cached until
+// evicted, while the keys still open are always queried from backend. An increment to a closed key through this
+// backend evict it, but one through another backend is not seen.
+// The capabilities of backend are kept by KeepCapabilities, their calls going straight to backend.
+func NewClosedKeyCachingBackend(backend Backend, options ClosedKeyCacheOptions) Backend {
+	if options.KeySpan == nil {
+		panic("KeySpan must be set")
+	}
+	if options.Capacity <= 0 {
+		options.Capacity = 10000
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+	return KeepCapabilities(backend, &closedKeyCachingBackend{
+		backend: backend,
+		options: options,
+		entries: map[string]*list.Element{},
+		recency: list.New(),
+	})
+}
+
+func (c *closedKeyCachingBackend) isClosed(key string, now time.Time) bool {
+	_, end, ok := c.options.KeySpan(key)
+	return ok && !end.Add(c.options.AllowedLateness).After(now)
+}
+
+func (c *closedKeyCachingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	now := c.options.now()
+	results := make([]int64, len(keys))
+	missKeys := []string{}
+	missPositions := []int{}
+
+	c.lock.Lock()
+	generation := c.generation
+	for i, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.recency.MoveToFront(element)
+			results[i] = element.Value.(*cachedValue).value
+			continue
+		}
+		missKeys = append(missKeys, key)
+		missPositions = append(missPositions, i)
+	}
+	c.lock.Unlock()
+	if len(missKeys) == 0 {
+		return results, nil
+	}
+
+	values, err := queryBackend(ctx, c.backend, missKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for i, key := range missKeys {
+		results[missPositions[i]] = values[i]
+		if c.generation == generation && c.isClosed(key, now) {
+			c.add(key, values[i])
+		}
+	}
+	return results, nil
+}
+
+// add cache value, evicting the least recently used key if full
+func (c *closedKeyCachingBackend) add(key string, value int64) {
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cachedValue).value = value
+		c.recency.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.recency.PushFront(&cachedValue{key: key, value: value})
+	if c.recency.Len() > c.options.Capacity {
+		oldest := c.recency.Back()
+		c.recency.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedValue).key)
+	}
+}
+
+// evict the keys being incremented, in case they are incremented later than allowed. It is called both before and
+// after the increment, as a query in between may cache the value before it.
+func (c *closedKeyCachingBackend) evict(keys []string) {
+	now := c.options.now()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	closed := false
+	for _, key := range keys {
+		if element, ok := c.entries[key]; ok {
+			c.recency.Remove(element)
+			delete(c.entries, key)
+		}
+		closed = closed || c.isClosed(key, now)
+	}
+	if closed {
+		c.generation++
+	}
+}
+
+func (c *closedKeyCachingBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	c.evict(keys)
+	defer c.evict(keys)
+	return c.backend.Increment(ctx, keys, values)
+}
+
+func (c *closedKeyCachingBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	c.evict(incrementKeys)
+	defer c.evict(incrementKeys)
+	return c.backend.(QueryIncrementBackend).QueryIncrement(ctx, queryKeys, incrementKeys, values)
+}
+
+func (c *closedKeyCachingBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	c.evict(keys)
+	defer c.evict(keys)
+	return c.backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+}
@@ -0,0 +1,135 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// queriedKeys returns a backend, and a function returning the number of keys queried since its last call
+func queriedKeys() (Backend, func() int) {
+	count := 0
+	backend := NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+		if call.Op == QueryOperation {
+			count += len(call.Keys)
+		}
+		return Fault{}
+	})
+	return backend, func() int {
+		queried := count
+		count = 0
+		return queried
+	}
+}
+
+func TestClosedKeyCachingBackend(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	inner, queried := queriedKeys()
+	backend := NewClosedKeyCachingBackend(inner, ClosedKeyCacheOptions{
+		KeySpan:         NewBucketDateLayout(Minute).KeySpan,
+		AllowedLateness: 5 * time.Minute,
+		now: func() time.Time {
+			return now
+		},
+	})
+	counter := NewBasicDateCounter(Minute, backend)
+
+	for i := 0; i < 120; i++ {
+		assert.NoError(t, counter.Increment(ctx, now.Add(-time.Duration(i)*time.Minute), 1))
+	}
+
+	sum, err := counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 120, sum)
+	assert.Equal(t, 120, queried())
+
+	assert.NoError(t, counter.Increment(ctx, now, 1))
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 121, sum)
+	assert.Equal(t, 6, queried(), "the buckets of the last 5 minutes, and the current one, are still open")
+
+	now = now.Add(time.Minute)
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 120, sum)
+	assert.Equal(t, 7, queried(), "the bucket which just closed is queried once more")
+
+	assert.NoError(t, counter.Increment(ctx, now.Add(-time.Hour), 10))
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 130, sum, "a late increment through the cache evict its key")
+	assert.Equal(t, 7, queried())
+
+	_, ok := NewClosedKeyCachingBackend(NewInMemoryBackend(), ClosedKeyCacheOptions{
+		KeySpan: NewBucketDateLayout(Minute).KeySpan,
+	}).(ConditionalIncrementBackend)
+	assert.True(t, ok, "conditional backend stays conditional")
+}
+
+func TestClosedKeyCachingBackendEviction(t *testing.T) {
+	ctx := context.Background()
+	inner, queried := queriedKeys()
+	backend := NewClosedKeyCachingBackend(inner, ClosedKeyCacheOptions{
+		KeySpan: func(key string) (time.Time, time.Time, bool) {
+			return time.Time{}, time.Time{}, key != "open"
+		},
+		Capacity: 2,
+	})
+
+	tests := []struct {
+		keys    []string
+		queried int
+	}{
+		{[]string{"a", "b", "open"}, 3},
+		{[]string{"a", "b", "open"}, 1},
+		{[]string{"c"}, 1},
+		{[]string{"b", "c"}, 0},
+		{[]string{"a"}, 1},
+		{[]string{"b", "c"}, 1},
+	}
+	for i, test := range tests {
+		_, err := backend.Query(ctx, test.keys)
+		assert.NoError(t, err)
+		assert.Equal(t, test.queried, queried(), "query %v %v", i, test.keys)
+	}
+}
+
+// afterQueryBackend call afterQuery once, after the first query read its values
+type afterQueryBackend struct {
+	Backend
+	afterQuery func()
+}
+
+func (a *afterQueryBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	values, err := a.Backend.Query(ctx, keys)
+	if a.afterQuery != nil {
+		afterQuery := a.afterQuery
+		a.afterQuery = nil
+		afterQuery()
+	}
+	return values, err
+}
+
+func TestClosedKeyCachingBackendConcurrentIncrement(t *testing.T) {
+	ctx := context.Background()
+	inner := &afterQueryBackend{Backend: NewInMemoryBackend()}
+	backend := NewClosedKeyCachingBackend(inner, ClosedKeyCacheOptions{
+		KeySpan: func(key string) (time.Time, time.Time, bool) {
+			return time.Time{}, time.Time{}, true
+		},
+	})
+	inner.afterQuery = func() {
+		assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{1}))
+	}
+
+	results, err := backend.Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0}, results, "read before the increment")
+	results, err = backend.Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, results, "the value read before the increment is not cached")
+}
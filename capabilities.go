@@ -0,0 +1,31 @@
+package rangecounter
+
+// CapableBackend is a Backend decorator implementing every optional capability a decorator keep, whether or not the
+// backend it wrap has them. KeepCapabilities only expose those the backend has.
+type CapableBackend interface {
+	QueryIncrementBackend
+	ConditionalIncrementBackend
+}
+
+// KeepCapabilities returns decorator as a QueryIncrementBackend and as a ConditionalIncrementBackend only if backend,
+// the one it wrap, is one, so that a caller checking for a capability of the decorator find the same as on backend.
+// decorator only get the calls of the capabilities of backend.
+func KeepCapabilities(backend Backend, decorator CapableBackend) Backend {
+	_, isQueryIncrement := backend.(QueryIncrementBackend)
+	_, isConditional := backend.(ConditionalIncrementBackend)
+	return withCapabilities(decorator, isQueryIncrement, isConditional)
+}
+
+// withCapabilities returns decorator as a QueryIncrementBackend if isQueryIncrement, and as a
+// ConditionalIncrementBackend if isConditional
+func withCapabilities(decorator CapableBackend, isQueryIncrement, isConditional bool) Backend {
+	switch {
+	case isQueryIncrement && isConditional:
+		return decorator
+	case isQueryIncrement:
+		return struct{ QueryIncrementBackend }{decorator}
+	case isConditional:
+		return struct{ ConditionalIncrementBackend }{decorator}
+	}
+	return struct{ Backend }{decorator}
+}
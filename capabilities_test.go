@@ -0,0 +1,59 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// conditionalOnlyBackend is a ConditionalIncrementBackend which cannot query and increment at once
+type conditionalOnlyBackend struct {
+	Backend
+}
+
+func (c conditionalOnlyBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	return c.Backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+}
+
+func TestKeepCapabilities(t *testing.T) {
+	tests := []struct {
+		name           string
+		backend        Backend
+		queryIncrement bool
+		conditional    bool
+	}{
+		{"both", NewInMemoryBackend(), true, true},
+		{"query increment", queryIncrementOnlyBackend{NewInMemoryBackend().(QueryIncrementBackend)}, true, false},
+		{"conditional", conditionalOnlyBackend{NewInMemoryBackend()}, false, true},
+		{"none", &flakyBackend{Backend: NewInMemoryBackend()}, false, false},
+	}
+	decorators := map[string]func(backend Backend) Backend{
+		"batching": func(backend Backend) Backend {
+			return NewBatchingBackend(backend, BatchingBackendOptions{})
+		},
+		"caching": func(backend Backend) Backend {
+			return NewClosedKeyCachingBackend(backend, ClosedKeyCacheOptions{KeySpan: NewBucketDateLayout(Minute).KeySpan})
+		},
+		"resilient": func(backend Backend) Backend {
+			return NewResilientBackend(backend, ResilientBackendOptions{})
+		},
+		"sharded": func(backend Backend) Backend {
+			return NewShardedBackend(map[string]Backend{"a": backend}, ShardedBackendOptions{})
+		},
+		"hash tagged": func(backend Backend) Backend {
+			return NewHashTaggedBackend(backend, "tag")
+		},
+	}
+	for name, decorate := range decorators {
+		for _, test := range tests {
+			t.Run(name+" "+test.name, func(t *testing.T) {
+				backend := decorate(test.backend)
+				_, ok := backend.(QueryIncrementBackend)
+				assert.Equal(t, test.queryIncrement, ok)
+				_, ok = backend.(ConditionalIncrementBackend)
+				assert.Equal(t, test.conditional, ok)
+			})
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Command rangecountertune recommend the heightLimit and bitLength of a range tree counter for a workload.
+//
+// The workload is either described by flags, such as
+//
+//	rangecountertune -widths 5:1,20:1,100:1 -writes-per-query 1 -span 100000
+//
+// or read from a trace of one operation in JSON per line, such as {"op":"query","from":10,"to":20}. With
+// -date-range, the trace is of a date range counter instead, such as {"op":"query","at":"2019-01-01T00:00:00Z","bucketCount":5}.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/pkg/errors"
+)
+
+func main() {
+	widths := flag.String("widths", "5:1,20:1,100:1", "query widths and their relative frequency, as width:weight separated by comma")
+	writesPerQuery := flag.Float64("writes-per-query", 1, "number of increment per query")
+	start := flag.Int64("start", 0, "first index written and queried")
+	span := flag.Int64("span", 100000, "number of indexes written and queried")
+	readCost := flag.Float64("read-cost", 1, "cost of a key read")
+	writeCost := flag.Float64("write-cost", 1, "cost of a key written")
+	keyCost := flag.Float64("key-cost", 0, "cost of a key stored per index written")
+	tracePath := flag.String("trace", "", "trace to replay instead of the workload flags")
+	dateRange := flag.String("date-range", "", "read the trace as a date range trace of second, minute or hour")
+	queries := flag.Int("queries", 10000, "number of queries simulated without a trace")
+	seed := flag.Int64("seed", 0, "seed of the simulated workload")
+	top := flag.Int("top", 0, "number of recommendation shown, all if 0")
+	flag.Parse()
+
+	workload, err := buildWorkload(*widths, *tracePath, *dateRange)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *tracePath == "" {
+		workload.WritesPerQuery = *writesPerQuery
+		workload.StartIndex = *start
+		workload.IndexSpan = *span
+	}
+	workload.ReadCost = *readCost
+	workload.WriteCost = *writeCost
+	workload.KeyCost = *keyCost
+
+	recommendations, err := rangecounter.Tune(context.Background(), workload, rangecounter.TuneOptions{
+		Queries: *queries,
+		Seed:    *seed,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *top > 0 && *top < len(recommendations) {
+		recommendations = recommendations[:*top]
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "height\tbits\treads\twrites\tkeys\test. reads\test. writes\test. keys\tcost\t")
+	for _, it := range recommendations {
+		fmt.Fprintf(writer, "%v\t%v\t%.3g\t%.3g\t%.3g\t%.3g\t%.3g\t%.3g\t%.4g\t\n",
+			it.HeightLimit, it.BitLength, it.Reads, it.Writes, it.Keys,
+			it.EstimatedReads, it.EstimatedWrites, it.EstimatedKeys, it.Cost)
+	}
+	writer.Flush()
+}
+
+func buildWorkload(widths string, tracePath string, dateRange string) (rangecounter.Workload, error) {
+	if tracePath != "" {
+		file, err := os.Open(tracePath)
+		if err != nil {
+			return rangecounter.Workload{}, errors.Wrap(err, "unable to open trace")
+		}
+		defer file.Close()
+
+		if dateRange == "" {
+			trace, err := rangecounter.ReadIntRangeTrace(file)
+			if err != nil {
+				return rangecounter.Workload{}, err
+			}
+			return rangecounter.WorkloadFromTrace(trace), nil
+		}
+
+		drange, err := parseDateRange(dateRange)
+		if err != nil {
+			return rangecounter.Workload{}, err
+		}
+		trace, err := rangecounter.ReadDateRangeTrace(file)
+		if err != nil {
+			return rangecounter.Workload{}, err
+		}
+		return rangecounter.WorkloadFromTrace(rangecounter.IntRangeTrace(trace, drange)), nil
+	}
+
+	queryWidths, err := parseWidths(widths)
+	if err != nil {
+		return rangecounter.Workload{}, err
+	}
+	return rangecounter.Workload{QueryWidths: queryWidths}, nil
+}
+
+func parseWidths(widths string) (map[int64]float64, error) {
+	parsed := map[int64]float64{}
+	for _, it := range strings.Split(widths, ",") {
+		widthAndWeight := strings.SplitN(strings.TrimSpace(it), ":", 2)
+		width, err := strconv.ParseInt(widthAndWeight[0], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid width %v", it)
+		}
+		if width <= 0 {
+			return nil, errors.Errorf("invalid width %v, it must be positive", it)
+		}
+		weight := 1.0
+		if len(widthAndWeight) == 2 {
+			weight, err = strconv.ParseFloat(widthAndWeight[1], 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid weight %v", it)
+			}
+		}
+		parsed[width] += weight
+	}
+	return parsed, nil
+}
+
+func parseDateRange(dateRange string) (rangecounter.DateRange, error) {
+	for _, it := range []rangecounter.DateRange{rangecounter.Seconds, rangecounter.Minute, rangecounter.Hour} {
+		if it.String() == dateRange {
+			return it, nil
+		}
+	}
+	return 0, errors.Errorf("unknown date range %v", dateRange)
+}
@@ -0,0 +1,189 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// flight is a call shared by several waiters. Its context keep the values of the context of the caller starting it,
+// but is only cancelled once every waiter left.
+type flight[T any] struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	result  T
+	err     error
+	waiters int
+}
+
+func newFlight[T any](ctx context.Context) *flight[T] {
+	flightCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	return &flight[T]{
+		ctx:    flightCtx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+func (f *flight[T]) run(call func(ctx context.Context) (T, error)) {
+	f.result, f.err = call(f.ctx)
+	f.cancel()
+	close(f.done)
+}
+
+// wait for the result of the flight, or until ctx is done. lock must be the one held while adding waiters, and
+// abandon is called with it held when the last waiter leave, before the flight is cancelled.
+func (f *flight[T]) wait(ctx context.Context, lock *sync.Mutex, abandon func()) (T, error) {
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		lock.Lock()
+		f.waiters--
+		if f.waiters == 0 {
+			abandon()
+			f.cancel()
+		}
+		lock.Unlock()
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// flightGroup share the calls made with the same key while one is in flight
+type flightGroup[K comparable, T any] struct {
+	lock    sync.Mutex
+	flights map[K]*flight[T]
+}
+
+func (g *flightGroup[K, T]) do(ctx context.Context, key K, call func(ctx context.Context) (T, error)) (T, error) {
+	g.lock.Lock()
+	if g.flights == nil {
+		g.flights = map[K]*flight[T]{}
+	}
+	f, ok := g.flights[key]
+	if !ok {
+		f = newFlight[T](ctx)
+		g.flights[key] = f
+		go func() {
+			f.run(call)
+			g.forget(key, f)
+		}()
+	}
+	f.waiters++
+	g.lock.Unlock()
+
+	return f.wait(ctx, &g.lock, func() {
+		g.forgetLocked(key, f)
+	})
+}
+
+func (g *flightGroup[K, T]) forget(key K, f *flight[T]) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.forgetLocked(key, f)
+}
+
+// forgetLocked remove f so that later calls start a new flight, unless it was already replaced
+func (g *flightGroup[K, T]) forgetLocked(key K, f *flight[T]) {
+	if g.flights[key] == f {
+		delete(g.flights, key)
+	}
+}
+
+type intRangeQuery struct {
+	from int64
+	to   int64
+}
+
+type coalescingIntRangeCounter struct {
+	counter IntRangeCounter
+	flights flightGroup[intRangeQuery, int64]
+}
+
+// NewCoalescingIntRangeCounter share a QuerySum between the identical queries made while it is in flight, so that
+// they cost a single query to counter. As a query can join one which started earlier, it may not see the increments
+// made in between. The shared query is only cancelled once all the queries sharing it are.
+// The returned counter is a ConditionalIntRangeCounter if counter is, increments are not coalesced.
+func NewCoalescingIntRangeCounter(counter IntRangeCounter) IntRangeCounter {
+	coalescing := &coalescingIntRangeCounter{counter: counter}
+	if conditional, ok := counter.(ConditionalIntRangeCounter); ok {
+		return &coalescingConditionalIntRangeCounter{coalescing, conditional}
+	}
+	return coalescing
+}
+
+func (c *coalescingIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	return c.flights.do(ctx, intRangeQuery{from, to}, func(ctx context.Context) (int64, error) {
+		return c.counter.QuerySum(ctx, from, to)
+	})
+}
+
+func (c *coalescingIntRangeCounter) Increment(ctx context.Context, at int64, by int64) error {
+	return c.counter.Increment(ctx, at, by)
+}
+
+func (c *coalescingIntRangeCounter) String() string {
+	return "coalescing(" + counterString(c.counter) + ")"
+}
+
+type coalescingConditionalIntRangeCounter struct {
+	*coalescingIntRangeCounter
+	conditional ConditionalIntRangeCounter
+}
+
+func (c *coalescingConditionalIntRangeCounter) IncrementIfBelow(ctx context.Context, at int64, by int64, from, to int64, limit int64) (bool, int64, error) {
+	return c.conditional.IncrementIfBelow(ctx, at, by, from, to, limit)
+}
+
+type dateRangeQuery struct {
+	seconds     int64
+	nanos       int
+	location    *time.Location
+	bucketCount int
+}
+
+type coalescingDateRangeCounter struct {
+	counter DateRangeCounter
+	flights flightGroup[dateRangeQuery, int64]
+}
+
+// NewCoalescingDateRangeCounter is NewCoalescingIntRangeCounter for a DateRangeCounter. Queries are identical if
+// their at is the same instant in the same location.
+func NewCoalescingDateRangeCounter(counter DateRangeCounter) DateRangeCounter {
+	coalescing := &coalescingDateRangeCounter{counter: counter}
+	if conditional, ok := counter.(ConditionalDateRangeCounter); ok {
+		return &coalescingConditionalDateRangeCounter{coalescing, conditional}
+	}
+	return coalescing
+}
+
+func (c *coalescingDateRangeCounter) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+	query := dateRangeQuery{
+		seconds:     at.Unix(),
+		nanos:       at.Nanosecond(),
+		location:    at.Location(),
+		bucketCount: bucketCount,
+	}
+	return c.flights.do(ctx, query, func(ctx context.Context) (int64, error) {
+		return c.counter.QuerySum(ctx, at, bucketCount)
+	})
+}
+
+func (c *coalescingDateRangeCounter) Increment(ctx context.Context, at time.Time, by int64) error {
+	return c.counter.Increment(ctx, at, by)
+}
+
+func (c *coalescingDateRangeCounter) String() string {
+	return "coalescing(" + counterString(c.counter) + ")"
+}
+
+type coalescingConditionalDateRangeCounter struct {
+	*coalescingDateRangeCounter
+	conditional ConditionalDateRangeCounter
+}
+
+func (c *coalescingConditionalDateRangeCounter) IncrementIfBelow(ctx context.Context, at time.Time, by int64, window int, limit int64) (bool, int64, error) {
+	return c.conditional.IncrementIfBelow(ctx, at, by, window, limit)
+}
@@ -0,0 +1,142 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingIntRangeCounter count the queries to counter, which wait for release
+type blockingIntRangeCounter struct {
+	IntRangeCounter
+	queries atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	b.queries.Add(1)
+	b.started <- struct{}{}
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	return b.IntRangeCounter.QuerySum(ctx, from, to)
+}
+
+func TestCoalescingIntRangeCounter(t *testing.T) {
+	ctx := context.Background()
+	inner := &blockingIntRangeCounter{
+		IntRangeCounter: NewRangeTreeIntCounter(NewInMemoryBackend(), 8, 2),
+		started:         make(chan struct{}, 10),
+		release:         make(chan struct{}),
+	}
+	counter := NewCoalescingIntRangeCounter(inner)
+	assert.NoError(t, counter.Increment(ctx, 5, 3))
+
+	sums := make([]int64, 5)
+	wg := sync.WaitGroup{}
+	query := func(i int, from, to int64) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sum, err := counter.QuerySum(ctx, from, to)
+			assert.NoError(t, err)
+			sums[i] = sum
+		}()
+	}
+
+	query(0, 0, 10)
+	<-inner.started
+	for i := 1; i < 4; i++ {
+		query(i, 0, 10)
+	}
+	query(4, 6, 10)
+	<-inner.started
+	// let the identical queries join the one in flight
+	time.Sleep(10 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+
+	assert.Equal(t, []int64{3, 3, 3, 3, 0}, sums)
+	assert.EqualValues(t, 2, inner.queries.Load())
+
+	sum, err := counter.QuerySum(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, sum)
+	assert.EqualValues(t, 3, inner.queries.Load(), "a query after the flight landed start a new one")
+
+	_, ok := counter.(ConditionalIntRangeCounter)
+	assert.False(t, ok, "the blocking counter is not conditional")
+	_, ok = NewCoalescingIntRangeCounter(NewRangeTreeIntCounter(NewInMemoryBackend(), 8, 2)).(ConditionalIntRangeCounter)
+	assert.True(t, ok)
+}
+
+func TestCoalescingIntRangeCounterCancel(t *testing.T) {
+	inner := &blockingIntRangeCounter{
+		IntRangeCounter: NewRangeTreeIntCounter(NewInMemoryBackend(), 8, 2),
+		started:         make(chan struct{}, 10),
+		release:         make(chan struct{}),
+	}
+	counter := NewCoalescingIntRangeCounter(inner)
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := counter.QuerySum(firstCtx, 0, 10)
+		errs <- err
+	}()
+	<-inner.started
+	go func() {
+		_, err := counter.QuerySum(secondCtx, 0, 10)
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancelFirst()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case err := <-errs:
+		assert.Fail(t, "the flight is cancelled with the first query", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancelSecond()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	go func() {
+		_, err := counter.QuerySum(context.Background(), 0, 10)
+		errs <- err
+	}()
+	<-inner.started
+	close(inner.release)
+	assert.NoError(t, <-errs, "an abandoned flight is not joined")
+}
+
+func TestCoalescingDateRangeCounter(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	counter := NewCoalescingDateRangeCounter(NewBasicDateCounter(Minute, NewInMemoryBackend()))
+	assert.NoError(t, counter.Increment(ctx, at, 2))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sum, err := counter.QuerySum(ctx, at.Add(time.Duration(i%2)*time.Minute), 1)
+			assert.NoError(t, err)
+			assert.EqualValues(t, 2*(1-i%2), sum)
+		}()
+	}
+	wg.Wait()
+
+	_, ok := counter.(ConditionalDateRangeCounter)
+	assert.True(t, ok)
+}
@@ -0,0 +1,58 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+type lockingConditionalBackend[V Value] struct {
+	GenericBackend[V]
+	lock sync.Mutex
+}
+
+// NewLockingConditionalBackend make backend a GenericConditionalIncrementBackend by serializing the conditional
+// increments made through the returned backend with its own lock, reading the sum then incrementing. They are only
+// atomic against each other, not against plain increments nor other processes, so all the conditional counters of a
+// backend in this process should share the returned backend.
+func NewLockingConditionalBackend[V Value](backend GenericBackend[V]) GenericConditionalIncrementBackend[V] {
+	return &lockingConditionalBackend[V]{
+		GenericBackend: backend,
+	}
+}
+
+func (l *lockingConditionalBackend[V]) IncrementIfBelow(ctx context.Context, sumKeys []string, delta V, limit V, keys []string, values []V) (bool, V, error) {
+	return lockedIncrementIfBelow(ctx, &l.lock, l.GenericBackend, sumKeys, delta, limit, keys, values)
+}
+
+// lockedIncrementIfBelow query sumKeys then increment keys if within limit, holding lock
+func lockedIncrementIfBelow[V Value](ctx context.Context, lock *sync.Mutex, backend GenericBackend[V], sumKeys []string, delta V, limit V, keys []string, values []V) (bool, V, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	results, err := backend.Query(ctx, sumKeys)
+	if err != nil {
+		return false, 0, err
+	}
+	sum := V(0)
+	for _, it := range results {
+		sum += it
+	}
+	if sum+delta > limit {
+		return false, sum, nil
+	}
+	err = backend.Increment(ctx, keys, values)
+	if err != nil {
+		return false, sum, err
+	}
+	return true, sum, nil
+}
+
+func incrementIfBelow[V Value](ctx context.Context, backend GenericBackend[V], sumKeys []string, delta V, limit V, keys []string, values []V) (bool, V, error) {
+	cib, ok := backend.(GenericConditionalIncrementBackend[V])
+	if !ok {
+		return false, 0, errors.New("backend is not a ConditionalIncrementBackend, see NewLockingConditionalBackend")
+	}
+	return cib.IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+}
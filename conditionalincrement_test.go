@@ -0,0 +1,103 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConditionalIncrementBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	counterToTest := map[string]func(DateRange, Backend) ConditionalDateRangeCounter{
+		"dateRange": func(dateRange DateRange, backend Backend) ConditionalDateRangeCounter {
+			return NewBasicDateCounter(dateRange, backend)
+		},
+		"intBacked": func(dateRange DateRange, backend Backend) ConditionalDateRangeCounter {
+			return NewIntBackedDateRange(NewBasicIntRangeCounter(backend), dateRange)
+		},
+		"intRangeTreeBacked": func(dateRange DateRange, backend Backend) ConditionalDateRangeCounter {
+			return NewIntBackedDateRange(NewRangeTreeIntCounter(backend, 8, 1), dateRange)
+		},
+		"intRangeTreeBackedToSecond": func(dateRange DateRange, backend Backend) ConditionalDateRangeCounter {
+			return NewIntBackedDateRange(NewIntRangeTranslator(NewRangeTreeIntCounter(backend, 16, 3), dateRange, Seconds), dateRange)
+		},
+	}
+	backendToTest := map[string]func() Backend{
+		"inMemory": func() Backend {
+			return NewInMemoryBackend()
+		},
+		"locking": func() Backend {
+			return NewLockingConditionalBackend[int64](NewBenchmarkBackend())
+		},
+	}
+
+	for counterName, counterFactory := range counterToTest {
+		for backendName, backendFactory := range backendToTest {
+			t.Run("counter "+counterName+" backend "+backendName, func(t *testing.T) {
+				ctx := context.Background()
+				counter := counterFactory(Hour, backendFactory())
+
+				assert.NoError(t, counter.Increment(ctx, Hour.incrementDateForce(-2, baseDate), 5))
+
+				limit := int64(20)
+				attempts := 50
+				appliedCount := make(chan bool, attempts)
+				wg := sync.WaitGroup{}
+				for i := 0; i < attempts; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						applied, _, err := counter.IncrementIfBelow(ctx, baseDate, 1, 3, limit)
+						assert.NoError(t, err)
+						appliedCount <- applied
+					}()
+				}
+				wg.Wait()
+				close(appliedCount)
+
+				applied := 0
+				for it := range appliedCount {
+					if it {
+						applied++
+					}
+				}
+				assert.Equal(t, 15, applied)
+
+				sum, err := counter.QuerySum(ctx, baseDate, 3)
+				assert.NoError(t, err)
+				assert.EqualValues(t, limit, sum)
+
+				// The older increment is out of a window of 2
+				applied2, sum2, err := counter.IncrementIfBelow(ctx, baseDate, 5, 2, limit)
+				assert.NoError(t, err)
+				assert.True(t, applied2)
+				assert.EqualValues(t, 15, sum2)
+			})
+		}
+	}
+}
+
+func TestLockingConditionalBackend(t *testing.T) {
+	ctx := context.Background()
+	_, _, err := NewBasicIntRangeCounter(NewBenchmarkBackend()).IncrementIfBelow(ctx, 1, 1, 0, 1, 1)
+	assert.Error(t, err, "the backend is not conditional")
+
+	backend := NewLockingConditionalBackend[int64](NewBenchmarkBackend())
+	applied, sum, err := backend.IncrementIfBelow(ctx, []string{"a", "b"}, 2, 3, []string{"a"}, []int64{2})
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	assert.EqualValues(t, 0, sum)
+
+	applied, sum, err = backend.IncrementIfBelow(ctx, []string{"a", "b"}, 2, 3, []string{"b"}, []int64{2})
+	assert.NoError(t, err)
+	assert.False(t, applied)
+	assert.EqualValues(t, 2, sum)
+
+	results, err := backend.Query(ctx, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 0}, results)
+}
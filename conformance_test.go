@@ -0,0 +1,104 @@
+package rangecounter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/asdacap/rangecounter/rangecountertest"
+)
+
+func TestBackendConformance(t *testing.T) {
+	backendToTest := map[string]func() rangecounter.Backend{
+		"in-memory": rangecounter.NewInMemoryBackend,
+		"sharded": func() rangecounter.Backend {
+			return rangecounter.NewShardedBackend(map[string]rangecounter.Backend{
+				"a": rangecounter.NewInMemoryBackend(),
+				"b": rangecounter.NewInMemoryBackend(),
+				"c": rangecounter.NewInMemoryBackend(),
+			}, rangecounter.ShardedBackendOptions{})
+		},
+		"replicated": func() rangecounter.Backend {
+			return rangecounter.NewReplicatedBackend([]rangecounter.Backend{
+				rangecounter.NewInMemoryBackend(),
+				rangecounter.NewInMemoryBackend(),
+				rangecounter.NewInMemoryBackend(),
+			}, rangecounter.ReplicatedBackendOptions{ReadRepair: true})
+		},
+		"pn-counter": func() rangecounter.Backend {
+			return rangecounter.NewPNCounterBackend("a", []string{"b"}, rangecounter.NewInMemoryBackend().(rangecounter.ExtremumBackend), nil, nil)
+		},
+		"hash-tagged": func() rangecounter.Backend {
+			return rangecounter.NewHashTaggedBackend(rangecounter.NewInMemoryBackend(), "tag")
+		},
+		"batching": func() rangecounter.Backend {
+			return rangecounter.NewBatchingBackend(rangecounter.NewInMemoryBackend(), rangecounter.BatchingBackendOptions{Window: time.Microsecond})
+		},
+	}
+	for name, factory := range backendToTest {
+		t.Run(name, func(t *testing.T) {
+			rangecountertest.TestBackend(t, factory)
+		})
+	}
+}
+
+func TestIntRangeCounterConformance(t *testing.T) {
+	counterToTest := map[string]func() rangecounter.IntRangeCounter{
+		"basic": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewBasicIntRangeCounter(rangecounter.NewInMemoryBackend())
+		},
+		"tree-1-1": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 1, 1)
+		},
+		"tree-8-1": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 8, 1)
+		},
+		"tree-4-4": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 4, 4)
+		},
+		"hybrid-8-1": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewHybridIntRangeCounter(rangecounter.NewInMemoryBackend(), 8, 1, []int{2, 4, 6})
+		},
+		"prefix-sum": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewPrefixSumIntRangeCounter(rangecounter.NewInMemoryBackend(), "prefixsum", nil)
+		},
+		"prefix-sum-overlay": func() rangecounter.IntRangeCounter {
+			backend := rangecounter.NewInMemoryBackend()
+			return rangecounter.NewPrefixSumIntRangeCounter(backend, "prefixsum", rangecounter.NewRangeTreeIntCounter(backend, 8, 1))
+		},
+		"coalescing-tree-8-1": func() rangecounter.IntRangeCounter {
+			return rangecounter.NewCoalescingIntRangeCounter(rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 8, 1))
+		},
+	}
+	for name, factory := range counterToTest {
+		t.Run(name, func(t *testing.T) {
+			rangecountertest.TestIntRangeCounter(t, factory)
+		})
+	}
+}
+
+func TestDateRangeCounterConformance(t *testing.T) {
+	counterToTest := map[string]func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter{
+		"basic": func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter {
+			return rangecounter.NewBasicDateCounter(dateRange, rangecounter.NewInMemoryBackend())
+		},
+		"int-backed-tree-8-1": func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter {
+			return rangecounter.NewIntBackedDateRange(rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 8, 1), dateRange)
+		},
+		"tree-16-1-to-second": func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter {
+			return rangecounter.NewIntBackedDateRange(rangecounter.NewIntRangeTranslator(rangecounter.NewRangeTreeIntCounter(rangecounter.NewInMemoryBackend(), 16, 1), dateRange, rangecounter.Seconds), dateRange)
+		},
+		"prefix-sum-overlay": func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter {
+			backend := rangecounter.NewInMemoryBackend()
+			return rangecounter.NewIntBackedDateRange(rangecounter.NewPrefixSumIntRangeCounter(backend, "prefixsum", rangecounter.NewBasicIntRangeCounter(backend)), dateRange)
+		},
+		"coalescing-basic": func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter {
+			return rangecounter.NewCoalescingDateRangeCounter(rangecounter.NewBasicDateCounter(dateRange, rangecounter.NewInMemoryBackend()))
+		},
+	}
+	for name, factory := range counterToTest {
+		t.Run(name, func(t *testing.T) {
+			rangecountertest.TestDateRangeCounter(t, factory)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package rangecounter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DateLayout determine which keys a date based counter write to on increment, and read from on query.
+// Counters that store something other than a plain sum, such as a sketch, use it so that they can be laid out
+// either as a bucket per range, or as a range tree over the range index.
+type DateLayout interface {
+	// incrementKeys returns the keys that has to be updated for an event at `at`
+	incrementKeys(at time.Time) ([]string, error)
+	// queryKeys returns the keys which together cover the bucketCount buckets before `at` (inclusive)
+	queryKeys(at time.Time, bucketCount int) ([]string, error)
+	// KeySpan returns the time covered by one of its keys, see DateKeySpanner
+	KeySpan(key string) (time.Time, time.Time, bool)
+	String() string
+}
+
+// NewBucketDateLayout lay out a key per bucket of drange, like NewBasicDateCounter
+func NewBucketDateLayout(drange DateRange) DateLayout {
+	return bucketDateLayout{
+		drange: drange,
+	}
+}
+
+// NewRangeTreeDateLayout lay out a range tree over the index of drange, like NewRangeTreeIntCounter
+// backed NewIntBackedDateRange
+func NewRangeTreeDateLayout(drange DateRange, heightLimit int, bitLength uint) DateLayout {
+	if heightLimit <= 0 {
+		panic("heightLimit must be nonzero")
+	}
+	return treeDateLayout{
+		drange: drange,
+		tree: rangeTreeLayout{
+			heightLimit: heightLimit,
+			bitLength:   bitLength,
+		},
+	}
+}
+
+type bucketDateLayout struct {
+	drange DateRange
+	prefix string
+}
+
+func (b bucketDateLayout) incrementKeys(at time.Time) ([]string, error) {
+	at, err := b.drange.alignDate(at)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to align date")
+	}
+	return []string{b.getKey(at)}, nil
+}
+
+// queryKeys returns the keys of the bucketCount buckets before at, newest first
+func (b bucketDateLayout) queryKeys(at time.Time, bucketCount int) ([]string, error) {
+	at, err := b.drange.alignDate(at)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to align date")
+	}
+
+	keys := []string{}
+	for i := 0; i < bucketCount; i++ {
+		keys = append(keys, b.getKey(at))
+		at, err = b.drange.incrementDate(-1, at)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decrement date")
+		}
+	}
+	return keys, nil
+}
+
+func (b bucketDateLayout) getKey(at time.Time) string {
+	return fmt.Sprintf("%v%v:%v", b.prefix, b.drange, at.Unix())
+}
+
+func (b bucketDateLayout) KeySpan(key string) (time.Time, time.Time, bool) {
+	prefix := fmt.Sprintf("%v%v:", b.prefix, b.drange)
+	if !strings.HasPrefix(key, prefix) {
+		return time.Time{}, time.Time{}, false
+	}
+	unix, err := strconv.ParseInt(key[len(prefix):], 10, 64)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	start := time.Unix(unix, 0).UTC()
+	return start, start.Add(b.drange.getDuration()), true
+}
+
+func (b bucketDateLayout) String() string {
+	return fmt.Sprintf("bucket(%v)", b.drange)
+}
+
+type treeDateLayout struct {
+	drange DateRange
+	tree   rangeTreeLayout
+}
+
+func (t treeDateLayout) incrementKeys(at time.Time) ([]string, error) {
+	return t.tree.incrementKeys(t.drange.toIndex(at)), nil
+}
+
+func (t treeDateLayout) queryKeys(at time.Time, bucketCount int) ([]string, error) {
+	if bucketCount <= 0 {
+		return []string{}, nil
+	}
+	endIndex := t.drange.toIndex(at)
+	return t.tree.determineSumKeys(endIndex-int64(bucketCount)+1, endIndex), nil
+}
+
+func (t treeDateLayout) KeySpan(key string) (time.Time, time.Time, bool) {
+	from, to, ok := t.tree.KeySpan(key)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return indexSpanTime(t.drange, from, to)
+}
+
+func (t treeDateLayout) String() string {
+	return fmt.Sprintf("tree(%v, %v-%v)", t.drange, t.tree.heightLimit, t.tree.bitLength)
+}
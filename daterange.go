@@ -49,6 +49,11 @@ func (drange DateRange) String() string {
 	return "unknown range"
 }
 
+// toIndex is the number of drange since unix epoch
+func (drange DateRange) toIndex(at time.Time) int64 {
+	return at.UnixNano() / drange.getDuration().Nanoseconds()
+}
+
 func (drange DateRange) getDuration() time.Duration {
 	switch drange {
 	case Seconds:
@@ -0,0 +1,221 @@
+package rangecounter
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ddSketchMinIndexable is the smallest magnitude tracked in a bin, anything smaller is counted as zero
+const ddSketchMinIndexable = 1e-9
+
+// ddSketch is a DDSketch, which keep a count per logarithmically sized bin so that any quantile is estimated within
+// a relative accuracy. Sketches of the same accuracy merge by adding their bins.
+type ddSketch struct {
+	gamma     float64
+	logGamma  float64
+	zeroCount uint64
+	positive  map[int32]uint64
+	negative  map[int32]uint64
+}
+
+func newDDSketch(relativeAccuracy float64) *ddSketch {
+	return newDDSketchWithGamma((1 + relativeAccuracy) / (1 - relativeAccuracy))
+}
+
+func newDDSketchWithGamma(gamma float64) *ddSketch {
+	return &ddSketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: map[int32]uint64{},
+		negative: map[int32]uint64{},
+	}
+}
+
+func (d *ddSketch) index(value float64) (int32, error) {
+	index := math.Ceil(math.Log(value) / d.logGamma)
+	if index < math.MinInt32 || index > math.MaxInt32 {
+		return 0, errors.Errorf("value %v is out of the range of the sketch", value)
+	}
+	return int32(index), nil
+}
+
+// binValue is the value a bin report, which is within the relative accuracy of any value in the bin
+func (d *ddSketch) binValue(index int32) float64 {
+	// the bin of the largest floats can end above math.MaxFloat64
+	return math.Min(2/(d.gamma+1)*math.Pow(d.gamma, float64(index)), math.MaxFloat64)
+}
+
+// add value, which must be finite
+func (d *ddSketch) add(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return errors.Errorf("unable to add %v, only finite values can be", value)
+	}
+	switch {
+	case value > ddSketchMinIndexable:
+		index, err := d.index(value)
+		if err != nil {
+			return err
+		}
+		d.positive[index]++
+	case value < -ddSketchMinIndexable:
+		index, err := d.index(-value)
+		if err != nil {
+			return err
+		}
+		d.negative[index]++
+	default:
+		d.zeroCount++
+	}
+	return nil
+}
+
+func (d *ddSketch) count() uint64 {
+	count := d.zeroCount
+	for _, binCount := range d.positive {
+		count += binCount
+	}
+	for _, binCount := range d.negative {
+		count += binCount
+	}
+	return count
+}
+
+// quantiles returns the value at each quantile, or NaN if the sketch is empty
+func (d *ddSketch) quantiles(quantiles []float64) []float64 {
+	type bin struct {
+		value float64
+		count uint64
+	}
+
+	bins := make([]bin, 0, len(d.positive)+len(d.negative)+1)
+	for index, binCount := range d.negative {
+		bins = append(bins, bin{-d.binValue(index), binCount})
+	}
+	if d.zeroCount > 0 {
+		bins = append(bins, bin{0, d.zeroCount})
+	}
+	for index, binCount := range d.positive {
+		bins = append(bins, bin{d.binValue(index), binCount})
+	}
+	sort.Slice(bins, func(i, j int) bool {
+		return bins[i].value < bins[j].value
+	})
+
+	total := d.count()
+	results := make([]float64, len(quantiles))
+	for i, quantile := range quantiles {
+		if total == 0 {
+			results[i] = math.NaN()
+			continue
+		}
+
+		rank := uint64(math.Max(math.Min(quantile, 1), 0) * float64(total-1))
+		cumulative := uint64(0)
+		for _, b := range bins {
+			cumulative += b.count
+			if cumulative > rank {
+				results[i] = b.value
+				break
+			}
+		}
+	}
+	return results
+}
+
+// marshal encode the sketch as its gamma, zero count, then the positive and negative bins as varint pairs
+func (d *ddSketch) marshal() []byte {
+	encoded := make([]byte, 8, 8+binary.MaxVarintLen64*(1+2+2*(len(d.positive)+len(d.negative))))
+	binary.BigEndian.PutUint64(encoded, math.Float64bits(d.gamma))
+	encoded = binary.AppendUvarint(encoded, d.zeroCount)
+	for _, bins := range []map[int32]uint64{d.positive, d.negative} {
+		encoded = binary.AppendUvarint(encoded, uint64(len(bins)))
+		for index, binCount := range bins {
+			encoded = binary.AppendVarint(encoded, int64(index))
+			encoded = binary.AppendUvarint(encoded, binCount)
+		}
+	}
+	return encoded
+}
+
+// merge the encoded sketch into d
+func (d *ddSketch) merge(encoded []byte) error {
+	if len(encoded) < 8 {
+		return errors.Errorf("ddsketch too short: %v bytes", len(encoded))
+	}
+	gamma := math.Float64frombits(binary.BigEndian.Uint64(encoded))
+	if gamma != d.gamma {
+		return errors.Errorf("unable to merge ddsketch of gamma %v into gamma %v", gamma, d.gamma)
+	}
+
+	reader := ddSketchReader{buffer: encoded[8:]}
+	d.zeroCount += reader.uvarint()
+	for _, bins := range []map[int32]uint64{d.positive, d.negative} {
+		binLength := reader.uvarint()
+		for i := uint64(0); i < binLength && reader.err == nil; i++ {
+			index := reader.varint()
+			bins[int32(index)] += reader.uvarint()
+		}
+	}
+	if reader.err != nil {
+		return reader.err
+	}
+	if len(reader.buffer) != 0 {
+		return errors.Errorf("%v trailing bytes after ddsketch", len(reader.buffer))
+	}
+	return nil
+}
+
+type ddSketchReader struct {
+	buffer []byte
+	err    error
+}
+
+func (r *ddSketchReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.buffer)
+	if n <= 0 {
+		r.err = errors.New("invalid ddsketch varint")
+		return 0
+	}
+	r.buffer = r.buffer[n:]
+	return value
+}
+
+func (r *ddSketchReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.buffer)
+	if n <= 0 {
+		r.err = errors.New("invalid ddsketch varint")
+		return 0
+	}
+	r.buffer = r.buffer[n:]
+	return value
+}
+
+// DDSketchMerge is the BytesMergeFunc for the sketches stored by QuantileRangeCounter.
+// A remote BytesBackend should merge the same way, adding the count of each bin.
+func DDSketchMerge(stored, value []byte) ([]byte, error) {
+	if len(value) < 8 {
+		return nil, errors.Errorf("ddsketch too short: %v bytes", len(value))
+	}
+
+	sketch := newDDSketchWithGamma(math.Float64frombits(binary.BigEndian.Uint64(value)))
+	if stored != nil {
+		err := sketch.merge(stored)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decode stored ddsketch")
+		}
+	}
+	err := sketch.merge(value)
+	if err != nil {
+		return nil, err
+	}
+	return sketch.marshal(), nil
+}
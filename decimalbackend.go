@@ -0,0 +1,54 @@
+package rangecounter
+
+import (
+	"context"
+)
+
+type decimalBackend struct {
+	backend Backend
+}
+
+type decimalConditionalBackend struct {
+	*decimalBackend
+}
+
+// NewDecimalBackend store Decimal values in backend as their int64 units. The returned backend is a
+// GenericConditionalIncrementBackend if backend is a ConditionalIncrementBackend.
+func NewDecimalBackend(backend Backend) GenericBackend[Decimal] {
+	decimal := &decimalBackend{
+		backend: backend,
+	}
+	if _, ok := backend.(ConditionalIncrementBackend); ok {
+		return &decimalConditionalBackend{decimal}
+	}
+	return decimal
+}
+
+func (d *decimalBackend) Query(ctx context.Context, keys []string) ([]Decimal, error) {
+	results, err := d.backend.Query(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	decimals := make([]Decimal, len(results))
+	for i, result := range results {
+		decimals[i] = Decimal(result)
+	}
+	return decimals, nil
+}
+
+func (d *decimalBackend) Increment(ctx context.Context, keys []string, values []Decimal) error {
+	return d.backend.Increment(ctx, keys, units(values))
+}
+
+func (d *decimalConditionalBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta Decimal, limit Decimal, keys []string, values []Decimal) (bool, Decimal, error) {
+	applied, sum, err := d.backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, int64(delta), int64(limit), keys, units(values))
+	return applied, Decimal(sum), err
+}
+
+func units(values []Decimal) []int64 {
+	converted := make([]int64, len(values))
+	for i, value := range values {
+		converted[i] = int64(value)
+	}
+	return converted
+}
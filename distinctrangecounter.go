@@ -0,0 +1,81 @@
+package rangecounter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DistinctRangeCounter count the unique members seen within a range of time.
+// The cardinality is an estimate, with a standard error of about 1.04/sqrt(2^precision).
+type DistinctRangeCounter interface {
+	Add(ctx context.Context, at time.Time, member string) error
+	QueryCardinality(ctx context.Context, at time.Time, bucketCount int) (int64, error)
+}
+
+type hyperLogLogRangeCounter struct {
+	layout    DateLayout
+	backend   BytesBackend
+	precision uint8
+}
+
+// NewDistinctRangeCounter store a hyperloglog sketch per key of the layout. The backend must merge the sketch with
+// HyperLogLogMerge. With a range tree layout, the sketches of a range is merged from O(log n) nodes.
+func NewDistinctRangeCounter(layout DateLayout, backend BytesBackend, precision uint8) DistinctRangeCounter {
+	if precision < hllMinPrecision || precision > hllMaxPrecision {
+		panic("precision must be between 4 and 18")
+	}
+	return &hyperLogLogRangeCounter{
+		layout:    layout,
+		backend:   backend,
+		precision: precision,
+	}
+}
+
+func (h *hyperLogLogRangeCounter) Add(ctx context.Context, at time.Time, member string) error {
+	keys, err := h.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+
+	update := hyperLogLogUpdate(h.precision, member)
+	values := make([][]byte, len(keys))
+	for i := range values {
+		values[i] = update
+	}
+
+	err = h.backend.MergeBytes(ctx, keys, values)
+	if err != nil {
+		return errors.Wrap(err, "unable to merge sketches")
+	}
+	return nil
+}
+
+func (h *hyperLogLogRangeCounter) QueryCardinality(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+	keys, err := h.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return 0, err
+	}
+
+	results, err := h.backend.QueryBytes(ctx, keys)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to query sketches")
+	}
+
+	hll := newHyperLogLog(h.precision)
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		err = hll.merge(result)
+		if err != nil {
+			return 0, errors.Wrapf(err, "unable to merge sketch of key %v", keys[i])
+		}
+	}
+	return hll.estimate(), nil
+}
+
+func (h *hyperLogLogRangeCounter) String() string {
+	return "hyperLogLogRangeCounter(" + h.layout.String() + ")"
+}
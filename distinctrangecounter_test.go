@@ -0,0 +1,80 @@
+package rangecounter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistinctRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type queryReq struct {
+		dateOffset  int
+		bucketCount int
+		expected    int64
+	}
+	queries := []queryReq{
+		{9, 10, 1000},
+		{9, 1, 100},
+		{4, 5, 500},
+		{20, 5, 0},
+		{12, 5, 200},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket":  NewBucketDateLayout(Hour),
+		"tree-1":  NewRangeTreeDateLayout(Hour, 1, 1),
+		"tree-8":  NewRangeTreeDateLayout(Hour, 8, 1),
+		"tree-16": NewRangeTreeDateLayout(Hour, 16, 3),
+	}
+	for layoutName, layout := range layoutToTest {
+		t.Run("layout "+layoutName, func(t *testing.T) {
+			ctx := context.Background()
+			counter := NewDistinctRangeCounter(layout, NewInMemoryBytesBackend(HyperLogLogMerge), 12)
+
+			for round := 0; round < 3; round++ {
+				for i := 0; i < 1000; i++ {
+					err := counter.Add(ctx, Hour.incrementDateForce(i%10, baseDate), fmt.Sprint("user-", i))
+					assert.NoError(t, err)
+				}
+			}
+
+			for _, q := range queries {
+				ans, err := counter.QueryCardinality(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount)
+				assert.NoError(t, err)
+				if q.expected == 0 {
+					assert.EqualValues(t, 0, ans)
+				} else {
+					assert.InEpsilon(t, q.expected, ans, 0.05)
+				}
+			}
+		})
+	}
+}
+
+func TestHyperLogLogMergeValidate(t *testing.T) {
+	valid := hyperLogLogUpdate(12, "member")
+	tests := map[string][]byte{
+		"too short":          {hllDense},
+		"precision too high": {hllDense, 255},
+		"precision too low":  {hllSparse, 0},
+		"dense too short":    append([]byte{hllDense, 4}, make([]byte, 15)...),
+		"dense too long":     append([]byte{hllDense, 4}, make([]byte, 17)...),
+		"sparse truncated":   valid[:len(valid)-1],
+		"unknown encoding":   {9, 12},
+	}
+	for name, value := range tests {
+		_, err := HyperLogLogMerge(nil, value)
+		assert.Error(t, err, name)
+	}
+
+	_, err := HyperLogLogMerge(valid, append([]byte{hllDense, 4}, make([]byte, 16)...))
+	assert.Error(t, err, "precisions differ")
+	merged, err := HyperLogLogMerge(nil, valid)
+	assert.NoError(t, err)
+	assert.Len(t, merged, hllHeaderLength+1<<12)
+}
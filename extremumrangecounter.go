@@ -0,0 +1,140 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ExtremumRangeCounter keep the max and min of the values observed within a range of time, for gauges such as
+// concurrent sessions. The bool returned by QueryMax and QueryMin is false if nothing was observed in the range.
+type ExtremumRangeCounter interface {
+	Observe(ctx context.Context, at time.Time, value int64) error
+	QueryMax(ctx context.Context, at time.Time, bucketCount int) (int64, bool, error)
+	QueryMin(ctx context.Context, at time.Time, bucketCount int) (int64, bool, error)
+}
+
+type extremumRangeCounter struct {
+	layout  DateLayout
+	backend ExtremumBackend
+}
+
+// NewExtremumRangeCounter store the max and min per key of the layout. With a range tree layout, each node
+// hold the max of its children, like a segment tree. The min is stored as the max of the negated value, so
+// Observe returns an error for math.MinInt64.
+func NewExtremumRangeCounter(layout DateLayout, backend ExtremumBackend) ExtremumRangeCounter {
+	return &extremumRangeCounter{
+		layout:  layout,
+		backend: backend,
+	}
+}
+
+func (e *extremumRangeCounter) Observe(ctx context.Context, at time.Time, value int64) error {
+	if value == math.MinInt64 {
+		return errors.New("unable to observe math.MinInt64, its negation overflow")
+	}
+	keys, err := e.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+
+	updateKeys := make([]string, 0, len(keys)*2)
+	values := make([]int64, 0, len(keys)*2)
+	for _, key := range keys {
+		updateKeys = append(updateKeys, "max"+key, "min"+key)
+		values = append(values, value, -value)
+	}
+
+	err = e.backend.UpdateMax(ctx, updateKeys, values)
+	if err != nil {
+		return errors.Wrap(err, "unable to update max")
+	}
+	return nil
+}
+
+func (e *extremumRangeCounter) QueryMax(ctx context.Context, at time.Time, bucketCount int) (int64, bool, error) {
+	return e.queryMax(ctx, "max", at, bucketCount)
+}
+
+func (e *extremumRangeCounter) QueryMin(ctx context.Context, at time.Time, bucketCount int) (int64, bool, error) {
+	negatedMin, found, err := e.queryMax(ctx, "min", at, bucketCount)
+	return -negatedMin, found, err
+}
+
+func (e *extremumRangeCounter) queryMax(ctx context.Context, prefix string, at time.Time, bucketCount int) (int64, bool, error) {
+	keys, err := e.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return 0, false, err
+	}
+	for i := range keys {
+		keys[i] = prefix + keys[i]
+	}
+
+	results, exists, err := queryMaxBackend(ctx, e.backend, keys)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "unable to query max")
+	}
+
+	extremum := int64(0)
+	found := false
+	for i, result := range results {
+		if exists[i] && (!found || result > extremum) {
+			extremum = result
+			found = true
+		}
+	}
+	return extremum, found, nil
+}
+
+func (e *extremumRangeCounter) String() string {
+	return "extremumRangeCounter(" + e.layout.String() + ")"
+}
+
+type compareAndSetExtremumBackend struct {
+	backend CompareAndSetBackend
+}
+
+// NewCompareAndSetExtremumBackend implements UpdateMax with a compare-and-set loop per key, for stores without an
+// atomic max. It retries until the stored value is at least the new value, or the context is done.
+func NewCompareAndSetExtremumBackend(backend CompareAndSetBackend) ExtremumBackend {
+	return &compareAndSetExtremumBackend{
+		backend: backend,
+	}
+}
+
+func (c *compareAndSetExtremumBackend) QueryMax(ctx context.Context, keys []string) ([]int64, []bool, error) {
+	return c.backend.Get(ctx, keys)
+}
+
+func (c *compareAndSetExtremumBackend) UpdateMax(ctx context.Context, keys []string, values []int64) error {
+	currents, exists, err := getBackend(ctx, c.backend, keys)
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		current, currentExists := currents[i], exists[i]
+		for !currentExists || values[i] > current {
+			if ctx.Err() != nil {
+				return errors.Wrapf(ctx.Err(), "unable to update max of key %v", key)
+			}
+
+			swapped, err := c.backend.CompareAndSet(ctx, key, current, currentExists, values[i])
+			if err != nil {
+				return err
+			}
+			if swapped {
+				break
+			}
+
+			refreshed, refreshedExists, err := getBackend(ctx, c.backend, []string{key})
+			if err != nil {
+				return err
+			}
+			current, currentExists = refreshed[0], refreshedExists[0]
+		}
+	}
+	return nil
+}
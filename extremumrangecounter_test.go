@@ -0,0 +1,133 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtremumRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type inputReq struct {
+		dateOffset int
+		value      int64
+	}
+	type queryReq struct {
+		dateOffset  int
+		bucketCount int
+		found       bool
+		max         int64
+		min         int64
+	}
+	inputs := []inputReq{
+		{0, 5},
+		{0, -3},
+		{1, 10},
+		{3, 7},
+		{3, 2},
+		{17, -20},
+	}
+	queries := []queryReq{
+		{0, 1, true, 5, -3},
+		{1, 1, true, 10, 10},
+		{1, 2, true, 10, -3},
+		{2, 1, false, 0, 0},
+		{3, 2, true, 7, 2},
+		{20, 20, true, 10, -20},
+		{12, 10, true, 7, 2},
+		{40, 10, false, 0, 0},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket":  NewBucketDateLayout(Hour),
+		"tree-8":  NewRangeTreeDateLayout(Hour, 8, 1),
+		"tree-16": NewRangeTreeDateLayout(Hour, 16, 3),
+	}
+	backendToTest := map[string]func() ExtremumBackend{
+		"inMemory": func() ExtremumBackend {
+			return NewInMemoryBackend().(ExtremumBackend)
+		},
+		"compareAndSet": func() ExtremumBackend {
+			return NewCompareAndSetExtremumBackend(NewInMemoryBackend().(CompareAndSetBackend))
+		},
+	}
+	for layoutName, layout := range layoutToTest {
+		for backendName, backendFactory := range backendToTest {
+			t.Run("layout "+layoutName+" backend "+backendName, func(t *testing.T) {
+				ctx := context.Background()
+				counter := NewExtremumRangeCounter(layout, backendFactory())
+
+				wg := sync.WaitGroup{}
+				for _, inp := range inputs {
+					wg.Add(1)
+					go func(inp inputReq) {
+						defer wg.Done()
+						err := counter.Observe(ctx, Hour.incrementDateForce(inp.dateOffset, baseDate), inp.value)
+						assert.NoError(t, err)
+					}(inp)
+				}
+				wg.Wait()
+
+				for _, q := range queries {
+					at := Hour.incrementDateForce(q.dateOffset, baseDate)
+					max, found, err := counter.QueryMax(ctx, at, q.bucketCount)
+					assert.NoError(t, err)
+					assert.Equal(t, q.found, found)
+					assert.Equal(t, q.max, max)
+
+					min, found, err := counter.QueryMin(ctx, at, q.bucketCount)
+					assert.NoError(t, err)
+					assert.Equal(t, q.found, found)
+					assert.Equal(t, q.min, min)
+				}
+			})
+		}
+	}
+}
+
+func TestExtremumRangeCounterLimits(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	counter := NewExtremumRangeCounter(NewBucketDateLayout(Hour), NewInMemoryBackend().(ExtremumBackend))
+
+	assert.Error(t, counter.Observe(ctx, at, math.MinInt64))
+	assert.NoError(t, counter.Observe(ctx, at, math.MinInt64+1))
+	assert.NoError(t, counter.Observe(ctx, at, math.MaxInt64))
+
+	min, found, err := counter.QueryMin(ctx, at, 1)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.EqualValues(t, math.MinInt64+1, min)
+	max, _, err := counter.QueryMax(ctx, at, 1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, math.MaxInt64, max)
+}
+
+// shortExtremumBackend returns one result less than the keys queried
+type shortExtremumBackend struct {
+	*inMemoryBackend[int64]
+}
+
+func (s shortExtremumBackend) QueryMax(ctx context.Context, keys []string) ([]int64, []bool, error) {
+	return s.inMemoryBackend.QueryMax(ctx, keys[1:])
+}
+
+func (s shortExtremumBackend) Get(ctx context.Context, keys []string) ([]int64, []bool, error) {
+	return s.inMemoryBackend.Get(ctx, keys[1:])
+}
+
+func TestExtremumRangeCounterShortResults(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	backend := shortExtremumBackend{NewInMemoryBackend().(*inMemoryBackend[int64])}
+
+	_, _, err := NewExtremumRangeCounter(NewBucketDateLayout(Hour), backend).QueryMax(ctx, at, 2)
+	assert.Error(t, err)
+	err = NewExtremumRangeCounter(NewBucketDateLayout(Hour), NewCompareAndSetExtremumBackend(backend)).Observe(ctx, at, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package rangecounter
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInjectedFault can be used as the error of an injected Fault.
+var ErrInjectedFault = errors.New("injected fault")
+
+// Fault is what a fault injecting backend do to a call. The zero Fault let the call through untouched.
+type Fault struct {
+	// Latency delay the call, or until the context is done
+	Latency time.Duration
+	// Err is returned by the call. A failed query or increment does not reach the backend, unless SkipKeys
+	// partially apply the increment.
+	Err error
+	// SkipKeys apply an increment without its last SkipKeys keys, which for a tree path are the lower levels
+	SkipKeys int
+	// DropWrite report an increment as successful without applying it
+	DropWrite bool
+	// TruncateResults drop the last TruncateResults results of a query. The counters and backends which index the
+	// results by key return an error, while those only summing them lose the dropped values.
+	TruncateResults int
+}
+
+// FaultCall describe a call to a fault injecting backend.
+type FaultCall struct {
+	// Seq is the number of calls made before this one
+	Seq int
+	// Op is IncrementOperation or QueryOperation
+	Op   string
+	Keys []string
+}
+
+// FaultPolicy decide the fault of each call. It is called with the backend locked, so it need not be safe for
+// concurrent use.
+type FaultPolicy func(call FaultCall) Fault
+
+// FaultSchedule inject faults[i] on the call with Seq i, and no fault after the schedule.
+func FaultSchedule(faults ...Fault) FaultPolicy {
+	return func(call FaultCall) Fault {
+		if call.Seq < len(faults) {
+			return faults[call.Seq]
+		}
+		return Fault{}
+	}
+}
+
+// FaultProbability inject fault on each call with the given probability. The same seed inject on the same calls.
+func FaultProbability(probability float64, fault Fault, seed int64) FaultPolicy {
+	random := rand.New(rand.NewSource(seed))
+	return func(call FaultCall) Fault {
+		if random.Float64() < probability {
+			return fault
+		}
+		return Fault{}
+	}
+}
+
+type faultInjectingBackend struct {
+	backend Backend
+	policy  FaultPolicy
+
+	lock sync.Mutex
+	seq  int
+}
+
+// NewFaultInjectingBackend inject the faults decided by policy into the calls to backend, for testing how counters
+// and services behave when the backend misbehave. The returned backend is only a Backend, so that QueryIncrement and
+// IncrementIfBelow also go through the faulty Query and Increment.
+func NewFaultInjectingBackend(backend Backend, policy FaultPolicy) Backend {
+	return &faultInjectingBackend{
+		backend: backend,
+		policy:  policy,
+	}
+}
+
+func (f *faultInjectingBackend) fault(ctx context.Context, op string, keys []string) (Fault, error) {
+	f.lock.Lock()
+	fault := f.policy(FaultCall{
+		Seq:  f.seq,
+		Op:   op,
+		Keys: keys,
+	})
+	f.seq++
+	f.lock.Unlock()
+
+	if fault.Latency > 0 {
+		if err := sleepContext(ctx, fault.Latency); err != nil {
+			return fault, err
+		}
+	}
+	return fault, nil
+}
+
+func (f *faultInjectingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	fault, err := f.fault(ctx, QueryOperation, keys)
+	if err != nil {
+		return nil, err
+	}
+	if fault.Err != nil {
+		return nil, fault.Err
+	}
+
+	results, err := f.backend.Query(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	if fault.TruncateResults > 0 {
+		keep := len(results) - fault.TruncateResults
+		if keep < 0 {
+			keep = 0
+		}
+		results = results[:keep]
+	}
+	return results, nil
+}
+
+func (f *faultInjectingBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	fault, err := f.fault(ctx, IncrementOperation, keys)
+	if err != nil {
+		return err
+	}
+	if fault.DropWrite {
+		return fault.Err
+	}
+
+	if fault.SkipKeys > 0 {
+		keep := len(keys) - fault.SkipKeys
+		if keep < 0 {
+			keep = 0
+		}
+		keys, values = keys[:keep], values[:keep]
+	} else if fault.Err != nil {
+		return fault.Err
+	}
+
+	if len(keys) > 0 {
+		if err := f.backend.Increment(ctx, keys, values); err != nil {
+			return err
+		}
+	}
+	return fault.Err
+}
@@ -0,0 +1,167 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultInjectingBackendTreeConsistency(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		fault    Fault
+		err      bool
+		pointSum int64
+		rangeSum int64
+	}{
+		{
+			name:     "no fault",
+			pointSum: 2,
+			rangeSum: 2,
+		},
+		{
+			name:     "error",
+			fault:    Fault{Err: ErrInjectedFault},
+			err:      true,
+			pointSum: 1,
+			rangeSum: 1,
+		},
+		{
+			name:     "dropped write",
+			fault:    Fault{DropWrite: true},
+			pointSum: 1,
+			rangeSum: 1,
+		},
+		{
+			name:     "partial increment",
+			fault:    Fault{SkipKeys: 2, Err: ErrInjectedFault},
+			err:      true,
+			pointSum: 1,
+			rangeSum: 2,
+		},
+		{
+			name:     "silent partial increment",
+			fault:    Fault{SkipKeys: 1},
+			pointSum: 1,
+			rangeSum: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			counter := NewRangeTreeIntCounter(NewFaultInjectingBackend(NewInMemoryBackend(),
+				FaultSchedule(Fault{}, test.fault)), 4, 1)
+
+			assert.NoError(t, counter.Increment(ctx, 5, 1))
+			err := counter.Increment(ctx, 5, 1)
+			assert.Equal(t, test.err, err != nil, "error %v", err)
+
+			sum, err := counter.QuerySum(ctx, 5, 5)
+			assert.NoError(t, err)
+			assert.Equal(t, test.pointSum, sum, "the leaf")
+			sum, err = counter.QuerySum(ctx, 3, 8)
+			assert.NoError(t, err)
+			assert.Equal(t, test.rangeSum, sum, "a range reading the upper levels")
+		})
+	}
+}
+
+func TestFaultInjectingBackendQuery(t *testing.T) {
+	ctx := context.Background()
+	counter := NewBasicIntRangeCounter(NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+		if call.Op == QueryOperation {
+			return Fault{TruncateResults: 2}
+		}
+		return Fault{}
+	}))
+
+	for i := int64(0); i < 5; i++ {
+		assert.NoError(t, counter.Increment(ctx, i, 1))
+	}
+	sum, err := counter.QuerySum(ctx, 0, 4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, sum, "the last two buckets are lost")
+}
+
+func TestTruncatedResults(t *testing.T) {
+	ctx := context.Background()
+	truncating := func() Backend {
+		return NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+			return Fault{TruncateResults: 1}
+		})
+	}
+	neverClosed := func(key string) (time.Time, time.Time, bool) {
+		return time.Time{}, time.Time{}, false
+	}
+	backends := map[string]Backend{
+		"batching": NewBatchingBackend(truncating(), BatchingBackendOptions{}),
+		"caching":  NewClosedKeyCachingBackend(truncating(), ClosedKeyCacheOptions{KeySpan: neverClosed}),
+		"sharded":  NewShardedBackend(map[string]Backend{"a": truncating()}, ShardedBackendOptions{}),
+		"tiered":   NewTieredBackend(truncating(), truncating(), TieredBackendOptions{KeySpan: neverClosed}),
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			_, err := backend.Query(ctx, []string{"a", "b"})
+			assert.Error(t, err)
+		})
+	}
+
+	limiter := NewRateLimiter(truncating(), SlidingWindowBuckets, Minute, 10, 5)
+	_, err := limiter.Allow(ctx, "a", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), 1)
+	assert.Error(t, err)
+}
+
+func TestFaultInjectingBackendLatency(t *testing.T) {
+	backend := NewFaultInjectingBackend(NewInMemoryBackend(), FaultSchedule(Fault{Latency: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := backend.Query(ctx, []string{"a"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	_, err = backend.Query(context.Background(), []string{"a"})
+	assert.NoError(t, err, "the schedule is over")
+}
+
+func TestFaultProbability(t *testing.T) {
+	ctx := context.Background()
+	failures := func() []int {
+		backend := NewFaultInjectingBackend(NewInMemoryBackend(), FaultProbability(0.2, Fault{Err: ErrInjectedFault}, 42))
+		failed := []int{}
+		for i := 0; i < 1000; i++ {
+			if _, err := backend.Query(ctx, []string{"a"}); err != nil {
+				failed = append(failed, i)
+			}
+		}
+		return failed
+	}
+
+	first := failures()
+	assert.Equal(t, first, failures(), "the same seed fail the same calls")
+	assert.InDelta(t, 200, len(first), 50)
+}
+
+func TestFaultInjectingBackendWithResilientBackend(t *testing.T) {
+	ctx := context.Background()
+	faulty := NewFaultInjectingBackend(NewInMemoryBackend(), FaultSchedule(
+		Fault{Err: ErrInjectedFault},
+		Fault{Err: ErrInjectedFault},
+	))
+	counter := NewRangeTreeIntCounter(NewResilientBackend(faulty, ResilientBackendOptions{
+		QueryRetries:     2,
+		IncrementRetries: 2,
+		RetryIncrementIf: func(err error) bool {
+			return err == ErrInjectedFault
+		},
+		sleep: noSleep,
+	}), 4, 1)
+
+	assert.NoError(t, counter.Increment(ctx, 3, 1), "retried past the injected errors")
+	sum, err := counter.QuerySum(ctx, 0, 15)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, sum, "applied once")
+}
@@ -0,0 +1,155 @@
+package rangecounter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// hybridIntRangeCounter write a bucket per index, like basicIntRangeCounter, and the nodes of a range tree at only
+// some depths. The bucket takes the place of the tree's leaf.
+type hybridIntRangeCounter[V Value] struct {
+	rangeTreeLayout
+	backend GenericBackend[V]
+
+	// levels is the depths of the tree which are written, 1 being the top level
+	levels map[int]bool
+}
+
+func (h *hybridIntRangeCounter[V]) QuerySum(ctx context.Context, from, to int64) (V, error) {
+	backendResult, err := h.backend.Query(ctx, h.queryKeys(from, to))
+	if err != nil {
+		return 0, err
+	}
+
+	sum := V(0)
+	for _, it := range backendResult {
+		sum += it
+	}
+	return sum, nil
+}
+
+func (h *hybridIntRangeCounter[V]) Increment(ctx context.Context, at int64, by V) error {
+	keys := h.incrementKeys(at)
+	increments := make([]V, len(keys))
+	for i := range increments {
+		increments[i] = by
+	}
+	return h.backend.Increment(ctx, keys, increments)
+}
+
+func (h *hybridIntRangeCounter[V]) IncrementIfBelow(ctx context.Context, at int64, by V, from, to int64, limit V) (bool, V, error) {
+	keys := h.incrementKeys(at)
+	increments := make([]V, len(keys))
+	for i := range increments {
+		increments[i] = by
+	}
+	return incrementIfBelow(ctx, h.backend, h.queryKeys(from, to), by, limit, keys, increments)
+}
+
+func (h *hybridIntRangeCounter[V]) incrementKeys(at int64) []string {
+	keys := []string{fmt.Sprint(at)}
+	for depth, key := range h.rangeTreeLayout.incrementKeys(at) {
+		if h.levels[depth+1] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// queryKeys returns whichever of the buckets or the tree nodes need fewer keys, preferring the buckets on a tie
+func (h *hybridIntRangeCounter[V]) queryKeys(from, to int64) []string {
+	bucketCount := int(to - from + 1)
+
+	treeKeys := []string{}
+	for _, key := range h.determineSumKeys(from, to) {
+		treeKeys = h.appendWrittenKeys(treeKeys, key, bucketCount)
+		if len(treeKeys) >= bucketCount {
+			break
+		}
+	}
+	if len(treeKeys) < bucketCount {
+		return treeKeys
+	}
+
+	keys := make([]string, 0, bucketCount)
+	for ; from <= to; from++ {
+		keys = append(keys, fmt.Sprint(from))
+	}
+	return keys
+}
+
+// appendWrittenKeys append the key of a tree node, or if its depth is not written, the keys of its descendants at
+// the next written depth or the buckets. It stops once keys has more than limit keys.
+func (h *hybridIntRangeCounter[V]) appendWrittenKeys(keys []string, key string, limit int) []string {
+	depth := strings.Count(key, ":")
+	if depth == h.heightLimit {
+		return append(keys, fmt.Sprint(h.leafIndex(key)))
+	}
+	if h.levels[depth] {
+		return append(keys, key)
+	}
+
+	for i := uint64(0); i < 1<<h.bitLength && len(keys) <= limit; i++ {
+		keys = h.appendWrittenKeys(keys, h.appendKey(key, i), limit)
+	}
+	return keys
+}
+
+// KeySpan returns the indexes under a tree node, or the index of a bucket
+func (h *hybridIntRangeCounter[V]) KeySpan(key string) (int64, int64, bool) {
+	if strings.HasPrefix(key, ":") {
+		return h.rangeTreeLayout.KeySpan(key)
+	}
+	return bucketKeySpan(key)
+}
+
+// leafIndex is the index of the leaf with this key
+func (h *hybridIntRangeCounter[V]) leafIndex(key string) int64 {
+	idx := uint64(0)
+	for _, path := range strings.Split(key[1:], ":") {
+		parsed, _ := strconv.ParseUint(path, 10, 64)
+		idx = idx<<h.bitLength | parsed
+	}
+	return int64(idx)
+}
+
+// NewHybridIntRangeCounter store each index in a bucket, and in a range tree of heightLimit and bitLength written
+// only at the given levels, 1 being the top level. A leaf of the tree is the bucket, so levels must be below
+// heightLimit. Each QuerySum reads the buckets, or the tree nodes if they need fewer keys, so that short ranges
+// cost as the bucket scheme while long ranges cost closer to the tree.
+func NewHybridIntRangeCounter(backend Backend, heightLimit int, bitLength uint, levels []int) ConditionalIntRangeCounter {
+	return NewGenericHybridIntRangeCounter[int64](backend, heightLimit, bitLength, levels)
+}
+
+func NewGenericHybridIntRangeCounter[V Value](backend GenericBackend[V], heightLimit int, bitLength uint, levels []int) GenericConditionalIntRangeCounter[V] {
+	if heightLimit <= 0 {
+		panic("heightLimit must be positive")
+	}
+	levelSet := map[int]bool{}
+	for _, level := range levels {
+		if level < 1 || level >= heightLimit {
+			panic("levels must be between 1 and heightLimit-1")
+		}
+		levelSet[level] = true
+	}
+	return &hybridIntRangeCounter[V]{
+		rangeTreeLayout: rangeTreeLayout{
+			heightLimit: heightLimit,
+			bitLength:   bitLength,
+		},
+		backend: backend,
+		levels:  levelSet,
+	}
+}
+
+func (h *hybridIntRangeCounter[V]) String() string {
+	levels := []int{}
+	for level := range h.levels {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	return fmt.Sprintf("hybridIntRangeCounter(%v-%v, levels %v)", h.heightLimit, h.bitLength, levels)
+}
@@ -0,0 +1,43 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHybridIntRangeCounterPicksCheaperKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := NewBenchmarkBackend()
+	counter := NewHybridIntRangeCounter(backend, 3, 4, []int{2})
+
+	assert.NoError(t, counter.Increment(ctx, 300, 1))
+	assert.EqualValues(t, 2, backend.incrementKeyTouched)
+
+	for i := int64(0); i < 1000; i++ {
+		assert.NoError(t, counter.Increment(ctx, i, 1))
+	}
+
+	tests := []struct {
+		name         string
+		from         int64
+		to           int64
+		expected     int64
+		expectedKeys int64
+	}{
+		{"short range use buckets", 14, 17, 4, 4},
+		{"node and buckets", 14, 33, 20, 5},
+		{"long range use the tree", 0, 511, 513, 62},
+		{"tie use buckets", 15, 16, 2, 2},
+	}
+	for _, d := range tests {
+		t.Run(d.name, func(t *testing.T) {
+			backend.queryKeyTouched = 0
+			sum, err := counter.QuerySum(ctx, d.from, d.to)
+			assert.NoError(t, err)
+			assert.EqualValues(t, d.expected, sum)
+			assert.EqualValues(t, d.expectedKeys, backend.queryKeyTouched)
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package rangecounter
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/pkg/errors"
+)
+
+// Encoding of a hyperLogLog. Dense is the header followed by every register, sparse is the header followed by
+// (4 byte register index, 1 byte rank) pairs, which is what a single Add sends.
+const (
+	hllDense byte = iota
+	hllSparse
+)
+
+const hllHeaderLength = 2
+
+// The precisions a hyperLogLog can have, a precision p having 2^p registers
+const (
+	hllMinPrecision = 4
+	hllMaxPrecision = 18
+)
+
+type hyperLogLog struct {
+	precision uint8
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint8) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+// hashMember hash the member with fnv, then mix it as fnv's high bits are not well distributed for short strings
+func hashMember(member string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(member))
+	hash := hasher.Sum64()
+
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// position is the register of the hash, and the rank to set it to
+func (h *hyperLogLog) position(hash uint64) (uint32, uint8) {
+	idx := hash >> (64 - h.precision)
+	remaining := hash<<h.precision | 1<<(h.precision-1)
+	return uint32(idx), uint8(bits.LeadingZeros64(remaining) + 1)
+}
+
+func (h *hyperLogLog) add(member string) {
+	idx, rank := h.position(hashMember(member))
+	if rank > h.registers[idx] {
+		h.registers[idx] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() int64 {
+	m := float64(len(h.registers))
+	alpha := 0.7213 / (1 + 1.079/m)
+	switch len(h.registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	}
+
+	sum := 0.0
+	zeros := 0
+	for _, register := range h.registers {
+		sum += math.Ldexp(1, -int(register))
+		if register == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+func (h *hyperLogLog) marshal() []byte {
+	encoded := make([]byte, hllHeaderLength+len(h.registers))
+	encoded[0] = hllDense
+	encoded[1] = h.precision
+	copy(encoded[hllHeaderLength:], h.registers)
+	return encoded
+}
+
+// merge the encoded hyperLogLog into h
+func (h *hyperLogLog) merge(encoded []byte) error {
+	if len(encoded) < hllHeaderLength {
+		return errors.Errorf("hyperloglog too short: %v bytes", len(encoded))
+	}
+	if encoded[1] != h.precision {
+		return errors.Errorf("unable to merge hyperloglog of precision %v into precision %v", encoded[1], h.precision)
+	}
+
+	body := encoded[hllHeaderLength:]
+	switch encoded[0] {
+	case hllDense:
+		if len(body) != len(h.registers) {
+			return errors.Errorf("expected %v registers, got %v", len(h.registers), len(body))
+		}
+		for i, rank := range body {
+			if rank > h.registers[i] {
+				h.registers[i] = rank
+			}
+		}
+	case hllSparse:
+		if len(body)%5 != 0 {
+			return errors.Errorf("invalid sparse hyperloglog length %v", len(body))
+		}
+		for i := 0; i < len(body); i += 5 {
+			idx := binary.BigEndian.Uint32(body[i:])
+			rank := body[i+4]
+			if int(idx) >= len(h.registers) {
+				return errors.Errorf("register %v out of range", idx)
+			}
+			if rank > h.registers[idx] {
+				h.registers[idx] = rank
+			}
+		}
+	default:
+		return errors.Errorf("unknown hyperloglog encoding %v", encoded[0])
+	}
+	return nil
+}
+
+// hyperLogLogUpdate is the sparse encoding of adding member to an empty hyperLogLog
+func hyperLogLogUpdate(precision uint8, member string) []byte {
+	idx, rank := newHyperLogLog(precision).position(hashMember(member))
+	encoded := make([]byte, hllHeaderLength+5)
+	encoded[0] = hllSparse
+	encoded[1] = precision
+	binary.BigEndian.PutUint32(encoded[hllHeaderLength:], idx)
+	encoded[hllHeaderLength+4] = rank
+	return encoded
+}
+
+// validateHyperLogLog check the header and length of an encoded hyperLogLog, before allocating its registers
+func validateHyperLogLog(encoded []byte) error {
+	if len(encoded) < hllHeaderLength {
+		return errors.Errorf("hyperloglog too short: %v bytes", len(encoded))
+	}
+	precision := encoded[1]
+	if precision < hllMinPrecision || precision > hllMaxPrecision {
+		return errors.Errorf("invalid hyperloglog precision %v", precision)
+	}
+	body := len(encoded) - hllHeaderLength
+	switch encoded[0] {
+	case hllDense:
+		if body != 1<<precision {
+			return errors.Errorf("expected %v registers, got %v", 1<<precision, body)
+		}
+	case hllSparse:
+		if body%5 != 0 {
+			return errors.Errorf("invalid sparse hyperloglog length %v", body)
+		}
+	default:
+		return errors.Errorf("unknown hyperloglog encoding %v", encoded[0])
+	}
+	return nil
+}
+
+// HyperLogLogMerge is the BytesMergeFunc for the sketches stored by DistinctRangeCounter.
+// A remote BytesBackend should merge the same way, taking the max of each register.
+func HyperLogLogMerge(stored, value []byte) ([]byte, error) {
+	err := validateHyperLogLog(value)
+	if err != nil {
+		return nil, err
+	}
+
+	hll := newHyperLogLog(value[1])
+	if stored != nil {
+		err := hll.merge(stored)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decode stored hyperloglog")
+		}
+	}
+	err = hll.merge(value)
+	if err != nil {
+		return nil, err
+	}
+	return hll.marshal(), nil
+}
@@ -5,26 +5,114 @@ import (
 	"time"
 )
 
-// Backend is where the values are actually stored.
+// GenericBackend is where the values are actually stored.
 // It should be a key-value store with support for pipelines (like redis) and incremenet.
-type Backend interface {
-	Query(ctx context.Context, keys []string) ([]int64, error)
-	Increment(ctx context.Context, keys []string, values []int64) error
+type GenericBackend[V Value] interface {
+	Query(ctx context.Context, keys []string) ([]V, error)
+	Increment(ctx context.Context, keys []string, values []V) error
 }
 
-// IntRangeCounter query count stuff with int64 as its keys
-type IntRangeCounter interface {
-	QuerySum(ctx context.Context, from, to int64) (int64, error)
-	Increment(ctx context.Context, at int64, by int64) (error)
+// Backend is the default GenericBackend, counting in int64
+type Backend = GenericBackend[int64]
+
+// QueryIncrementBackend is a Backend that can increment some keys and query others in a single round trip,
+// like a redis pipeline of INCRBY and GET.
+// The returned values must include the increments done in the same call.
+type QueryIncrementBackend interface {
+	Backend
+	QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error)
+}
+
+// GenericConditionalIncrementBackend is a GenericBackend that can atomically increment keys only if the sum of
+// sumKeys plus delta stays within limit, for example with a redis lua script.
+// It returns whether the increment was applied, and the sum of sumKeys before the increment.
+type GenericConditionalIncrementBackend[V Value] interface {
+	GenericBackend[V]
+	IncrementIfBelow(ctx context.Context, sumKeys []string, delta V, limit V, keys []string, values []V) (bool, V, error)
+}
+
+// ConditionalIncrementBackend is the default GenericConditionalIncrementBackend, counting in int64
+type ConditionalIncrementBackend = GenericConditionalIncrementBackend[int64]
+
+// BytesBackend is where mergeable byte values, such as sketches, are stored.
+// MergeBytes merge each value into the value stored at its key. How they are merged is up to the backend, usually
+// configured per sketch type, so it should be commutative and associative. A missing key is queried as nil.
+type BytesBackend interface {
+	QueryBytes(ctx context.Context, keys []string) ([][]byte, error)
+	MergeBytes(ctx context.Context, keys []string, values [][]byte) error
+}
+
+// ExtremumBackend keep the max of the values written to each key, atomically.
+// QueryMax also returns whether each key has been written, as any int64 is a valid max.
+// See NewCompareAndSetExtremumBackend for a backend which can only compare-and-set.
+type ExtremumBackend interface {
+	QueryMax(ctx context.Context, keys []string) ([]int64, []bool, error)
+	UpdateMax(ctx context.Context, keys []string, values []int64) error
+}
+
+// CompareAndSetBackend is a key-value store that can set a key only if it still has the expected value.
+// oldExists is false if the key is expected to be missing.
+type CompareAndSetBackend interface {
+	Get(ctx context.Context, keys []string) ([]int64, []bool, error)
+	CompareAndSet(ctx context.Context, key string, old int64, oldExists bool, new int64) (bool, error)
+}
+
+// GenericIntRangeCounter query count stuff with int64 as its keys
+type GenericIntRangeCounter[V Value] interface {
+	QuerySum(ctx context.Context, from, to int64) (V, error)
+	Increment(ctx context.Context, at int64, by V) (error)
 }
 
-// DateRangeCounter query count stuff with time.Time as its keys and rangeCount
+// IntRangeCounter is the default GenericIntRangeCounter, counting in int64
+type IntRangeCounter = GenericIntRangeCounter[int64]
+
+// GenericConditionalIntRangeCounter is a GenericIntRangeCounter that can increment `at` only if the sum from `from`
+// to `to` stays within limit after the increment.
+// It returns whether the increment was applied, and the sum before the increment. Its backend must be a
+// GenericConditionalIncrementBackend, see NewLockingConditionalBackend.
+type GenericConditionalIntRangeCounter[V Value] interface {
+	GenericIntRangeCounter[V]
+	IncrementIfBelow(ctx context.Context, at int64, by V, from, to int64, limit V) (bool, V, error)
+}
+
+// ConditionalIntRangeCounter is the default GenericConditionalIntRangeCounter, counting in int64
+type ConditionalIntRangeCounter = GenericConditionalIntRangeCounter[int64]
+
+// GenericDateRangeCounter query count stuff with time.Time as its keys and rangeCount
 // A range is a duration, for example second, minutes or hours, and it should be fixed for the implementation
 // The bucketCount is the count of `range` before `at` (inclusive of `at`)
 // The implementation should align to the boundary of range.
 // On some implementation it should use IntRangeCounter under
-type DateRangeCounter interface {
-	QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error)
-	Increment(ctx context.Context, at time.Time, by int64) (error)
+type GenericDateRangeCounter[V Value] interface {
+	QuerySum(ctx context.Context, at time.Time, bucketCount int) (V, error)
+	Increment(ctx context.Context, at time.Time, by V) (error)
 }
 
+// DateRangeCounter is the default GenericDateRangeCounter, counting in int64
+type DateRangeCounter = GenericDateRangeCounter[int64]
+
+// GenericConditionalDateRangeCounter is a GenericDateRangeCounter that can increment `at` only if the sum of
+// `window` buckets before `at` (inclusive) stays within limit after the increment.
+// It returns whether the increment was applied, and the sum before the increment. Its backend must be a
+// GenericConditionalIncrementBackend, see NewLockingConditionalBackend.
+type GenericConditionalDateRangeCounter[V Value] interface {
+	GenericDateRangeCounter[V]
+	IncrementIfBelow(ctx context.Context, at time.Time, by V, window int, limit V) (bool, V, error)
+}
+
+// ConditionalDateRangeCounter is the default GenericConditionalDateRangeCounter, counting in int64
+type ConditionalDateRangeCounter = GenericConditionalDateRangeCounter[int64]
+
+// IndexKeySpanner is an int range counter which can tell the indexes each of its keys covers, for example to place
+// keys by their age.
+type IndexKeySpanner interface {
+	// KeySpan returns the first and last index covered by key, or false if key is not one of its keys
+	KeySpan(key string) (int64, int64, bool)
+}
+
+// DateKeySpanner is a date range counter or layout which can tell the time each of its keys covers.
+type DateKeySpanner interface {
+	// KeySpan returns the start of the first bucket and the end of the last bucket covered by key, or false if key
+	// is not one of its keys
+	KeySpan(key string) (time.Time, time.Time, bool)
+}
@@ -1,30 +1,115 @@
 package rangecounter
 
-import "context"
+import (
+	"context"
+	"sync"
+)
 
-type inMemoryBackend struct {
-	store map[string]int64
+type inMemoryBackend[V Value] struct {
+	lock  sync.Mutex
+	store map[string]V
 }
 
 func NewInMemoryBackend() Backend {
-	return &inMemoryBackend{
-		store: map[string]int64{},
+	return NewGenericInMemoryBackend[int64]()
+}
+
+func NewGenericInMemoryBackend[V Value]() GenericBackend[V] {
+	return &inMemoryBackend[V]{
+		store: map[string]V{},
+	}
+}
+
+func (b *inMemoryBackend[V]) Query(ctx context.Context, keys []string) ([]V, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.query(keys), nil
+}
+
+func (b *inMemoryBackend[V]) Increment(ctx context.Context, keys []string, values []V) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.increment(keys, values)
+	return nil
+}
+
+func (b *inMemoryBackend[V]) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []V) ([]V, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.increment(incrementKeys, values)
+	return b.query(queryKeys), nil
+}
+
+func (b *inMemoryBackend[V]) IncrementIfBelow(ctx context.Context, sumKeys []string, delta V, limit V, keys []string, values []V) (bool, V, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	sum := V(0)
+	for _, it := range b.query(sumKeys) {
+		sum += it
+	}
+	if sum+delta > limit {
+		return false, sum, nil
+	}
+	b.increment(keys, values)
+	return true, sum, nil
+}
+
+func (b *inMemoryBackend[V]) QueryMax(ctx context.Context, keys []string) ([]V, []bool, error) {
+	return b.Get(ctx, keys)
+}
+
+func (b *inMemoryBackend[V]) UpdateMax(ctx context.Context, keys []string, values []V) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i := 0; i < len(keys); i++ {
+		current, ok := b.store[keys[i]]
+		if !ok || values[i] > current {
+			b.store[keys[i]] = values[i]
+		}
+	}
+	return nil
+}
+
+func (b *inMemoryBackend[V]) Get(ctx context.Context, keys []string) ([]V, []bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	results := make([]V, 0, len(keys))
+	exists := make([]bool, 0, len(keys))
+	for _, key := range keys {
+		value, ok := b.store[key]
+		results = append(results, value)
+		exists = append(exists, ok)
+	}
+	return results, exists, nil
+}
+
+func (b *inMemoryBackend[V]) CompareAndSet(ctx context.Context, key string, old V, oldExists bool, new V) (bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	current, ok := b.store[key]
+	if ok != oldExists || current != old {
+		return false, nil
 	}
+	b.store[key] = new
+	return true, nil
 }
 
-func (b inMemoryBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
-	results := make([]int64, 0, len(keys))
+func (b *inMemoryBackend[V]) query(keys []string) []V {
+	results := make([]V, 0, len(keys))
 	for _, key := range keys {
 		results = append(results, b.store[key])
 	}
-	return results, nil
+	return results
 }
 
-func (b inMemoryBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+func (b *inMemoryBackend[V]) increment(keys []string, values []V) {
 	for i := 0; i < len(keys); i++ {
 		key := keys[i]
 		value := values[i]
 		b.store[key] = b.store[key] + value
 	}
-	return nil
 }
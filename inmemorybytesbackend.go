@@ -0,0 +1,49 @@
+package rangecounter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// BytesMergeFunc merge value into stored, returning the new stored value. stored is nil for a missing key.
+type BytesMergeFunc func(stored, value []byte) ([]byte, error)
+
+type inMemoryBytesBackend struct {
+	lock  sync.Mutex
+	store map[string][]byte
+	merge BytesMergeFunc
+}
+
+func NewInMemoryBytesBackend(merge BytesMergeFunc) BytesBackend {
+	return &inMemoryBytesBackend{
+		store: map[string][]byte{},
+		merge: merge,
+	}
+}
+
+func (b *inMemoryBytesBackend) QueryBytes(ctx context.Context, keys []string) ([][]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	results := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, b.store[key])
+	}
+	return results, nil
+}
+
+func (b *inMemoryBytesBackend) MergeBytes(ctx context.Context, keys []string, values [][]byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for i := 0; i < len(keys); i++ {
+		merged, err := b.merge(b.store[keys[i]], values[i])
+		if err != nil {
+			return errors.Wrapf(err, "unable to merge key %v", keys[i])
+		}
+		b.store[keys[i]] = merged
+	}
+	return nil
+}
@@ -2,30 +2,97 @@ package rangecounter
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
-type intBackedDateRange struct {
+type intBackedDateRange[V Value] struct {
 	nativeRange  DateRange
-	backingRange IntRangeCounter
+	backingRange GenericIntRangeCounter[V]
 }
 
-func (ibdr *intBackedDateRange) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+func (ibdr *intBackedDateRange[V]) QuerySum(ctx context.Context, at time.Time, bucketCount int) (V, error) {
 	durationNano := ibdr.nativeRange.getDuration().Nanoseconds()
 	endIndex := at.UnixNano()/durationNano
 	startIndex := endIndex - int64(bucketCount) + 1
 	return ibdr.backingRange.QuerySum(ctx, startIndex, endIndex)
 }
 
-func (ibdr *intBackedDateRange) Increment(ctx context.Context, at time.Time, by int64) (error) {
+func (ibdr *intBackedDateRange[V]) Increment(ctx context.Context, at time.Time, by V) (error) {
 	durationNano := ibdr.nativeRange.getDuration().Nanoseconds()
 	index := at.UnixNano()/durationNano
 	return ibdr.backingRange.Increment(ctx, index, by)
 }
 
-func NewIntBackedDateRange(backingRange IntRangeCounter, nativeRange DateRange) DateRangeCounter {
-	return &intBackedDateRange{
+// IncrementIfBelow is only supported if the backing range is a ConditionalIntRangeCounter
+func (ibdr *intBackedDateRange[V]) IncrementIfBelow(ctx context.Context, at time.Time, by V, window int, limit V) (bool, V, error) {
+	conditionalRange, ok := ibdr.backingRange.(GenericConditionalIntRangeCounter[V])
+	if !ok {
+		return false, 0, errors.New("backing range does not support conditional increment")
+	}
+
+	durationNano := ibdr.nativeRange.getDuration().Nanoseconds()
+	index := at.UnixNano()/durationNano
+	return conditionalRange.IncrementIfBelow(ctx, index, by, index-int64(window)+1, index, limit)
+}
+
+// KeySpan returns the time covered by a key of the backing range, if it is an IndexKeySpanner
+func (ibdr *intBackedDateRange[V]) KeySpan(key string) (time.Time, time.Time, bool) {
+	spanner, ok := ibdr.backingRange.(IndexKeySpanner)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	from, to, ok := spanner.KeySpan(key)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return indexSpanTime(ibdr.nativeRange, from, to)
+}
+
+// indexSpanTime returns the start of the bucket of index from, and the end of the bucket of index to. Both saturate
+// at the times an int64 of nanoseconds can hold, so the upper nodes of a wide tree end after any real time.
+func indexSpanTime(drange DateRange, from, to int64) (time.Time, time.Time, bool) {
+	duration := drange.getDuration().Nanoseconds()
+	end := time.Unix(0, math.MaxInt64).UTC()
+	if to < math.MaxInt64 {
+		end = indexTime(to+1, duration)
+	}
+	return indexTime(from, duration), end, true
+}
+
+// indexTime returns the start of the bucket of index, saturated
+func indexTime(index, duration int64) time.Time {
+	switch {
+	case index > math.MaxInt64/duration:
+		return time.Unix(0, math.MaxInt64).UTC()
+	case index < math.MinInt64/duration:
+		return time.Unix(0, math.MinInt64).UTC()
+	}
+	return time.Unix(0, index*duration).UTC()
+}
+
+func NewIntBackedDateRange(backingRange IntRangeCounter, nativeRange DateRange) ConditionalDateRangeCounter {
+	return NewGenericIntBackedDateRange[int64](backingRange, nativeRange)
+}
+
+func NewGenericIntBackedDateRange[V Value](backingRange GenericIntRangeCounter[V], nativeRange DateRange) GenericConditionalDateRangeCounter[V] {
+	return &intBackedDateRange[V]{
 		backingRange: backingRange,
 		nativeRange: nativeRange,
 	}
 }
+
+func (ibdr *intBackedDateRange[V]) String() string {
+	return fmt.Sprintf("intBackedDateRange(%v, %v)", ibdr.nativeRange, counterString(ibdr.backingRange))
+}
+
+// counterString is the String of counter if it has one, or its type
+func counterString(counter interface{}) string {
+	if stringer, ok := counter.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", counter)
+}
@@ -1,27 +1,69 @@
 package rangecounter
 
-import "context"
+import (
+	"context"
+	"fmt"
 
-type intRangeTranslator struct {
-	innerCounter IntRangeCounter
+	"github.com/pkg/errors"
+)
+
+type intRangeTranslator[V Value] struct {
+	innerCounter GenericIntRangeCounter[V]
 	factor       int64
 }
 
-func (i *intRangeTranslator) Increment(ctx context.Context, at int64, by int64) error {
+func (i *intRangeTranslator[V]) Increment(ctx context.Context, at int64, by V) error {
 	return i.innerCounter.Increment(ctx, at*i.factor, by)
 }
 
-func (i *intRangeTranslator) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+func (i *intRangeTranslator[V]) QuerySum(ctx context.Context, from, to int64) (V, error) {
 	return i.innerCounter.QuerySum(ctx, from*i.factor, (to*i.factor)+i.factor-1)
 }
 
-func NewIntRangeTranslator(innerCounter IntRangeCounter, fromDateRange, toDateRange DateRange) IntRangeCounter {
+// IncrementIfBelow is only supported if the inner counter is a ConditionalIntRangeCounter
+func (i *intRangeTranslator[V]) IncrementIfBelow(ctx context.Context, at int64, by V, from, to int64, limit V) (bool, V, error) {
+	conditionalCounter, ok := i.innerCounter.(GenericConditionalIntRangeCounter[V])
+	if !ok {
+		return false, 0, errors.New("inner counter does not support conditional increment")
+	}
+	return conditionalCounter.IncrementIfBelow(ctx, at*i.factor, by, from*i.factor, (to*i.factor)+i.factor-1, limit)
+}
+
+// KeySpan translate the span of a key of the inner counter, if it is an IndexKeySpanner
+func (i *intRangeTranslator[V]) KeySpan(key string) (int64, int64, bool) {
+	spanner, ok := i.innerCounter.(IndexKeySpanner)
+	if !ok {
+		return 0, 0, false
+	}
+	from, to, ok := spanner.KeySpan(key)
+	if !ok {
+		return 0, 0, false
+	}
+	return floorDiv(from, i.factor), floorDiv(to, i.factor), true
+}
+
+func floorDiv(a, b int64) int64 {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
+
+func NewIntRangeTranslator(innerCounter IntRangeCounter, fromDateRange, toDateRange DateRange) ConditionalIntRangeCounter {
+	return NewGenericIntRangeTranslator[int64](innerCounter, fromDateRange, toDateRange)
+}
+
+func NewGenericIntRangeTranslator[V Value](innerCounter GenericIntRangeCounter[V], fromDateRange, toDateRange DateRange) GenericConditionalIntRangeCounter[V] {
 	if toDateRange.getDuration().Nanoseconds() > fromDateRange.getDuration().Nanoseconds() {
 		panic("to date range must be smaller than from date range")
 	}
 	factor := fromDateRange.getDuration().Nanoseconds() / toDateRange.getDuration().Nanoseconds()
-	return &intRangeTranslator{
+	return &intRangeTranslator[V]{
 		innerCounter: innerCounter,
 		factor:       factor,
 	}
 }
+
+func (i *intRangeTranslator[V]) String() string {
+	return fmt.Sprintf("intRangeTranslator(x%v, %v)", i.factor, counterString(i.innerCounter))
+}
@@ -0,0 +1,164 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRangeTreeKeySpan(t *testing.T) {
+	layout := rangeTreeLayout{heightLimit: 4, bitLength: 1}
+	tests := []struct {
+		key  string
+		from int64
+		to   int64
+		ok   bool
+	}{
+		{":0", 0, 7, true},
+		{":1", 8, 15, true},
+		{":0:1", 4, 7, true},
+		{":0:1:0:1", 5, 5, true},
+		{":3:1:1:1", 31, 31, true},
+		{"5", 0, 0, false},
+		{":0:2", 0, 0, false},
+		{":0:1:0:1:0", 0, 0, false},
+		{":01", 0, 0, false},
+		{":", 0, 0, false},
+	}
+	for _, test := range tests {
+		from, to, ok := layout.KeySpan(test.key)
+		assert.Equal(t, test.ok, ok, test.key)
+		assert.Equal(t, test.from, from, test.key)
+		assert.Equal(t, test.to, to, test.key)
+	}
+}
+
+func TestWideTreeKeySpan(t *testing.T) {
+	layout := NewRangeTreeDateLayout(Seconds, 8, 8)
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxTime := time.Unix(0, math.MaxInt64).UTC()
+	for _, key := range []string{":0", ":0:0", ":0:0:0"} {
+		from, to, ok := layout.KeySpan(key)
+		assert.True(t, ok, key)
+		assert.True(t, from.Equal(time.Unix(0, 0)), "%v start at %v", key, from)
+		assert.True(t, to.Equal(maxTime), "%v end at %v", key, to)
+	}
+
+	from, to, ok := layout.KeySpan(":0:0:0:0:0:1")
+	assert.True(t, ok)
+	assert.True(t, from.Equal(time.Unix(1<<16, 0)))
+	assert.True(t, to.Equal(time.Unix(2<<16, 0)))
+
+	tiered := NewTieredBackend(NewInMemoryBackend(), NewInMemoryBackend(), TieredBackendOptions{
+		KeySpan: layout.KeySpan,
+		HotFor:  time.Hour,
+		now: func() time.Time {
+			return now
+		},
+	}).(*tieredBackend)
+	cold, _ := tiered.isCold(":0", now)
+	assert.False(t, cold, "the root never end")
+
+	from, to, _ = indexSpanTime(Seconds, math.MinInt64, math.MaxInt64)
+	assert.True(t, from.Equal(time.Unix(0, math.MinInt64)))
+	assert.True(t, to.Equal(maxTime))
+}
+
+// incrementedKeys returns a backend, and a function returning the keys incremented since its last call
+func incrementedKeys() (Backend, func() []string) {
+	keys := []string{}
+	backend := NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+		if call.Op == IncrementOperation {
+			keys = append(keys, call.Keys...)
+		}
+		return Fault{}
+	})
+	return backend, func() []string {
+		incremented := keys
+		keys = []string{}
+		return incremented
+	}
+}
+
+func TestIndexKeySpanCoverIncrements(t *testing.T) {
+	ctx := context.Background()
+	counterToTest := map[string]func(backend Backend) IntRangeCounter{
+		"basic": func(backend Backend) IntRangeCounter {
+			return NewBasicIntRangeCounter(backend)
+		},
+		"tree-4-2": func(backend Backend) IntRangeCounter {
+			return NewRangeTreeIntCounter(backend, 4, 2)
+		},
+		"hybrid-8-1": func(backend Backend) IntRangeCounter {
+			return NewHybridIntRangeCounter(backend, 8, 1, []int{2, 4, 6})
+		},
+		"minute-to-second": func(backend Backend) IntRangeCounter {
+			return NewIntRangeTranslator(NewRangeTreeIntCounter(backend, 16, 2), Minute, Seconds)
+		},
+	}
+
+	for name, factory := range counterToTest {
+		t.Run(name, func(t *testing.T) {
+			backend, keys := incrementedKeys()
+			counter := factory(backend)
+			spanner := counter.(IndexKeySpanner)
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < 200; i++ {
+				at := random.Int63n(100000) - 1000
+				assert.NoError(t, counter.Increment(ctx, at, 1))
+				for _, key := range keys() {
+					from, to, ok := spanner.KeySpan(key)
+					assert.True(t, ok, key)
+					assert.True(t, from <= at && at <= to, "%v spans %v to %v, not %v", key, from, to, at)
+				}
+			}
+			_, _, ok := spanner.KeySpan("unrelated")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestDateKeySpanCoverIncrements(t *testing.T) {
+	ctx := context.Background()
+	counterToTest := map[string]func(backend Backend) DateRangeCounter{
+		"basic": func(backend Backend) DateRangeCounter {
+			return NewBasicDateCounter(Minute, backend)
+		},
+		"int-backed-tree": func(backend Backend) DateRangeCounter {
+			return NewIntBackedDateRange(NewRangeTreeIntCounter(backend, 8, 2), Minute)
+		},
+	}
+	layoutOfCounter := map[string]DateLayout{
+		"basic":           NewBucketDateLayout(Minute),
+		"int-backed-tree": NewRangeTreeDateLayout(Minute, 8, 2),
+	}
+
+	for name, factory := range counterToTest {
+		t.Run(name, func(t *testing.T) {
+			backend, keys := incrementedKeys()
+			counter := factory(backend)
+			layout := layoutOfCounter[name]
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < 200; i++ {
+				at := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(random.Int63n(int64(30 * 24 * time.Hour))))
+				assert.NoError(t, counter.Increment(ctx, at, 1))
+				incremented := keys()
+				layoutKeys, err := layout.incrementKeys(at)
+				assert.NoError(t, err)
+				assert.Equal(t, layoutKeys, incremented, "the layout has the keys of the counter")
+
+				for _, key := range incremented {
+					from, to, ok := counter.(DateKeySpanner).KeySpan(key)
+					assert.True(t, ok, key)
+					assert.True(t, !at.Before(from) && at.Before(to), "%v spans %v to %v, not %v", key, from, to, at)
+					layoutFrom, layoutTo, _ := layout.KeySpan(key)
+					assert.True(t, from.Equal(layoutFrom) && to.Equal(layoutTo), key)
+				}
+			}
+		})
+	}
+}
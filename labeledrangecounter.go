@@ -0,0 +1,297 @@
+package rangecounter
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LabeledDateRangeCounter count many series at once, each identified by its set of labels, such as customer or
+// endpoint. QuerySum sum the series whose labels match all of filter, and QueryGroupBy sum the series per value of
+// a label. Series without the label are grouped under "".
+type LabeledDateRangeCounter interface {
+	Increment(ctx context.Context, at time.Time, by int64, labels map[string]string) error
+	QuerySum(ctx context.Context, at time.Time, bucketCount int, filter map[string]string) (int64, error)
+	QueryGroupBy(ctx context.Context, at time.Time, bucketCount int, labelName string) (map[string]int64, error)
+}
+
+type labeledDateRangeCounter struct {
+	name    string
+	layout  DateLayout
+	backend Backend
+	index   BytesBackend
+
+	knownSeriesLock sync.Mutex
+	knownSeries     map[string]bool
+}
+
+// NewLabeledDateRangeCounter store each series with the layout under its own keys in backend, and the set of known
+// series in index, which must merge with LabelIndexMerge. Counters with the same name share their series, so each
+// counter should have its own name, as adding a series rewrite the whole set of its counter. The series keys start
+// with the name and series in braces, which work as a redis hash tag.
+// Every query read the whole set of series of the counter, then the keys of each matching series, so the number of
+// distinct label sets should stay bounded, to a few thousands per counter.
+func NewLabeledDateRangeCounter(name string, layout DateLayout, backend Backend, index BytesBackend) LabeledDateRangeCounter {
+	return &labeledDateRangeCounter{
+		name:        labelNameEscaper.Replace(name),
+		layout:      layout,
+		backend:     backend,
+		index:       index,
+		knownSeries: map[string]bool{},
+	}
+}
+
+func (l *labeledDateRangeCounter) Increment(ctx context.Context, at time.Time, by int64, labels map[string]string) error {
+	series := encodeSeries(labels)
+	err := l.registerSeries(ctx, series)
+	if err != nil {
+		return err
+	}
+
+	keys, err := l.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+	values := make([]int64, len(keys))
+	for i := range keys {
+		keys[i] = l.seriesKey(series, keys[i])
+		values[i] = by
+	}
+	return l.backend.Increment(ctx, keys, values)
+}
+
+// registerSeries add the series to the index, unless this counter already did
+func (l *labeledDateRangeCounter) registerSeries(ctx context.Context, series string) error {
+	l.knownSeriesLock.Lock()
+	known := l.knownSeries[series]
+	l.knownSeriesLock.Unlock()
+	if known {
+		return nil
+	}
+
+	err := l.index.MergeBytes(ctx, []string{l.indexKey()}, [][]byte{encodeSeriesSet([]string{series})})
+	if err != nil {
+		return errors.Wrap(err, "unable to update label index")
+	}
+
+	l.knownSeriesLock.Lock()
+	l.knownSeries[series] = true
+	l.knownSeriesLock.Unlock()
+	return nil
+}
+
+func (l *labeledDateRangeCounter) QuerySum(ctx context.Context, at time.Time, bucketCount int, filter map[string]string) (int64, error) {
+	sums, err := l.querySeries(ctx, at, bucketCount, func(labels map[string]string) bool {
+		for name, value := range filter {
+			if labelValue, ok := labels[name]; !ok || labelValue != value {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sum := int64(0)
+	for _, it := range sums {
+		sum += it
+	}
+	return sum, nil
+}
+
+func (l *labeledDateRangeCounter) QueryGroupBy(ctx context.Context, at time.Time, bucketCount int, labelName string) (map[string]int64, error) {
+	sums, err := l.querySeries(ctx, at, bucketCount, func(labels map[string]string) bool {
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string]int64{}
+	for series, sum := range sums {
+		labels, err := decodeSeries(series)
+		if err != nil {
+			return nil, err
+		}
+		groups[labels[labelName]] += sum
+	}
+	return groups, nil
+}
+
+// querySeries returns the sum of each known series matching the filter, in a single backend query
+func (l *labeledDateRangeCounter) querySeries(ctx context.Context, at time.Time, bucketCount int, filter func(labels map[string]string) bool) (map[string]int64, error) {
+	indexes, err := queryBytesBackend(ctx, l.index, []string{l.indexKey()})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query label index")
+	}
+	allSeries, err := decodeSeriesSet(indexes[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode label index")
+	}
+
+	layoutKeys, err := l.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return nil, err
+	}
+
+	matchingSeries := []string{}
+	keys := []string{}
+	for _, series := range allSeries {
+		labels, err := decodeSeries(series)
+		if err != nil {
+			return nil, err
+		}
+		if !filter(labels) {
+			continue
+		}
+		matchingSeries = append(matchingSeries, series)
+		for _, key := range layoutKeys {
+			keys = append(keys, l.seriesKey(series, key))
+		}
+	}
+
+	results, err := queryBackend(ctx, l.backend, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query counters")
+	}
+
+	sums := map[string]int64{}
+	for i, series := range matchingSeries {
+		for _, result := range results[i*len(layoutKeys) : (i+1)*len(layoutKeys)] {
+			sums[series] += result
+		}
+	}
+	return sums, nil
+}
+
+func (l *labeledDateRangeCounter) String() string {
+	return "labeledDateRangeCounter(" + l.layout.String() + ")"
+}
+
+// labelNameEscaper escape the name of a counter, so that it ends at the first unescaped ":" of its keys
+var labelNameEscaper = strings.NewReplacer(`\`, `\\`, `:`, `\:`, `{`, `\{`, `}`, `\}`)
+
+func (l *labeledDateRangeCounter) indexKey() string {
+	return l.name + ":series"
+}
+
+func (l *labeledDateRangeCounter) seriesKey(series string, key string) string {
+	return "{" + l.name + ":" + series + "}" + key
+}
+
+var seriesEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, `,`, `\,`, `{`, `\{`, `}`, `\}`)
+
+// encodeSeries is the labels sorted by name, as name=value separated by comma
+func encodeSeries(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	builder := strings.Builder{}
+	for i, name := range names {
+		if i != 0 {
+			builder.WriteByte(',')
+		}
+		builder.WriteString(seriesEscaper.Replace(name))
+		builder.WriteByte('=')
+		builder.WriteString(seriesEscaper.Replace(labels[name]))
+	}
+	return builder.String()
+}
+
+func decodeSeries(series string) (map[string]string, error) {
+	labels := map[string]string{}
+	if series == "" {
+		return labels, nil
+	}
+
+	name := ""
+	current := strings.Builder{}
+	inValue := false
+	for i := 0; i < len(series); i++ {
+		switch series[i] {
+		case '\\':
+			i++
+			if i == len(series) {
+				return nil, errors.Errorf("dangling escape in series %v", series)
+			}
+			current.WriteByte(series[i])
+		case '=':
+			if inValue {
+				return nil, errors.Errorf("unexpected '=' in series %v", series)
+			}
+			name = current.String()
+			current.Reset()
+			inValue = true
+		case ',':
+			if !inValue {
+				return nil, errors.Errorf("label without value in series %v", series)
+			}
+			labels[name] = current.String()
+			current.Reset()
+			inValue = false
+		default:
+			current.WriteByte(series[i])
+		}
+	}
+	if !inValue {
+		return nil, errors.Errorf("label without value in series %v", series)
+	}
+	labels[name] = current.String()
+	return labels, nil
+}
+
+// encodeSeriesSet encode the sorted series, each prefixed with its length
+func encodeSeriesSet(series []string) []byte {
+	sort.Strings(series)
+	encoded := []byte{}
+	for _, it := range series {
+		encoded = binary.AppendUvarint(encoded, uint64(len(it)))
+		encoded = append(encoded, it...)
+	}
+	return encoded
+}
+
+func decodeSeriesSet(encoded []byte) ([]string, error) {
+	series := []string{}
+	for len(encoded) > 0 {
+		length, n := binary.Uvarint(encoded)
+		if n <= 0 || uint64(len(encoded)-n) < length {
+			return nil, errors.New("invalid series in label index")
+		}
+		series = append(series, string(encoded[n:n+int(length)]))
+		encoded = encoded[n+int(length):]
+	}
+	return series, nil
+}
+
+// LabelIndexMerge is the BytesMergeFunc for the label index of LabeledDateRangeCounter, and the node index of
+// PNCounterBackend, a set union.
+func LabelIndexMerge(stored, value []byte) ([]byte, error) {
+	storedSeries, err := decodeSeriesSet(stored)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode stored label index")
+	}
+	newSeries, err := decodeSeriesSet(value)
+	if err != nil {
+		return nil, err
+	}
+
+	union := map[string]bool{}
+	for _, series := range append(storedSeries, newSeries...) {
+		union[series] = true
+	}
+	merged := make([]string, 0, len(union))
+	for series := range union {
+		merged = append(merged, series)
+	}
+	return encodeSeriesSet(merged), nil
+}
@@ -0,0 +1,111 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabeledDateRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type inputReq struct {
+		dateOffset int
+		by         int64
+		labels     map[string]string
+	}
+	type sumReq struct {
+		dateOffset  int
+		bucketCount int
+		filter      map[string]string
+		expected    int64
+	}
+	type groupByReq struct {
+		dateOffset  int
+		bucketCount int
+		labelName   string
+		expected    map[string]int64
+	}
+	inputs := []inputReq{
+		{0, 1, map[string]string{"customer": "a", "endpoint": "/login"}},
+		{0, 2, map[string]string{"customer": "a", "endpoint": "/search"}},
+		{1, 4, map[string]string{"customer": "b", "endpoint": "/search"}},
+		{3, 8, map[string]string{"customer": "a", "endpoint": "/login"}},
+		{3, 16, map[string]string{"customer": "c,=}", "endpoint": "/search"}},
+		{3, 32, map[string]string{}},
+	}
+	sums := []sumReq{
+		{0, 1, nil, 3},
+		{3, 4, nil, 63},
+		{3, 4, map[string]string{"customer": "a"}, 11},
+		{3, 4, map[string]string{"customer": "a", "endpoint": "/login"}, 9},
+		{3, 2, map[string]string{"endpoint": "/search"}, 16},
+		{3, 4, map[string]string{"customer": "c,=}"}, 16},
+		{3, 4, map[string]string{"customer": "d"}, 0},
+	}
+	groupBys := []groupByReq{
+		{3, 4, "customer", map[string]int64{"a": 11, "b": 4, "c,=}": 16, "": 32}},
+		{1, 2, "endpoint", map[string]int64{"/login": 1, "/search": 6, "": 0}},
+		{20, 2, "endpoint", map[string]int64{"/login": 0, "/search": 0, "": 0}},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket": NewBucketDateLayout(Hour),
+		"tree-8": NewRangeTreeDateLayout(Hour, 8, 1),
+	}
+	for layoutName, layout := range layoutToTest {
+		t.Run("layout "+layoutName, func(t *testing.T) {
+			ctx := context.Background()
+			backend := NewBenchmarkBackend()
+			index := NewInMemoryBytesBackend(LabelIndexMerge)
+			counter := NewLabeledDateRangeCounter("requests", layout, backend, index)
+
+			for _, inp := range inputs {
+				err := counter.Increment(ctx, Hour.incrementDateForce(inp.dateOffset, baseDate), inp.by, inp.labels)
+				assert.NoError(t, err)
+			}
+
+			for _, q := range sums {
+				queryCall := backend.queryCall
+				ans, err := counter.QuerySum(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount, q.filter)
+				assert.NoError(t, err)
+				assert.EqualValues(t, q.expected, ans)
+				assert.EqualValues(t, queryCall+1, backend.queryCall)
+			}
+
+			// Another counter of the same name on the same backends know the same series
+			otherCounter := NewLabeledDateRangeCounter("requests", layout, backend, index)
+			for _, q := range groupBys {
+				ans, err := otherCounter.QueryGroupBy(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount, q.labelName)
+				assert.NoError(t, err)
+				assert.Equal(t, q.expected, ans)
+			}
+
+			// While one of another name has its own
+			for _, name := range []string{"errors", "requests:", "requests\\"} {
+				unrelated := NewLabeledDateRangeCounter(name, layout, backend, index)
+				ans, err := unrelated.QueryGroupBy(ctx, baseDate, 100, "endpoint")
+				assert.NoError(t, err)
+				assert.Empty(t, ans, name)
+			}
+		})
+	}
+}
+
+// shortBytesBackend returns no value whatever the keys queried
+type shortBytesBackend struct {
+	BytesBackend
+}
+
+func (s shortBytesBackend) QueryBytes(ctx context.Context, keys []string) ([][]byte, error) {
+	return nil, nil
+}
+
+func TestLabeledDateRangeCounterShortIndex(t *testing.T) {
+	counter := NewLabeledDateRangeCounter("requests", NewBucketDateLayout(Hour), NewInMemoryBackend(),
+		shortBytesBackend{NewInMemoryBytesBackend(LabelIndexMerge)})
+	_, err := counter.QuerySum(context.Background(), time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), 1, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,385 @@
+package rangecounter
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// PNCount is the total of the positive and of the negative increments a node made to a key. Both only grow, so
+// two counts of the same node and key merge by taking the max of each.
+type PNCount struct {
+	Positive int64 `json:"p"`
+	Negative int64 `json:"n"`
+}
+
+// PNCounterState is the state of some keys of a PN-counter backend, as the count of each node for each key. It can
+// be encoded as JSON to be sent between nodes.
+type PNCounterState map[string]map[string]PNCount
+
+// PNCounterTransport carry states between the nodes of PN-counter backends. Send should only succeed once state will
+// be delivered to every other node, and Receive returns the states delivered to this node since its last call.
+type PNCounterTransport interface {
+	Send(ctx context.Context, state PNCounterState) error
+	Receive(ctx context.Context) ([]PNCounterState, error)
+}
+
+// PNCounterBackend is a Backend where each node increment its own counts, and merge the counts of the other nodes,
+// so every node converge to the same values without coordination.
+type PNCounterBackend interface {
+	Backend
+	// State returns the counts of every known node for keys
+	State(ctx context.Context, keys []string) (PNCounterState, error)
+	// Merge keep the max of each count of state and the stored one. Merging the same state again, or states in any
+	// order, give the same values.
+	Merge(ctx context.Context, state PNCounterState) error
+	// Sync send the state of the keys changed since the last Sync, and merge the states received. Every received state
+	// is merged even if some fail, which are then lost until their sender send those keys again.
+	Sync(ctx context.Context) error
+}
+
+// pnCounterNodesKey is the reserved key of the node set in the index of a PN-counter backend
+const pnCounterNodesKey = "pncounter:nodes"
+
+type pnCounterBackend struct {
+	node      string
+	store     ExtremumBackend
+	index     BytesBackend
+	transport PNCounterTransport
+
+	// incrementLock serialize the increments, which read then update the counts of this node, and the merges which
+	// could update them in between
+	incrementLock sync.Mutex
+
+	lock  sync.Mutex
+	nodes map[string]bool
+	dirty map[string]bool
+}
+
+// NewPNCounterBackend store the counts of a PN-counter CRDT in store, for node, which must be unique among the
+// processes sharing the counters. nodes are the other nodes known in advance, more are learnt from merged states and
+// kept under the reserved key "pncounter:nodes" of index, which must merge with LabelIndexMerge, so they are still
+// counted after a restart. index can be nil if nodes list every node, merging a state of another node is then an
+// error. A query read the counts of every known node, so it read 2 keys per node per key.
+//
+// transport can be nil if states are only exchanged with State and Merge. A state sent by Sync holds the whole counts
+// of its keys, so a lost one is made up by the next Sync of the same keys, or by merging their State.
+func NewPNCounterBackend(node string, nodes []string, store ExtremumBackend, index BytesBackend, transport PNCounterTransport) PNCounterBackend {
+	backend := &pnCounterBackend{
+		node:      node,
+		store:     store,
+		index:     index,
+		transport: transport,
+		nodes:     map[string]bool{node: true},
+		dirty:     map[string]bool{},
+	}
+	for _, it := range nodes {
+		backend.nodes[it] = true
+	}
+	return backend
+}
+
+func positiveKey(key, node string) string {
+	return key + "/p/" + node
+}
+
+func negativeKey(key, node string) string {
+	return key + "/n/" + node
+}
+
+// knownNodes returns the nodes given or learnt, including those learnt by the previous processes if there is an index
+func (p *pnCounterBackend) knownNodes(ctx context.Context) ([]string, error) {
+	stored := []string{}
+	if p.index != nil {
+		encoded, err := queryBytesBackend(ctx, p.index, []string{pnCounterNodesKey})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to query node index")
+		}
+		stored, err = decodeSeriesSet(encoded[0])
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to decode node index")
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, node := range stored {
+		p.nodes[node] = true
+	}
+	nodes := make([]string, 0, len(p.nodes))
+	for node := range p.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// learnNodes add the nodes of a merged state to the known ones, storing them in the index before their counts are
+func (p *pnCounterBackend) learnNodes(ctx context.Context, nodes map[string]bool) error {
+	p.lock.Lock()
+	unknown := []string{}
+	for node := range nodes {
+		if !p.nodes[node] {
+			unknown = append(unknown, node)
+		}
+	}
+	p.lock.Unlock()
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	if p.index == nil {
+		return errors.Errorf("state has counts of unknown node %v, and there is no index to add it to", unknown[0])
+	}
+
+	err := p.index.MergeBytes(ctx, []string{pnCounterNodesKey}, [][]byte{encodeSeriesSet(unknown)})
+	if err != nil {
+		return errors.Wrap(err, "unable to update node index")
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, node := range unknown {
+		p.nodes[node] = true
+	}
+	return nil
+}
+
+// counts returns the count of each node for each key
+func (p *pnCounterBackend) counts(ctx context.Context, keys []string, nodes []string) ([][]PNCount, error) {
+	storeKeys := make([]string, 0, len(keys)*len(nodes)*2)
+	for _, key := range keys {
+		for _, node := range nodes {
+			storeKeys = append(storeKeys, positiveKey(key, node), negativeKey(key, node))
+		}
+	}
+	values, _, err := queryMaxBackend(ctx, p.store, storeKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([][]PNCount, len(keys))
+	for k := range keys {
+		counts[k] = make([]PNCount, len(nodes))
+		for n := range nodes {
+			i := (k*len(nodes) + n) * 2
+			counts[k][n] = PNCount{Positive: values[i], Negative: values[i+1]}
+		}
+	}
+	return counts, nil
+}
+
+func (p *pnCounterBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	nodes, err := p.knownNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := p.counts(ctx, keys, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]int64, len(keys))
+	for k := range keys {
+		for _, count := range counts[k] {
+			results[k] += count.Positive - count.Negative
+		}
+	}
+	return results, nil
+}
+
+func (p *pnCounterBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	deltas := map[string]PNCount{}
+	order := []string{}
+	for i, key := range keys {
+		delta, ok := deltas[key]
+		if !ok {
+			order = append(order, key)
+		}
+		if values[i] > 0 {
+			delta.Positive += values[i]
+		} else {
+			delta.Negative -= values[i]
+		}
+		deltas[key] = delta
+	}
+	if len(order) == 0 {
+		return nil
+	}
+
+	p.incrementLock.Lock()
+	defer p.incrementLock.Unlock()
+
+	counts, err := p.counts(ctx, order, []string{p.node})
+	if err != nil {
+		return err
+	}
+	storeKeys := make([]string, 0, len(order)*2)
+	storeValues := make([]int64, 0, len(order)*2)
+	for k, key := range order {
+		storeKeys = append(storeKeys, positiveKey(key, p.node), negativeKey(key, p.node))
+		storeValues = append(storeValues, counts[k][0].Positive+deltas[key].Positive, counts[k][0].Negative+deltas[key].Negative)
+	}
+	err = p.store.UpdateMax(ctx, storeKeys, storeValues)
+	if err != nil {
+		return err
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, key := range order {
+		p.dirty[key] = true
+	}
+	return nil
+}
+
+func (p *pnCounterBackend) State(ctx context.Context, keys []string) (PNCounterState, error) {
+	nodes, err := p.knownNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := p.counts(ctx, keys, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	state := PNCounterState{}
+	for k, key := range keys {
+		for n, node := range nodes {
+			if counts[k][n] != (PNCount{}) {
+				if state[key] == nil {
+					state[key] = map[string]PNCount{}
+				}
+				state[key][node] = counts[k][n]
+			}
+		}
+	}
+	return state, nil
+}
+
+func (p *pnCounterBackend) Merge(ctx context.Context, state PNCounterState) error {
+	storeKeys := []string{}
+	storeValues := []int64{}
+	nodes := map[string]bool{}
+	for key, counts := range state {
+		for node, count := range counts {
+			nodes[node] = true
+			storeKeys = append(storeKeys, positiveKey(key, node), negativeKey(key, node))
+			storeValues = append(storeValues, count.Positive, count.Negative)
+		}
+	}
+	if len(storeKeys) == 0 {
+		return nil
+	}
+	err := p.learnNodes(ctx, nodes)
+	if err != nil {
+		return err
+	}
+
+	p.incrementLock.Lock()
+	err = p.store.UpdateMax(ctx, storeKeys, storeValues)
+	p.incrementLock.Unlock()
+	if err != nil {
+		return errors.Wrap(err, "unable to merge state")
+	}
+	return nil
+}
+
+func (p *pnCounterBackend) Sync(ctx context.Context) error {
+	if p.transport == nil {
+		return errors.New("no transport to sync with")
+	}
+
+	p.lock.Lock()
+	keys := make([]string, 0, len(p.dirty))
+	for key := range p.dirty {
+		keys = append(keys, key)
+	}
+	p.dirty = map[string]bool{}
+	p.lock.Unlock()
+	sort.Strings(keys)
+
+	if len(keys) > 0 {
+		err := p.send(ctx, keys)
+		if err != nil {
+			p.lock.Lock()
+			for _, key := range keys {
+				p.dirty[key] = true
+			}
+			p.lock.Unlock()
+			return err
+		}
+	}
+
+	states, err := p.transport.Receive(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to receive states")
+	}
+	// a state which failed to merge must not drop the following ones, as they are not received again
+	var firstErr error
+	failed := 0
+	for _, state := range states {
+		err := p.Merge(ctx, state)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		return errors.Wrapf(firstErr, "%v of %v received states failed to merge", failed, len(states))
+	}
+	return nil
+}
+
+func (p *pnCounterBackend) send(ctx context.Context, keys []string) error {
+	state, err := p.State(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(p.transport.Send(ctx, state), "unable to send state")
+}
+
+type inMemoryPNCounterTransport struct {
+	node  string
+	lock  *sync.Mutex
+	inbox map[string][]PNCounterState
+}
+
+// NewInMemoryPNCounterTransports returns a transport for each of nodes, which deliver the states sent by a node to
+// all the others within this process.
+func NewInMemoryPNCounterTransports(nodes ...string) map[string]PNCounterTransport {
+	lock := &sync.Mutex{}
+	inbox := map[string][]PNCounterState{}
+	transports := map[string]PNCounterTransport{}
+	for _, node := range nodes {
+		inbox[node] = nil
+		transports[node] = &inMemoryPNCounterTransport{
+			node:  node,
+			lock:  lock,
+			inbox: inbox,
+		}
+	}
+	return transports
+}
+
+func (t *inMemoryPNCounterTransport) Send(ctx context.Context, state PNCounterState) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for node := range t.inbox {
+		if node != t.node {
+			t.inbox[node] = append(t.inbox[node], state)
+		}
+	}
+	return nil
+}
+
+func (t *inMemoryPNCounterTransport) Receive(ctx context.Context) ([]PNCounterState, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	states := t.inbox[t.node]
+	t.inbox[t.node] = nil
+	return states, nil
+}
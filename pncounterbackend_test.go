@@ -0,0 +1,180 @@
+package rangecounter
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingPNCounterTransport struct {
+	PNCounterTransport
+	failures int
+}
+
+func (f *failingPNCounterTransport) Send(ctx context.Context, state PNCounterState) error {
+	if f.failures > 0 {
+		f.failures--
+		return ErrInjectedFault
+	}
+	return f.PNCounterTransport.Send(ctx, state)
+}
+
+func newTestPNCounterBackends(nodes ...string) map[string]PNCounterBackend {
+	transports := NewInMemoryPNCounterTransports(nodes...)
+	backends := map[string]PNCounterBackend{}
+	for _, node := range nodes {
+		backends[node] = NewPNCounterBackend(node, nodes, NewInMemoryBackend().(ExtremumBackend), nil, transports[node])
+	}
+	return backends
+}
+
+func TestPNCounterBackendConvergence(t *testing.T) {
+	ctx := context.Background()
+	nodes := []string{"eu", "us", "ap"}
+	backends := newTestPNCounterBackends(nodes...)
+	counters := map[string]IntRangeCounter{}
+	for node, backend := range backends {
+		counters[node] = NewRangeTreeIntCounter(backend, 8, 1)
+	}
+
+	expected := int64(0)
+	for i, node := range nodes {
+		for at := int64(0); at < 50; at++ {
+			by := int64(i+1) * at
+			if at%3 == 0 {
+				by = -by
+			}
+			assert.NoError(t, counters[node].Increment(ctx, at, by))
+			expected += by
+		}
+	}
+
+	sum, err := counters["eu"].QuerySum(ctx, 0, 100)
+	assert.NoError(t, err)
+	assert.NotEqual(t, expected, sum, "not synced yet")
+
+	for round := 0; round < 2; round++ {
+		for _, node := range nodes {
+			assert.NoError(t, backends[node].Sync(ctx))
+		}
+	}
+	partial, err := counters["eu"].QuerySum(ctx, 10, 20)
+	assert.NoError(t, err)
+	for _, node := range nodes {
+		sum, err := counters[node].QuerySum(ctx, 0, 100)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, sum, "node %v converged", node)
+		sum, err = counters[node].QuerySum(ctx, 10, 20)
+		assert.NoError(t, err)
+		assert.Equal(t, partial, sum, "node %v converged", node)
+	}
+}
+
+func TestPNCounterBackendMerge(t *testing.T) {
+	ctx := context.Background()
+	backends := newTestPNCounterBackends("a", "b")
+	assert.NoError(t, backends["a"].Increment(ctx, []string{"x", "y", "x"}, []int64{5, -3, -2}))
+	assert.NoError(t, backends["b"].Increment(ctx, []string{"x"}, []int64{7}))
+
+	stateA, err := backends["a"].State(ctx, []string{"x", "y", "missing"})
+	assert.NoError(t, err)
+	assert.Equal(t, PNCounterState{
+		"x": {"a": {Positive: 5, Negative: 2}},
+		"y": {"a": {Negative: 3}},
+	}, stateA)
+	stateB, err := backends["b"].State(ctx, []string{"x"})
+	assert.NoError(t, err)
+
+	encoded, err := json.Marshal(stateB)
+	assert.NoError(t, err)
+	decoded := PNCounterState{}
+	assert.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, stateB, decoded)
+
+	first := NewPNCounterBackend("c", nil, NewInMemoryBackend().(ExtremumBackend), NewInMemoryBytesBackend(LabelIndexMerge), nil)
+	second := NewPNCounterBackend("d", nil, NewInMemoryBackend().(ExtremumBackend), NewInMemoryBytesBackend(LabelIndexMerge), nil)
+	for _, state := range []PNCounterState{stateA, stateB, stateA, stateB} {
+		assert.NoError(t, first.Merge(ctx, state))
+	}
+	for _, state := range []PNCounterState{stateB, stateA} {
+		assert.NoError(t, second.Merge(ctx, state))
+	}
+
+	for _, backend := range []PNCounterBackend{first, second} {
+		results, err := backend.Query(ctx, []string{"x", "y"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{10, -3}, results, "merged in any order and any number of times")
+	}
+	assert.Error(t, first.Sync(ctx), "no transport")
+}
+
+func TestPNCounterBackendFailedSend(t *testing.T) {
+	ctx := context.Background()
+	transports := NewInMemoryPNCounterTransports("a", "b")
+	transport := &failingPNCounterTransport{PNCounterTransport: transports["a"], failures: 1}
+	a := NewPNCounterBackend("a", nil, NewInMemoryBackend().(ExtremumBackend), NewInMemoryBytesBackend(LabelIndexMerge), transport)
+	b := NewPNCounterBackend("b", nil, NewInMemoryBackend().(ExtremumBackend), NewInMemoryBytesBackend(LabelIndexMerge), transports["b"])
+
+	assert.NoError(t, a.Increment(ctx, []string{"x"}, []int64{3}))
+	assert.ErrorIs(t, a.Sync(ctx), ErrInjectedFault)
+	assert.NoError(t, a.Sync(ctx), "the changed keys are sent again")
+	assert.NoError(t, b.Sync(ctx))
+
+	results, err := b.Query(ctx, []string{"x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3}, results, "node a is learnt from its state")
+}
+
+func TestPNCounterBackendRestart(t *testing.T) {
+	ctx := context.Background()
+	store := NewInMemoryBackend().(ExtremumBackend)
+	index := NewInMemoryBytesBackend(LabelIndexMerge)
+	state := PNCounterState{"x": {"b": {Positive: 4}, "c": {Negative: 1}}}
+
+	backend := NewPNCounterBackend("a", nil, store, index, nil)
+	assert.NoError(t, backend.Increment(ctx, []string{"x"}, []int64{2}))
+	assert.NoError(t, backend.Merge(ctx, state))
+
+	restarted := NewPNCounterBackend("a", nil, store, index, nil)
+	results, err := restarted.Query(ctx, []string{"x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, results, "the learnt nodes are read from the index")
+
+	withoutIndex := NewPNCounterBackend("a", []string{"b"}, NewInMemoryBackend().(ExtremumBackend), nil, nil)
+	assert.Error(t, withoutIndex.Merge(ctx, state), "node c is not listed")
+	results, err = withoutIndex.Query(ctx, []string{"x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{0}, results, "nothing of the state is merged")
+	assert.NoError(t, withoutIndex.Merge(ctx, PNCounterState{"x": {"b": {Positive: 4}}}))
+}
+
+func TestPNCounterBackendFailedMerge(t *testing.T) {
+	ctx := context.Background()
+	transports := NewInMemoryPNCounterTransports("a", "b", "c")
+	a := NewPNCounterBackend("a", []string{"a", "c"}, NewInMemoryBackend().(ExtremumBackend), nil, transports["a"])
+	b := NewPNCounterBackend("b", []string{"b"}, NewInMemoryBackend().(ExtremumBackend), nil, transports["b"])
+	c := NewPNCounterBackend("c", []string{"b", "c"}, NewInMemoryBackend().(ExtremumBackend), nil, transports["c"])
+
+	assert.NoError(t, b.Increment(ctx, []string{"x"}, []int64{2}))
+	assert.NoError(t, b.Sync(ctx))
+	assert.NoError(t, c.Increment(ctx, []string{"x"}, []int64{5}))
+	assert.NoError(t, c.Sync(ctx))
+	assert.Error(t, a.Sync(ctx), "node b is unknown and there is no index")
+
+	results, err := a.Query(ctx, []string{"x"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, results, "the state after the failed one is still merged")
+}
+
+func TestPNCounterBackendShortResults(t *testing.T) {
+	ctx := context.Background()
+	store := shortExtremumBackend{NewInMemoryBackend().(*inMemoryBackend[int64])}
+	_, err := NewPNCounterBackend("a", []string{"a"}, store, nil, nil).Query(ctx, []string{"x"})
+	assert.Error(t, err)
+
+	index := shortBytesBackend{NewInMemoryBytesBackend(LabelIndexMerge)}
+	_, err = NewPNCounterBackend("a", nil, NewInMemoryBackend().(ExtremumBackend), index, nil).Query(ctx, []string{"x"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,155 @@
+package rangecounter
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// prefixSumBlockSize is the number of indexes sharing a block key. A range of indexes is incremented through the
+// block keys it covers whole, plus the index keys at both ends.
+const prefixSumBlockSize = 256
+
+type prefixSumIntRangeCounter struct {
+	backend Backend
+	prefix  string
+	overlay IntRangeCounter
+
+	// writeLock serialize the read-modify-write of Increment
+	writeLock sync.Mutex
+}
+
+// NewPrefixSumIntRangeCounter store the running total up to each index under prefix, so that a QuerySum read only
+// the total at `to` and `from-1`, plus the head index. It is meant for counters which are written almost exclusively
+// at the latest index, like "now". The total at an index is the sum of its key and of the key of its block of
+// prefixSumBlockSize indexes, so a write ahead of the head, which copy the head's total to every index in between,
+// write at most 2*prefixSumBlockSize index keys plus one key per block in between.
+// A late write, behind the head, is propagated to every index up to the head the same way if overlay is nil.
+// Otherwise it is recorded in the overlay, which is then also queried on every QuerySum.
+// Increment read before it write, so the increments of the returned counter are serialized, and it should be the only
+// writer of its backend and prefix. Indexes must not be negative.
+func NewPrefixSumIntRangeCounter(backend Backend, prefix string, overlay IntRangeCounter) IntRangeCounter {
+	return &prefixSumIntRangeCounter{
+		backend: backend,
+		prefix:  prefix,
+		overlay: overlay,
+	}
+}
+
+func (p *prefixSumIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	if from < 0 || to < 0 {
+		return 0, errors.Errorf("prefix sum index must not be negative, got %v to %v", from, to)
+	}
+
+	keys := append([]string{p.headKey()}, p.totalKeys(to)...)
+	keys = append(keys, p.totalKeys(from-1)...)
+	results, err := queryBackend(ctx, p.backend, keys)
+	if err != nil {
+		return 0, err
+	}
+	head, toTotal, beforeFromTotal := results[0]-1, results[1]+results[2], results[3]+results[4]
+
+	if head >= 0 && to > head {
+		// Nothing is written after the head, so the total stays the same
+		headResults, err := queryBackend(ctx, p.backend, p.totalKeys(head))
+		if err != nil {
+			return 0, err
+		}
+		toTotal = headResults[0] + headResults[1]
+		if from-1 > head {
+			beforeFromTotal = toTotal
+		}
+	}
+
+	sum := toTotal - beforeFromTotal
+	if p.overlay != nil {
+		overlaySum, err := p.overlay.QuerySum(ctx, from, to)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to query overlay")
+		}
+		sum += overlaySum
+	}
+	return sum, nil
+}
+
+func (p *prefixSumIntRangeCounter) Increment(ctx context.Context, at int64, by int64) error {
+	if at < 0 {
+		return errors.Errorf("prefix sum index must not be negative, got %v", at)
+	}
+
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	results, err := queryBackend(ctx, p.backend, []string{p.headKey()})
+	if err != nil {
+		return err
+	}
+	head := results[0] - 1
+
+	if head < 0 {
+		return p.backend.Increment(ctx, []string{p.getKey(at), p.headKey()}, []int64{by, at + 1})
+	}
+
+	if at > head {
+		headResults, err := queryBackend(ctx, p.backend, p.totalKeys(head))
+		if err != nil {
+			return err
+		}
+		headTotal := headResults[0] + headResults[1]
+
+		keys, values := p.rangeIncrement(head+1, at-1, headTotal)
+		keys = append(keys, p.getKey(at), p.headKey())
+		values = append(values, headTotal+by, at-head)
+		return p.backend.Increment(ctx, keys, values)
+	}
+
+	if p.overlay != nil {
+		return p.overlay.Increment(ctx, at, by)
+	}
+
+	keys, values := p.rangeIncrement(at, head, by)
+	return p.backend.Increment(ctx, keys, values)
+}
+
+// rangeIncrement returns the keys and values adding by to the total of every index from `from` to `to`
+func (p *prefixSumIntRangeCounter) rangeIncrement(from, to int64, by int64) ([]string, []int64) {
+	keys := []string{}
+	values := []int64{}
+	for from <= to {
+		block := floorDiv(from, prefixSumBlockSize)
+		blockEnd := (block+1)*prefixSumBlockSize - 1
+		if from == block*prefixSumBlockSize && blockEnd <= to {
+			keys = append(keys, p.getBlockKey(block))
+			values = append(values, by)
+			from = blockEnd + 1
+			continue
+		}
+		keys = append(keys, p.getKey(from))
+		values = append(values, by)
+		from++
+	}
+	return keys, values
+}
+
+// totalKeys returns the keys whose sum is the total at idx
+func (p *prefixSumIntRangeCounter) totalKeys(idx int64) []string {
+	return []string{p.getKey(idx), p.getBlockKey(floorDiv(idx, prefixSumBlockSize))}
+}
+
+func (p *prefixSumIntRangeCounter) headKey() string {
+	return p.prefix + ":head"
+}
+
+func (p *prefixSumIntRangeCounter) getKey(idx int64) string {
+	return p.prefix + ":" + strconv.FormatInt(idx, 10)
+}
+
+func (p *prefixSumIntRangeCounter) getBlockKey(block int64) string {
+	return p.prefix + ":block:" + strconv.FormatInt(block, 10)
+}
+
+func (p *prefixSumIntRangeCounter) String() string {
+	return "prefixSumIntRangeCounter(" + p.prefix + ")"
+}
@@ -0,0 +1,117 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixSumIntRangeCounterLateWrites(t *testing.T) {
+	counterToTest := map[string]func(backend Backend) IntRangeCounter{
+		"propagate": func(backend Backend) IntRangeCounter {
+			return NewPrefixSumIntRangeCounter(backend, "prefixsum", nil)
+		},
+		"overlay": func(backend Backend) IntRangeCounter {
+			return NewPrefixSumIntRangeCounter(backend, "prefixsum", NewRangeTreeIntCounter(backend, 8, 1))
+		},
+	}
+	for name, factory := range counterToTest {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			counter := factory(NewInMemoryBackend())
+
+			for _, it := range []struct{ at, by int64 }{{5, 1}, {8, 2}, {6, 4}, {5, 8}, {12, 16}, {2, 32}} {
+				assert.NoError(t, counter.Increment(ctx, it.at, it.by))
+			}
+
+			for _, q := range []struct{ from, to, expected int64 }{
+				{0, 1, 0},
+				{0, 4, 32},
+				{5, 5, 9},
+				{5, 6, 13},
+				{6, 8, 6},
+				{9, 11, 0},
+				{0, 12, 63},
+				{12, 20, 16},
+				{13, 20, 0},
+			} {
+				sum, err := counter.QuerySum(ctx, q.from, q.to)
+				assert.NoError(t, err)
+				assert.EqualValues(t, q.expected, sum, "%v to %v", q.from, q.to)
+			}
+		})
+	}
+}
+
+func TestPrefixSumIntRangeCounterQueryKeys(t *testing.T) {
+	ctx := context.Background()
+	backend := NewBenchmarkBackend()
+	counter := NewPrefixSumIntRangeCounter(backend, "prefixsum", nil)
+	for i := int64(0); i < 100; i++ {
+		assert.NoError(t, counter.Increment(ctx, i, 1))
+	}
+
+	backend.queryCall, backend.queryKeyTouched = 0, 0
+	sum, err := counter.QuerySum(ctx, 10, 89)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 80, sum)
+	assert.EqualValues(t, 1, backend.queryCall)
+	assert.EqualValues(t, 5, backend.queryKeyTouched)
+
+	_, err = counter.QuerySum(ctx, -1, 10)
+	assert.Error(t, err)
+	assert.Error(t, counter.Increment(ctx, -1, 1))
+}
+
+func TestPrefixSumIntRangeCounterGap(t *testing.T) {
+	ctx := context.Background()
+	backend := NewBenchmarkBackend()
+	counter := NewPrefixSumIntRangeCounter(backend, "prefixsum", nil)
+	assert.NoError(t, counter.Increment(ctx, 10, 1))
+
+	backend.incrementCall, backend.incrementKeyTouched = 0, 0
+	assert.NoError(t, counter.Increment(ctx, 1000000, 2))
+	assert.EqualValues(t, 1, backend.incrementCall)
+	assert.LessOrEqual(t, backend.incrementKeyTouched, int64(2*prefixSumBlockSize+1000000/prefixSumBlockSize+2))
+
+	assert.NoError(t, counter.Increment(ctx, 300, 4))
+	for _, q := range []struct{ from, to, expected int64 }{
+		{0, 10, 1},
+		{11, 299, 0},
+		{300, 300, 4},
+		{301, 999999, 0},
+		{0, 1000000, 7},
+		{999999, 2000000, 2},
+	} {
+		sum, err := counter.QuerySum(ctx, q.from, q.to)
+		assert.NoError(t, err)
+		assert.EqualValues(t, q.expected, sum, "%v to %v", q.from, q.to)
+	}
+}
+
+func TestPrefixSumIntRangeCounterPrefix(t *testing.T) {
+	ctx := context.Background()
+	backend := NewInMemoryBackend()
+	first := NewPrefixSumIntRangeCounter(backend, "first", nil)
+	second := NewPrefixSumIntRangeCounter(backend, "second", nil)
+	assert.NoError(t, first.Increment(ctx, 5, 1))
+	assert.NoError(t, second.Increment(ctx, 2, 2))
+
+	sum, err := first.QuerySum(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, sum)
+	sum, err = second.QuerySum(ctx, 0, 10)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, sum)
+}
+
+func TestPrefixSumIntRangeCounterTruncatedResults(t *testing.T) {
+	backend := NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+		return Fault{TruncateResults: 1}
+	})
+	counter := NewPrefixSumIntRangeCounter(backend, "prefixsum", nil)
+	_, err := counter.QuerySum(context.Background(), 0, 10)
+	assert.Error(t, err)
+	assert.Error(t, counter.Increment(context.Background(), 0, 1))
+}
@@ -0,0 +1,87 @@
+package rangecounter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuantileRangeCounter record values, such as latency, and estimate their quantiles over a range of time.
+// Each returned value is within the relative accuracy of the actual quantile, or NaN if nothing was recorded.
+// Only finite values can be recorded.
+type QuantileRangeCounter interface {
+	Record(ctx context.Context, at time.Time, value float64) error
+	QueryQuantiles(ctx context.Context, at time.Time, bucketCount int, quantiles []float64) ([]float64, error)
+}
+
+type ddSketchRangeCounter struct {
+	layout           DateLayout
+	backend          BytesBackend
+	relativeAccuracy float64
+}
+
+// NewQuantileRangeCounter store a DDSketch per key of the layout. The backend must merge the sketch with
+// DDSketchMerge. With a range tree layout, the sketches of a range is merged from O(log n) nodes.
+func NewQuantileRangeCounter(layout DateLayout, backend BytesBackend, relativeAccuracy float64) QuantileRangeCounter {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		panic("relativeAccuracy must be between 0 and 1")
+	}
+	return &ddSketchRangeCounter{
+		layout:           layout,
+		backend:          backend,
+		relativeAccuracy: relativeAccuracy,
+	}
+}
+
+func (d *ddSketchRangeCounter) Record(ctx context.Context, at time.Time, value float64) error {
+	keys, err := d.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+
+	sketch := newDDSketch(d.relativeAccuracy)
+	err = sketch.add(value)
+	if err != nil {
+		return err
+	}
+	update := sketch.marshal()
+	values := make([][]byte, len(keys))
+	for i := range values {
+		values[i] = update
+	}
+
+	err = d.backend.MergeBytes(ctx, keys, values)
+	if err != nil {
+		return errors.Wrap(err, "unable to merge sketches")
+	}
+	return nil
+}
+
+func (d *ddSketchRangeCounter) QueryQuantiles(ctx context.Context, at time.Time, bucketCount int, quantiles []float64) ([]float64, error) {
+	keys, err := d.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := d.backend.QueryBytes(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query sketches")
+	}
+
+	sketch := newDDSketch(d.relativeAccuracy)
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		err = sketch.merge(result)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to merge sketch of key %v", keys[i])
+		}
+	}
+	return sketch.quantiles(quantiles), nil
+}
+
+func (d *ddSketchRangeCounter) String() string {
+	return "ddSketchRangeCounter(" + d.layout.String() + ")"
+}
@@ -0,0 +1,78 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuantileRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type queryReq struct {
+		dateOffset  int
+		bucketCount int
+		quantiles   []float64
+		expected    []float64
+	}
+	queries := []queryReq{
+		{9, 10, []float64{0, 0.5, 0.99, 1}, []float64{1, 500, 990, 1000}},
+		{0, 1, []float64{0, 0.5, 1}, []float64{10, 500, 1000}},
+		{4, 2, []float64{0, 1}, []float64{3, 994}},
+		{20, 1, []float64{0, 0.5, 1}, []float64{-5, 0, 0}},
+		{21, 25, []float64{0, 1}, []float64{-5, 1000}},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket":  NewBucketDateLayout(Hour),
+		"tree-8":  NewRangeTreeDateLayout(Hour, 8, 1),
+		"tree-16": NewRangeTreeDateLayout(Hour, 16, 3),
+	}
+	for layoutName, layout := range layoutToTest {
+		t.Run("layout "+layoutName, func(t *testing.T) {
+			ctx := context.Background()
+			counter := NewQuantileRangeCounter(layout, NewInMemoryBytesBackend(DDSketchMerge), 0.01)
+
+			for i := 1; i <= 1000; i++ {
+				err := counter.Record(ctx, Hour.incrementDateForce(i%10, baseDate), float64(i))
+				assert.NoError(t, err)
+			}
+			for _, value := range []float64{-5, 0, 0} {
+				err := counter.Record(ctx, Hour.incrementDateForce(20, baseDate), value)
+				assert.NoError(t, err)
+			}
+
+			for _, q := range queries {
+				results, err := counter.QueryQuantiles(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount, q.quantiles)
+				assert.NoError(t, err)
+				for i, expected := range q.expected {
+					assert.InDelta(t, expected, results[i], 0.01*math.Abs(expected))
+				}
+			}
+
+			results, err := counter.QueryQuantiles(ctx, Hour.incrementDateForce(15, baseDate), 3, []float64{0.5})
+			assert.NoError(t, err)
+			assert.True(t, math.IsNaN(results[0]))
+		})
+	}
+}
+
+func TestQuantileRangeCounterNonFinite(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	counter := NewQuantileRangeCounter(NewBucketDateLayout(Hour), NewInMemoryBytesBackend(DDSketchMerge), 0.01)
+
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		assert.Error(t, counter.Record(ctx, at, value), value)
+	}
+	assert.NoError(t, counter.Record(ctx, at, math.MaxFloat64))
+	assert.NoError(t, counter.Record(ctx, at, -math.MaxFloat64))
+
+	quantiles, err := counter.QueryQuantiles(ctx, at, 1, []float64{0, 1})
+	assert.NoError(t, err)
+	assert.InEpsilon(t, -math.MaxFloat64, quantiles[0], 0.01)
+	assert.InEpsilon(t, math.MaxFloat64, quantiles[1], 0.01)
+}
@@ -0,0 +1,175 @@
+package rangecountermemcache
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer speak the part of the memcached text protocol used by the backend, with the same counter semantic:
+// incr wrap around, decr stop at zero.
+type fakeServer struct {
+	listener net.Listener
+
+	lock        sync.Mutex
+	values      map[string]string
+	connections int
+	// beforeAdd and beforeIncrement are called before each add, incr or decr is processed, without the lock
+	beforeAdd       func(key string)
+	beforeIncrement func(key string)
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeServer{
+		listener: listener,
+		values:   map[string]string{},
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	go server.accept()
+	return server
+}
+
+func (f *fakeServer) address() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeServer) set(key string, value string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.values[key] = value
+}
+
+func (f *fakeServer) delete(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.values, key)
+}
+
+func (f *fakeServer) get(key string) (string, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	value, ok := f.values[key]
+	return value, ok
+}
+
+func (f *fakeServer) accept() {
+	for {
+		netConn, err := f.listener.Accept()
+		if err != nil {
+			return
+		}
+		f.lock.Lock()
+		f.connections++
+		f.lock.Unlock()
+		go f.serve(netConn)
+	}
+}
+
+func (f *fakeServer) serve(netConn net.Conn) {
+	defer netConn.Close()
+	reader := bufio.NewReader(netConn)
+	writer := bufio.NewWriter(netConn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			writer.WriteString("ERROR\r\n")
+			continue
+		}
+
+		switch fields[0] {
+		case "get":
+			f.lock.Lock()
+			for _, key := range fields[1:] {
+				if value, ok := f.values[key]; ok {
+					writer.WriteString("VALUE " + key + " 0 " + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n")
+				}
+			}
+			f.lock.Unlock()
+			writer.WriteString("END\r\n")
+		case "incr", "decr":
+			writer.WriteString(f.increment(fields) + "\r\n")
+		case "add":
+			if len(fields) != 5 {
+				writer.WriteString("ERROR\r\n")
+				continue
+			}
+			length, err := strconv.Atoi(fields[4])
+			if err != nil {
+				writer.WriteString("CLIENT_ERROR bad command line format\r\n")
+				continue
+			}
+			data := make([]byte, length+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			if f.beforeAdd != nil {
+				f.beforeAdd(fields[1])
+			}
+			f.lock.Lock()
+			if _, ok := f.values[fields[1]]; ok {
+				writer.WriteString("NOT_STORED\r\n")
+			} else {
+				f.values[fields[1]] = string(data[:length])
+				writer.WriteString("STORED\r\n")
+			}
+			f.lock.Unlock()
+		default:
+			writer.WriteString("ERROR\r\n")
+		}
+
+		// reply to a pipeline once all its commands are read
+		if reader.Buffered() == 0 {
+			if err := writer.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (f *fakeServer) increment(fields []string) string {
+	if len(fields) != 3 {
+		return "ERROR"
+	}
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR invalid numeric delta argument"
+	}
+	if f.beforeIncrement != nil {
+		f.beforeIncrement(fields[1])
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	value, ok := f.values[fields[1]]
+	if !ok {
+		return "NOT_FOUND"
+	}
+	current, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	}
+	switch {
+	case fields[0] == "incr":
+		current += delta
+	case delta > current:
+		current = 0
+	default:
+		current -= delta
+	}
+	f.values[fields[1]] = strconv.FormatUint(current, 10)
+	return f.values[fields[1]]
+}
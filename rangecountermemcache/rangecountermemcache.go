@@ -0,0 +1,351 @@
+// Package rangecountermemcache store counters in memcached, over its text protocol.
+//
+// Memcached counters are unsigned 64 bit integers, so values are stored offset by 1<<63: zero is stored as
+// 9223372036854775808 and negative values below it, which incr and decr keep right as long as the value fit in an
+// int64. As decr stop at zero, a value going below math.MinInt64 stay there instead of wrapping around.
+//
+// Keys are escaped into valid memcached keys: spaces, control characters and "%" are percent-encoded, and keys still
+// longer than 250 bytes are replaced by their sha256.
+package rangecountermemcache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/pkg/errors"
+)
+
+const (
+	offset       = uint64(1) << 63
+	maxKeyLength = 250
+	// maxAddAttempts is how many times a missing key is incremented then added, before giving up on a key which
+	// keep being added and evicted
+	maxAddAttempts = 3
+)
+
+// Backend is a rangecounter.Backend over a memcached server.
+type Backend interface {
+	rangecounter.Backend
+	// Close the idle connections. Calls made after open new ones.
+	Close() error
+}
+
+// BackendOptions configure NewBackend.
+type BackendOptions struct {
+	// Timeout of each call if its context has no deadline, 1 second if zero
+	Timeout time.Duration
+	// MaxIdleConns is the number of connections kept open between calls, 2 if zero
+	MaxIdleConns int
+}
+
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+type backend struct {
+	address string
+	options BackendOptions
+
+	lock sync.Mutex
+	idle []*conn
+}
+
+// NewBackend store the values in the memcached server at address. Query is a single multi-get, and Increment a
+// pipeline of incr and decr, adding the keys which are missing. Memcached can evict keys, so it is best used with
+// enough memory for the counters, or for counters which can be lost.
+func NewBackend(address string, options BackendOptions) Backend {
+	if options.Timeout <= 0 {
+		options.Timeout = time.Second
+	}
+	if options.MaxIdleConns <= 0 {
+		options.MaxIdleConns = 2
+	}
+	return &backend{
+		address: address,
+		options: options,
+	}
+}
+
+// encode returns the unsigned value stored for value
+func encode(value int64) uint64 {
+	return uint64(value) ^ offset
+}
+
+func decode(stored uint64) int64 {
+	return int64(stored ^ offset)
+}
+
+// escapeKey returns a valid memcached key for key, unique to it
+func escapeKey(key string) string {
+	escaped := strings.Builder{}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c <= ' ' || c == 0x7f || c == '%' {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+			continue
+		}
+		escaped.WriteByte(c)
+	}
+	if escaped.Len() == 0 || escaped.Len() > maxKeyLength {
+		// "%%" never appear in an escaped key
+		hash := sha256.Sum256([]byte(key))
+		return "%%" + hex.EncodeToString(hash[:])
+	}
+	return escaped.String()
+}
+
+func (b *backend) Close() error {
+	b.lock.Lock()
+	idle := b.idle
+	b.idle = nil
+	b.lock.Unlock()
+
+	var closeErr error
+	for _, c := range idle {
+		if err := c.Close(); err != nil && closeErr == nil {
+			closeErr = errors.Wrap(err, "unable to close connection")
+		}
+	}
+	return closeErr
+}
+
+func (b *backend) get(ctx context.Context) (*conn, error) {
+	b.lock.Lock()
+	if len(b.idle) > 0 {
+		c := b.idle[len(b.idle)-1]
+		b.idle = b.idle[:len(b.idle)-1]
+		b.lock.Unlock()
+		return c, nil
+	}
+	b.lock.Unlock()
+
+	dialer := net.Dialer{Timeout: b.options.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", b.address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to %v", b.address)
+	}
+	return &conn{
+		Conn:   netConn,
+		reader: bufio.NewReader(netConn),
+		writer: bufio.NewWriter(netConn),
+	}, nil
+}
+
+func (b *backend) put(c *conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.idle) >= b.options.MaxIdleConns {
+		c.Close()
+		return
+	}
+	b.idle = append(b.idle, c)
+}
+
+// withConn call call with a connection, which is only reused if it succeed, as the replies left on a failed one are
+// unknown.
+func (b *backend) withConn(ctx context.Context, call func(c *conn) error) error {
+	c, err := b.get(ctx)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(b.options.Timeout)
+	}
+	if err := c.SetDeadline(deadline); err != nil {
+		c.Close()
+		return errors.Wrap(err, "unable to set deadline")
+	}
+	stop := context.AfterFunc(ctx, func() {
+		// unblock the pending read or write
+		c.SetDeadline(time.Unix(1, 0))
+	})
+
+	err = call(c)
+	if !stop() {
+		c.Close()
+		return errors.Wrap(ctx.Err(), "memcached call cancelled")
+	}
+	if err != nil {
+		c.Close()
+		return err
+	}
+	b.put(c)
+	return nil
+}
+
+// readLine returns a reply line without its "\r\n", or an error if it is an error reply
+func (c *conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(err, "unable to read reply")
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", errors.Errorf("memcached replied %v", line)
+	}
+	return line, nil
+}
+
+func (b *backend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	results := make([]int64, len(keys))
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	escaped := make([]string, len(keys))
+	for i, key := range keys {
+		escaped[i] = escapeKey(key)
+	}
+	values := map[string]int64{}
+	err := b.withConn(ctx, func(c *conn) error {
+		c.writer.WriteString("get " + strings.Join(escaped, " ") + "\r\n")
+		if err := c.writer.Flush(); err != nil {
+			return errors.Wrap(err, "unable to send get")
+		}
+
+		for {
+			line, err := c.readLine()
+			if err != nil {
+				return err
+			}
+			if line == "END" {
+				return nil
+			}
+			// VALUE <key> <flags> <bytes> [<cas unique>]
+			fields := strings.Fields(line)
+			if len(fields) < 4 || fields[0] != "VALUE" {
+				return errors.Errorf("unexpected reply %v", line)
+			}
+			length, err := strconv.Atoi(fields[3])
+			if err != nil || length < 0 {
+				return errors.Errorf("unexpected reply %v", line)
+			}
+			data := make([]byte, length+2)
+			if _, err := io.ReadFull(c.reader, data); err != nil {
+				return errors.Wrap(err, "unable to read value")
+			}
+			stored, err := strconv.ParseUint(string(data[:length]), 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "value of key %v is not a counter", fields[1])
+			}
+			values[fields[1]] = decode(stored)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range escaped {
+		results[i] = values[key]
+	}
+	return results, nil
+}
+
+func (b *backend) Increment(ctx context.Context, keys []string, values []int64) error {
+	pending := []int{}
+	for i, value := range values {
+		if value != 0 {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	escaped := make([]string, len(keys))
+	for _, i := range pending {
+		escaped[i] = escapeKey(keys[i])
+	}
+	return b.withConn(ctx, func(c *conn) error {
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt == maxAddAttempts {
+				return errors.Errorf("unable to increment %v keys, they keep being evicted", len(pending))
+			}
+
+			missing, err := incrementPipeline(c, escaped, values, pending)
+			if err != nil {
+				return err
+			}
+			pending, err = addPipeline(c, escaped, values, missing)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// incrementPipeline send an incr or decr for each of indexes, and returns those whose key is missing
+func incrementPipeline(c *conn, keys []string, values []int64, indexes []int) ([]int, error) {
+	for _, i := range indexes {
+		if values[i] > 0 {
+			c.writer.WriteString("incr " + keys[i] + " " + strconv.FormatUint(uint64(values[i]), 10) + "\r\n")
+		} else {
+			// -values[i] overflow for math.MinInt64, but its uint64 is still right
+			c.writer.WriteString("decr " + keys[i] + " " + strconv.FormatUint(uint64(-values[i]), 10) + "\r\n")
+		}
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, errors.Wrap(err, "unable to send increments")
+	}
+
+	missing := []int{}
+	for _, i := range indexes {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to increment key %v", keys[i])
+		}
+		if line == "NOT_FOUND" {
+			missing = append(missing, i)
+			continue
+		}
+		if _, err := strconv.ParseUint(line, 10, 64); err != nil {
+			return nil, errors.Errorf("unexpected reply %v to increment of key %v", line, keys[i])
+		}
+	}
+	return missing, nil
+}
+
+// addPipeline send an add of its offset value for each of indexes, and returns those which were added meanwhile and
+// need to be incremented again
+func addPipeline(c *conn, keys []string, values []int64, indexes []int) ([]int, error) {
+	if len(indexes) == 0 {
+		return nil, nil
+	}
+	for _, i := range indexes {
+		data := strconv.FormatUint(encode(values[i]), 10)
+		c.writer.WriteString("add " + keys[i] + " 0 0 " + strconv.Itoa(len(data)) + "\r\n" + data + "\r\n")
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, errors.Wrap(err, "unable to send adds")
+	}
+
+	retry := []int{}
+	for _, i := range indexes {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to add key %v", keys[i])
+		}
+		switch line {
+		case "STORED":
+		case "NOT_STORED":
+			retry = append(retry, i)
+		default:
+			return nil, errors.Errorf("unexpected reply %v to add of key %v", line, keys[i])
+		}
+	}
+	return retry, nil
+}
@@ -0,0 +1,154 @@
+package rangecountermemcache
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/asdacap/rangecounter/rangecountertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendConformance(t *testing.T) {
+	rangecountertest.TestBackend(t, func() rangecounter.Backend {
+		return NewBackend(newFakeServer(t).address(), BackendOptions{})
+	})
+}
+
+func TestOffsetEncoding(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	backend := NewBackend(server.address(), BackendOptions{})
+
+	tests := []struct {
+		by       int64
+		expected int64
+		stored   string
+	}{
+		{-5, -5, "9223372036854775803"},
+		{7, 2, "9223372036854775810"},
+		{-2, 0, "9223372036854775808"},
+		{math.MinInt64, math.MinInt64, "0"},
+		// decr stop at zero, and incr wrap around
+		{-1, math.MinInt64, "0"},
+		{math.MaxInt64, -1, "9223372036854775807"},
+		{math.MaxInt64, math.MaxInt64 - 1, "18446744073709551614"},
+		{2, math.MinInt64, "0"},
+	}
+	for _, test := range tests {
+		assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{test.by}))
+		results, err := backend.Query(ctx, []string{"a"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{test.expected}, results, "incremented by %v", test.by)
+		stored, _ := server.get("a")
+		assert.Equal(t, test.stored, stored, "incremented by %v", test.by)
+	}
+}
+
+func TestEscapeKey(t *testing.T) {
+	long := strings.Repeat("k", 251)
+	tests := map[string]string{
+		"a:1:2":        "a:1:2",
+		"also missing": "also%20missing",
+		"100%":         "100%25",
+		"tab\tnl\n":    "tab%09nl%0A",
+		"":             "%%e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		long[:250]:     long[:250],
+	}
+	for key, expected := range tests {
+		assert.Equal(t, expected, escapeKey(key), key)
+	}
+	assert.True(t, strings.HasPrefix(escapeKey(long), "%%"))
+	assert.Len(t, escapeKey(long), 66)
+	assert.NotEqual(t, escapeKey(long), escapeKey(long+"k"))
+}
+
+func TestIncrementAddedMeanwhile(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	added := 0
+	server.beforeAdd = func(key string) {
+		// another client add the key between our incr and add
+		if added < 1 {
+			server.set(key, "9223372036854775818")
+		}
+		added++
+	}
+	backend := NewBackend(server.address(), BackendOptions{})
+
+	assert.NoError(t, backend.Increment(ctx, []string{"a", "b", "a"}, []int64{1, 0, 2}))
+	results, err := backend.Query(ctx, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{13, 0}, results)
+	assert.Equal(t, 2, added, "both increments of the missing key tried to add it")
+}
+
+func TestIncrementKeepEvicted(t *testing.T) {
+	server := newFakeServer(t)
+	server.beforeAdd = func(key string) {
+		server.set(key, "9223372036854775808")
+	}
+	server.beforeIncrement = func(key string) {
+		server.delete(key)
+	}
+	backend := NewBackend(server.address(), BackendOptions{})
+	assert.Error(t, backend.Increment(context.Background(), []string{"a"}, []int64{1}))
+}
+
+func TestServerErrors(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	server.set("text", "hello")
+	backend := NewBackend(server.address(), BackendOptions{})
+
+	_, err := backend.Query(ctx, []string{"a", "text"})
+	assert.Error(t, err)
+	assert.Error(t, backend.Increment(ctx, []string{"text"}, []int64{1}))
+
+	assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{1}), "the failed connections are not reused")
+	assert.NoError(t, backend.Close())
+}
+
+func TestConnectionReuse(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	backend := NewBackend(server.address(), BackendOptions{})
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{1}))
+		_, err := backend.Query(ctx, []string{"a"})
+		assert.NoError(t, err)
+	}
+	server.lock.Lock()
+	assert.Equal(t, 1, server.connections)
+	server.lock.Unlock()
+}
+
+func TestStalledServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	backend := NewBackend(listener.Addr().String(), BackendOptions{Timeout: 20 * time.Millisecond})
+	_, err = backend.Query(context.Background(), []string{"a"})
+	assert.Error(t, err, "timed out")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	start := time.Now()
+	_, err = NewBackend(listener.Addr().String(), BackendOptions{Timeout: time.Hour}).Query(ctx, []string{"a"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,57 @@
+package rangecounterotel
+
+import (
+	"context"
+
+	"github.com/asdacap/rangecounter"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedBackend struct {
+	backend rangecounter.Backend
+	tracer  trace.Tracer
+	name    string
+}
+
+// TraceBackend create a span for each call to backend, with the backend name and key count. The capabilities of
+// backend are kept by rangecounter.KeepCapabilities.
+func TraceBackend(backend rangecounter.Backend, provider trace.TracerProvider, name string) rangecounter.Backend {
+	return rangecounter.KeepCapabilities(backend, &tracedBackend{
+		backend: backend,
+		tracer:  provider.Tracer(instrumentationName),
+		name:    name,
+	})
+}
+
+func (t *tracedBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.backend.query",
+		BackendAttribute.String(t.name), KeyCountAttribute.Int(len(keys)))
+	results, err := t.backend.Query(ctx, keys)
+	endSpan(span, err)
+	return results, err
+}
+
+func (t *tracedBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.backend.increment",
+		BackendAttribute.String(t.name), KeyCountAttribute.Int(len(keys)))
+	err := t.backend.Increment(ctx, keys, values)
+	endSpan(span, err)
+	return err
+}
+
+func (t *tracedBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.backend.query_increment",
+		BackendAttribute.String(t.name), KeyCountAttribute.Int(len(queryKeys)+len(incrementKeys)))
+	results, err := t.backend.(rangecounter.QueryIncrementBackend).QueryIncrement(ctx, queryKeys, incrementKeys, values)
+	endSpan(span, err)
+	return results, err
+}
+
+func (t *tracedBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.backend.increment_if_below",
+		BackendAttribute.String(t.name), KeyCountAttribute.Int(len(sumKeys)+len(keys)))
+	applied, sum, err := t.backend.(rangecounter.ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+	span.SetAttributes(AppliedAttribute.Bool(applied))
+	endSpan(span, err)
+	return applied, sum, err
+}
@@ -0,0 +1,119 @@
+package rangecounterotel
+
+import (
+	"context"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracedIntRangeCounter struct {
+	counter rangecounter.IntRangeCounter
+	tracer  trace.Tracer
+	layout  string
+}
+
+// TraceIntRangeCounter create a span for each call to counter, with its layout and range. The layout is the
+// counter's String if empty. The returned counter is a ConditionalIntRangeCounter if counter is.
+func TraceIntRangeCounter(counter rangecounter.IntRangeCounter, provider trace.TracerProvider, layout string) rangecounter.IntRangeCounter {
+	traced := &tracedIntRangeCounter{
+		counter: counter,
+		tracer:  provider.Tracer(instrumentationName),
+		layout:  layoutName(layout, counter),
+	}
+	if conditional, ok := counter.(rangecounter.ConditionalIntRangeCounter); ok {
+		return &tracedConditionalIntRangeCounter{traced, conditional}
+	}
+	return traced
+}
+
+func (t *tracedIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.int.query_sum", LayoutAttribute.String(t.layout),
+		FromAttribute.Int64(from), ToAttribute.Int64(to), WidthAttribute.Int64(to-from+1))
+	sum, err := t.counter.QuerySum(ctx, from, to)
+	endSpan(span, err)
+	return sum, err
+}
+
+func (t *tracedIntRangeCounter) Increment(ctx context.Context, at int64, by int64) error {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.int.increment", LayoutAttribute.String(t.layout),
+		AtAttribute.Int64(at))
+	err := t.counter.Increment(ctx, at, by)
+	endSpan(span, err)
+	return err
+}
+
+// String is the layout of the counter, so that a counter wrapping it can name it
+func (t *tracedIntRangeCounter) String() string {
+	return t.layout
+}
+
+type tracedConditionalIntRangeCounter struct {
+	*tracedIntRangeCounter
+	conditional rangecounter.ConditionalIntRangeCounter
+}
+
+func (t *tracedConditionalIntRangeCounter) IncrementIfBelow(ctx context.Context, at int64, by int64, from, to int64, limit int64) (bool, int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.int.increment_if_below", LayoutAttribute.String(t.layout),
+		AtAttribute.Int64(at), FromAttribute.Int64(from), ToAttribute.Int64(to), WidthAttribute.Int64(to-from+1))
+	applied, sum, err := t.conditional.IncrementIfBelow(ctx, at, by, from, to, limit)
+	span.SetAttributes(AppliedAttribute.Bool(applied))
+	endSpan(span, err)
+	return applied, sum, err
+}
+
+type tracedDateRangeCounter struct {
+	counter rangecounter.DateRangeCounter
+	tracer  trace.Tracer
+	layout  string
+}
+
+// TraceDateRangeCounter create a span for each call to counter, with its layout, date and bucket count. The layout
+// is the counter's String if empty. The returned counter is a ConditionalDateRangeCounter if counter is.
+func TraceDateRangeCounter(counter rangecounter.DateRangeCounter, provider trace.TracerProvider, layout string) rangecounter.DateRangeCounter {
+	traced := &tracedDateRangeCounter{
+		counter: counter,
+		tracer:  provider.Tracer(instrumentationName),
+		layout:  layoutName(layout, counter),
+	}
+	if conditional, ok := counter.(rangecounter.ConditionalDateRangeCounter); ok {
+		return &tracedConditionalDateRangeCounter{traced, conditional}
+	}
+	return traced
+}
+
+func (t *tracedDateRangeCounter) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.date.query_sum", LayoutAttribute.String(t.layout),
+		DateAttribute.String(at.Format(time.RFC3339Nano)), BucketCountAttribute.Int(bucketCount))
+	sum, err := t.counter.QuerySum(ctx, at, bucketCount)
+	endSpan(span, err)
+	return sum, err
+}
+
+func (t *tracedDateRangeCounter) Increment(ctx context.Context, at time.Time, by int64) error {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.date.increment", LayoutAttribute.String(t.layout),
+		DateAttribute.String(at.Format(time.RFC3339Nano)))
+	err := t.counter.Increment(ctx, at, by)
+	endSpan(span, err)
+	return err
+}
+
+// String is the layout of the counter, so that a counter wrapping it can name it
+func (t *tracedDateRangeCounter) String() string {
+	return t.layout
+}
+
+type tracedConditionalDateRangeCounter struct {
+	*tracedDateRangeCounter
+	conditional rangecounter.ConditionalDateRangeCounter
+}
+
+func (t *tracedConditionalDateRangeCounter) IncrementIfBelow(ctx context.Context, at time.Time, by int64, window int, limit int64) (bool, int64, error) {
+	ctx, span := startSpan(ctx, t.tracer, "rangecounter.date.increment_if_below", LayoutAttribute.String(t.layout),
+		DateAttribute.String(at.Format(time.RFC3339Nano)), BucketCountAttribute.Int(window))
+	applied, sum, err := t.conditional.IncrementIfBelow(ctx, at, by, window, limit)
+	span.SetAttributes(AppliedAttribute.Bool(applied))
+	endSpan(span, err)
+	return applied, sum, err
+}
@@ -0,0 +1,55 @@
+// Package rangecounterotel trace backends and counters with OpenTelemetry.
+//
+// Each call is a span in the context it is given, so a counter span is the parent of the spans of the backend calls
+// it makes. The time of a counter span not covered by its backend spans is spent computing keys and summing.
+package rangecounterotel
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/asdacap/rangecounter/rangecounterotel"
+
+// The attributes of the spans. AtAttribute is the index of an int counter call, and DateAttribute the date of a date
+// counter call, in RFC 3339.
+const (
+	BackendAttribute     = attribute.Key("rangecounter.backend")
+	LayoutAttribute      = attribute.Key("rangecounter.layout")
+	KeyCountAttribute    = attribute.Key("rangecounter.key_count")
+	FromAttribute        = attribute.Key("rangecounter.from")
+	ToAttribute          = attribute.Key("rangecounter.to")
+	WidthAttribute       = attribute.Key("rangecounter.width")
+	AtAttribute          = attribute.Key("rangecounter.at")
+	DateAttribute        = attribute.Key("rangecounter.date")
+	BucketCountAttribute = attribute.Key("rangecounter.bucket_count")
+	AppliedAttribute     = attribute.Key("rangecounter.applied")
+)
+
+// layoutName is name, or the String of counter if it has one, or its type
+func layoutName(name string, counter interface{}) string {
+	if name != "" {
+		return name
+	}
+	if stringer, ok := counter.(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	return fmt.Sprintf("%T", counter)
+}
+
+// endSpan record err on span, if any, and end it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func startSpan(ctx context.Context, tracer trace.Tracer, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+}
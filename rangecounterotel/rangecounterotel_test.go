@@ -0,0 +1,87 @@
+package rangecounterotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type failingBackend struct {
+	rangecounter.Backend
+}
+
+func (f failingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	return nil, errors.New("unavailable")
+}
+
+func attributeOf(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, it := range span.Attributes {
+		if it.Key == key {
+			return it.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracedCounterSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx := context.Background()
+
+	backend := TraceBackend(rangecounter.NewInMemoryBackend(), provider, "memory")
+	counter := TraceDateRangeCounter(
+		rangecounter.NewIntBackedDateRange(TraceIntRangeCounter(rangecounter.NewRangeTreeIntCounter(backend, 8, 1), provider, "tree-8-1"), rangecounter.Minute),
+		provider, "")
+
+	_, err := counter.QuerySum(ctx, time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), 5)
+	assert.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 3)
+	backendSpan, intSpan, dateSpan := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "rangecounter.backend.query", backendSpan.Name)
+	assert.Equal(t, "memory", attributeOf(backendSpan, BackendAttribute).AsString())
+	assert.Greater(t, attributeOf(backendSpan, KeyCountAttribute).AsInt64(), int64(0))
+	assert.Equal(t, intSpan.SpanContext.SpanID(), backendSpan.Parent.SpanID())
+
+	assert.Equal(t, "rangecounter.int.query_sum", intSpan.Name)
+	assert.Equal(t, "tree-8-1", attributeOf(intSpan, LayoutAttribute).AsString())
+	assert.EqualValues(t, 5, attributeOf(intSpan, WidthAttribute).AsInt64())
+	assert.Equal(t, dateSpan.SpanContext.SpanID(), intSpan.Parent.SpanID())
+
+	assert.Equal(t, "rangecounter.date.query_sum", dateSpan.Name)
+	assert.EqualValues(t, 5, attributeOf(dateSpan, BucketCountAttribute).AsInt64())
+	assert.Equal(t, "intBackedDateRange(minute, tree-8-1)", attributeOf(dateSpan, LayoutAttribute).AsString())
+	assert.Equal(t, "2019-01-01T00:00:00Z", attributeOf(dateSpan, DateAttribute).AsString())
+	assert.Equal(t, backendSpan.SpanContext.TraceID(), dateSpan.SpanContext.TraceID())
+
+	_, ok := counter.(rangecounter.ConditionalDateRangeCounter)
+	assert.True(t, ok, "conditional counter stays conditional")
+}
+
+func TestTracedBackendError(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	counter := TraceIntRangeCounter(rangecounter.NewBasicIntRangeCounter(
+		TraceBackend(failingBackend{rangecounter.NewInMemoryBackend()}, provider, "failing")), provider, "")
+	_, err := counter.QuerySum(context.Background(), 0, 3)
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Len(t, span.Events, 1, "the error is recorded")
+	}
+	assert.EqualValues(t, 4, attributeOf(spans[0], KeyCountAttribute).AsInt64())
+}
@@ -0,0 +1,57 @@
+package rangecounterprom
+
+import (
+	"context"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+)
+
+type instrumentedBackend struct {
+	backend rangecounter.Backend
+	metrics *Metrics
+	name    string
+}
+
+// InstrumentBackend record the metrics of each call to backend under name. The capabilities of backend are kept by
+// rangecounter.KeepCapabilities.
+func InstrumentBackend(backend rangecounter.Backend, metrics *Metrics, name string) rangecounter.Backend {
+	return rangecounter.KeepCapabilities(backend, &instrumentedBackend{
+		backend: backend,
+		metrics: metrics,
+		name:    name,
+	})
+}
+
+func (i *instrumentedBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	start := time.Now()
+	results, err := i.backend.Query(ctx, keys)
+	i.metrics.observeBackend(ctx, i.name, "query", start, len(keys), 0, err)
+	return results, err
+}
+
+func (i *instrumentedBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	start := time.Now()
+	err := i.backend.Increment(ctx, keys, values)
+	i.metrics.observeBackend(ctx, i.name, "increment", start, 0, len(keys), err)
+	return err
+}
+
+func (i *instrumentedBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	start := time.Now()
+	results, err := i.backend.(rangecounter.QueryIncrementBackend).QueryIncrement(ctx, queryKeys, incrementKeys, values)
+	i.metrics.observeBackend(ctx, i.name, "query_increment", start, len(queryKeys), len(incrementKeys), err)
+	return results, err
+}
+
+// IncrementIfBelow count the keys as written only if the increment is applied
+func (i *instrumentedBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	start := time.Now()
+	applied, sum, err := i.backend.(rangecounter.ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+	written := 0
+	if applied {
+		written = len(keys)
+	}
+	i.metrics.observeBackend(ctx, i.name, "increment_if_below", start, len(sumKeys), written, err)
+	return applied, sum, err
+}
@@ -0,0 +1,106 @@
+package rangecounterprom
+
+import (
+	"context"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+)
+
+type instrumentedIntRangeCounter struct {
+	counter rangecounter.IntRangeCounter
+	metrics *Metrics
+	name    string
+}
+
+// InstrumentIntRangeCounter record the metrics of each call to counter under name. The returned counter is a
+// ConditionalIntRangeCounter if counter is.
+func InstrumentIntRangeCounter(counter rangecounter.IntRangeCounter, metrics *Metrics, name string) rangecounter.IntRangeCounter {
+	instrumented := &instrumentedIntRangeCounter{
+		counter: counter,
+		metrics: metrics,
+		name:    name,
+	}
+	if conditional, ok := counter.(rangecounter.ConditionalIntRangeCounter); ok {
+		return &instrumentedConditionalIntRangeCounter{instrumented, conditional}
+	}
+	return instrumented
+}
+
+func (i *instrumentedIntRangeCounter) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	sum, err := i.counter.QuerySum(ctx, from, to)
+	i.metrics.observeCounter(i.name, "query_sum", start, stats, err)
+	return sum, err
+}
+
+func (i *instrumentedIntRangeCounter) Increment(ctx context.Context, at int64, by int64) error {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	err := i.counter.Increment(ctx, at, by)
+	i.metrics.observeCounter(i.name, "increment", start, stats, err)
+	return err
+}
+
+type instrumentedConditionalIntRangeCounter struct {
+	*instrumentedIntRangeCounter
+	conditional rangecounter.ConditionalIntRangeCounter
+}
+
+func (i *instrumentedConditionalIntRangeCounter) IncrementIfBelow(ctx context.Context, at int64, by int64, from, to int64, limit int64) (bool, int64, error) {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	applied, sum, err := i.conditional.IncrementIfBelow(ctx, at, by, from, to, limit)
+	i.metrics.observeCounter(i.name, "increment_if_below", start, stats, err)
+	return applied, sum, err
+}
+
+type instrumentedDateRangeCounter struct {
+	counter rangecounter.DateRangeCounter
+	metrics *Metrics
+	name    string
+}
+
+// InstrumentDateRangeCounter record the metrics of each call to counter under name. The returned counter is a
+// ConditionalDateRangeCounter if counter is.
+func InstrumentDateRangeCounter(counter rangecounter.DateRangeCounter, metrics *Metrics, name string) rangecounter.DateRangeCounter {
+	instrumented := &instrumentedDateRangeCounter{
+		counter: counter,
+		metrics: metrics,
+		name:    name,
+	}
+	if conditional, ok := counter.(rangecounter.ConditionalDateRangeCounter); ok {
+		return &instrumentedConditionalDateRangeCounter{instrumented, conditional}
+	}
+	return instrumented
+}
+
+func (i *instrumentedDateRangeCounter) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	sum, err := i.counter.QuerySum(ctx, at, bucketCount)
+	i.metrics.observeCounter(i.name, "query_sum", start, stats, err)
+	return sum, err
+}
+
+func (i *instrumentedDateRangeCounter) Increment(ctx context.Context, at time.Time, by int64) error {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	err := i.counter.Increment(ctx, at, by)
+	i.metrics.observeCounter(i.name, "increment", start, stats, err)
+	return err
+}
+
+type instrumentedConditionalDateRangeCounter struct {
+	*instrumentedDateRangeCounter
+	conditional rangecounter.ConditionalDateRangeCounter
+}
+
+func (i *instrumentedConditionalDateRangeCounter) IncrementIfBelow(ctx context.Context, at time.Time, by int64, window int, limit int64) (bool, int64, error) {
+	start := time.Now()
+	ctx, stats := withCallStats(ctx)
+	applied, sum, err := i.conditional.IncrementIfBelow(ctx, at, by, window, limit)
+	i.metrics.observeCounter(i.name, "increment_if_below", start, stats, err)
+	return applied, sum, err
+}
@@ -0,0 +1,135 @@
+// Package rangecounterprom instrument backends and counters with Prometheus metrics.
+//
+// Backend metrics count the calls, keys per call, latency and errors of each operation. Counter metrics count the
+// same of each counter call, plus the keys read and written by the backends during that call, which is the read and
+// write amplification of the counter's layout. The keys are only counted by backends wrapped with InstrumentBackend,
+// and are attributed to every instrumented counter in the call's context.
+package rangecounterprom
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is the collectors shared by the instrumented backends and counters, labelled by their name and operation.
+type Metrics struct {
+	backendCalls    *prometheus.CounterVec
+	backendErrors   *prometheus.CounterVec
+	backendKeys     *prometheus.HistogramVec
+	backendDuration *prometheus.HistogramVec
+
+	counterCalls    *prometheus.CounterVec
+	counterErrors   *prometheus.CounterVec
+	counterDuration *prometheus.HistogramVec
+	counterReads    *prometheus.HistogramVec
+	counterWrites   *prometheus.HistogramVec
+}
+
+var keyBuckets = prometheus.ExponentialBuckets(1, 2, 12)
+
+// NewMetrics create the collectors under namespace and register them to registerer.
+func NewMetrics(registerer prometheus.Registerer, namespace string) (*Metrics, error) {
+	labels := []string{"name", "operation"}
+	m := &Metrics{
+		backendCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "backend", Name: "calls_total",
+			Help: "Number of backend calls.",
+		}, labels),
+		backendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "backend", Name: "errors_total",
+			Help: "Number of backend calls which returned an error.",
+		}, labels),
+		backendKeys: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "backend", Name: "keys_per_call",
+			Help:    "Number of keys per backend call.",
+			Buckets: keyBuckets,
+		}, labels),
+		backendDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "backend", Name: "call_duration_seconds",
+			Help:    "Latency of backend calls.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		counterCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "counter", Name: "calls_total",
+			Help: "Number of counter calls.",
+		}, labels),
+		counterErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "counter", Name: "errors_total",
+			Help: "Number of counter calls which returned an error.",
+		}, labels),
+		counterDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "counter", Name: "call_duration_seconds",
+			Help:    "Latency of counter calls.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		counterReads: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "counter", Name: "keys_read_per_call",
+			Help:    "Number of keys read from instrumented backends per counter call.",
+			Buckets: keyBuckets,
+		}, labels),
+		counterWrites: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "counter", Name: "keys_written_per_call",
+			Help:    "Number of keys written to instrumented backends per counter call.",
+			Buckets: keyBuckets,
+		}, labels),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		m.backendCalls, m.backendErrors, m.backendKeys, m.backendDuration,
+		m.counterCalls, m.counterErrors, m.counterDuration, m.counterReads, m.counterWrites,
+	} {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to register metrics")
+		}
+	}
+	return m, nil
+}
+
+// callStats is the keys touched by the backends during a counter call
+type callStats struct {
+	parent  *callStats
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+type callStatsKey struct{}
+
+func withCallStats(ctx context.Context) (context.Context, *callStats) {
+	parent, _ := ctx.Value(callStatsKey{}).(*callStats)
+	stats := &callStats{parent: parent}
+	return context.WithValue(ctx, callStatsKey{}, stats), stats
+}
+
+// addKeys add to the stats of every instrumented counter call in ctx
+func addKeys(ctx context.Context, read, written int) {
+	stats, _ := ctx.Value(callStatsKey{}).(*callStats)
+	for ; stats != nil; stats = stats.parent {
+		stats.read.Add(int64(read))
+		stats.written.Add(int64(written))
+	}
+}
+
+func (m *Metrics) observeBackend(ctx context.Context, name, operation string, start time.Time, read, written int, err error) {
+	m.backendCalls.WithLabelValues(name, operation).Inc()
+	m.backendKeys.WithLabelValues(name, operation).Observe(float64(read + written))
+	m.backendDuration.WithLabelValues(name, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.backendErrors.WithLabelValues(name, operation).Inc()
+	}
+	addKeys(ctx, read, written)
+}
+
+func (m *Metrics) observeCounter(name, operation string, start time.Time, stats *callStats, err error) {
+	m.counterCalls.WithLabelValues(name, operation).Inc()
+	m.counterDuration.WithLabelValues(name, operation).Observe(time.Since(start).Seconds())
+	m.counterReads.WithLabelValues(name, operation).Observe(float64(stats.read.Load()))
+	m.counterWrites.WithLabelValues(name, operation).Observe(float64(stats.written.Load()))
+	if err != nil {
+		m.counterErrors.WithLabelValues(name, operation).Inc()
+	}
+}
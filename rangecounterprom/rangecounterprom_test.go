@@ -0,0 +1,87 @@
+package rangecounterprom
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingBackend struct {
+	rangecounter.Backend
+}
+
+func (f failingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	return nil, errors.New("unavailable")
+}
+
+// histogramSum returns the sample count and sum of a histogram
+func histogramSum(t *testing.T, vec *prometheus.HistogramVec, labels ...string) (uint64, float64) {
+	metric := &dto.Metric{}
+	assert.NoError(t, vec.WithLabelValues(labels...).(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()
+}
+
+func TestInstrumentedCounterAmplification(t *testing.T) {
+	ctx := context.Background()
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry, "rangecounter")
+	assert.NoError(t, err)
+
+	backend := InstrumentBackend(rangecounter.NewInMemoryBackend(), metrics, "memory")
+	counter := InstrumentDateRangeCounter(
+		rangecounter.NewIntBackedDateRange(rangecounter.NewRangeTreeIntCounter(backend, 8, 1), rangecounter.Minute),
+		metrics, "tree")
+	_, ok := counter.(rangecounter.ConditionalDateRangeCounter)
+	assert.True(t, ok, "conditional counter stays conditional")
+	_, ok = backend.(rangecounter.ConditionalIncrementBackend)
+	assert.True(t, ok, "conditional backend stays conditional")
+
+	at := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, counter.Increment(ctx, at, 1))
+	assert.NoError(t, counter.Increment(ctx, at, 1))
+	_, err = counter.QuerySum(ctx, at, 5)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, 2, testutil.ToFloat64(metrics.counterCalls.WithLabelValues("tree", "increment")))
+	assert.EqualValues(t, 1, testutil.ToFloat64(metrics.counterCalls.WithLabelValues("tree", "query_sum")))
+	assert.EqualValues(t, 2, testutil.ToFloat64(metrics.backendCalls.WithLabelValues("memory", "increment")))
+
+	count, sum := histogramSum(t, metrics.counterWrites, "tree", "increment")
+	assert.EqualValues(t, 2, count)
+	assert.EqualValues(t, 16, sum, "each increment write the 8 levels")
+	count, sum = histogramSum(t, metrics.counterReads, "tree", "query_sum")
+	assert.EqualValues(t, 1, count)
+	assert.Greater(t, sum, 0.0)
+	_, sum = histogramSum(t, metrics.backendKeys, "memory", "increment")
+	assert.EqualValues(t, 16, sum)
+
+	applied, _, err := counter.(rangecounter.ConditionalDateRangeCounter).IncrementIfBelow(ctx, at, 1, 5, 2)
+	assert.NoError(t, err)
+	assert.False(t, applied)
+	_, sum = histogramSum(t, metrics.counterWrites, "tree", "increment_if_below")
+	assert.EqualValues(t, 0, sum, "a rejected increment write nothing")
+}
+
+func TestInstrumentedBackendErrors(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics, err := NewMetrics(registry, "rangecounter")
+	assert.NoError(t, err)
+
+	counter := InstrumentIntRangeCounter(rangecounter.NewBasicIntRangeCounter(
+		InstrumentBackend(failingBackend{rangecounter.NewInMemoryBackend()}, metrics, "failing")), metrics, "basic")
+	_, err = counter.QuerySum(context.Background(), 0, 10)
+	assert.Error(t, err)
+
+	assert.EqualValues(t, 1, testutil.ToFloat64(metrics.backendErrors.WithLabelValues("failing", "query")))
+	assert.EqualValues(t, 1, testutil.ToFloat64(metrics.counterErrors.WithLabelValues("basic", "query_sum")))
+
+	_, err = NewMetrics(registry, "rangecounter")
+	assert.Error(t, err, "metrics can only be registered once")
+}
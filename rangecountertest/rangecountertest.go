@@ -0,0 +1,379 @@
+// Package rangecountertest is a conformance suite for implementations of the rangecounter interfaces, such as a
+// Backend over another store. Each suite runs as subtests of t, against fresh values made by the factory.
+//
+//	func TestRedisBackend(t *testing.T) {
+//		rangecountertest.TestBackend(t, func() rangecounter.Backend {
+//			return newRedisBackend(flushedClient(t))
+//		})
+//	}
+package rangecountertest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asdacap/rangecounter"
+	"github.com/stretchr/testify/assert"
+)
+
+// Seed is the seed of the randomized differential tests, which is logged so a failure can be reproduced.
+var Seed int64 = 1
+
+const (
+	concurrentWorkers    = 8
+	concurrentIncrements = 50
+	differentialRounds   = 500
+)
+
+// TestBackend check that backend store int64 per key, with any missing key being 0. It also check the optional
+// QueryIncrementBackend and ConditionalIncrementBackend if the backend implement them.
+func TestBackend(t *testing.T, factory func() rangecounter.Backend) {
+	ctx := context.Background()
+
+	t.Run("missing keys are zero", func(t *testing.T) {
+		results, err := factory().Query(ctx, []string{"missing", "also missing"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{0, 0}, results)
+	})
+
+	t.Run("empty calls", func(t *testing.T) {
+		backend := factory()
+		assert.NoError(t, backend.Increment(ctx, []string{}, []int64{}))
+		results, err := backend.Query(ctx, []string{})
+		assert.NoError(t, err)
+		assert.Len(t, results, 0)
+	})
+
+	t.Run("results follow the order of keys", func(t *testing.T) {
+		backend := factory()
+		assert.NoError(t, backend.Increment(ctx, []string{"a", "b", "c"}, []int64{1, 2, 3}))
+		results, err := backend.Query(ctx, []string{"c", "missing", "a", "b", "a"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{3, 0, 1, 2, 1}, results)
+	})
+
+	t.Run("increments add up", func(t *testing.T) {
+		backend := factory()
+		assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{5}))
+		assert.NoError(t, backend.Increment(ctx, []string{"a", "a"}, []int64{-2, 10}))
+		assert.NoError(t, backend.Increment(ctx, []string{"b"}, []int64{-7}))
+		results, err := backend.Query(ctx, []string{"a", "b"})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{13, -7}, results)
+	})
+
+	t.Run("keys are opaque", func(t *testing.T) {
+		backend := factory()
+		keys := []string{":1:2", ":1:2:", "{series=a,b}:3", "with space", "ünïcødé", "12", "012"}
+		values := make([]int64, len(keys))
+		for i := range values {
+			values[i] = int64(i + 1)
+		}
+		assert.NoError(t, backend.Increment(ctx, keys, values))
+		results, err := backend.Query(ctx, keys)
+		assert.NoError(t, err)
+		assert.Equal(t, values, results)
+	})
+
+	t.Run("differential", func(t *testing.T) {
+		t.Logf("seed %v", Seed)
+		random := rand.New(rand.NewSource(Seed))
+		backend := factory()
+		model := map[string]int64{}
+		for round := 0; round < differentialRounds; round++ {
+			keys := make([]string, random.Intn(5)+1)
+			values := make([]int64, len(keys))
+			for i := range keys {
+				keys[i] = fmt.Sprint("key", random.Intn(20))
+				values[i] = random.Int63n(200) - 100
+				model[keys[i]] += values[i]
+			}
+			assert.NoError(t, backend.Increment(ctx, keys, values))
+
+			queryKeys := make([]string, random.Intn(5)+1)
+			expected := make([]int64, len(queryKeys))
+			for i := range queryKeys {
+				queryKeys[i] = fmt.Sprint("key", random.Intn(25))
+				expected[i] = model[queryKeys[i]]
+			}
+			results, err := backend.Query(ctx, queryKeys)
+			assert.NoError(t, err)
+			if !assert.Equal(t, expected, results, "round %v", round) {
+				return
+			}
+		}
+	})
+
+	t.Run("concurrent increments", func(t *testing.T) {
+		backend := factory()
+		runConcurrently(func(worker, i int) {
+			assert.NoError(t, backend.Increment(ctx, []string{"shared", fmt.Sprint("worker", worker)}, []int64{1, 2}))
+		})
+		results, err := backend.Query(ctx, []string{"shared", "worker0", fmt.Sprint("worker", concurrentWorkers-1)})
+		assert.NoError(t, err)
+		assert.Equal(t, []int64{concurrentWorkers * concurrentIncrements, 2 * concurrentIncrements, 2 * concurrentIncrements}, results)
+	})
+
+	if _, ok := factory().(rangecounter.QueryIncrementBackend); ok {
+		t.Run("QueryIncrement", func(t *testing.T) {
+			backend := factory().(rangecounter.QueryIncrementBackend)
+			assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{1}))
+			results, err := backend.QueryIncrement(ctx, []string{"a", "b", "c"}, []string{"a", "b"}, []int64{2, 3})
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{3, 3, 0}, results, "results include the increments")
+		})
+	}
+
+	if _, ok := factory().(rangecounter.ConditionalIncrementBackend); ok {
+		t.Run("IncrementIfBelow", func(t *testing.T) {
+			backend := factory().(rangecounter.ConditionalIncrementBackend)
+			assert.NoError(t, backend.Increment(ctx, []string{"a", "b"}, []int64{3, 4}))
+
+			applied, sum, err := backend.IncrementIfBelow(ctx, []string{"a", "b"}, 3, 10, []string{"a", "c"}, []int64{3, 1})
+			assert.NoError(t, err)
+			assert.True(t, applied)
+			assert.EqualValues(t, 7, sum)
+
+			applied, sum, err = backend.IncrementIfBelow(ctx, []string{"a", "b"}, 1, 10, []string{"a"}, []int64{1})
+			assert.NoError(t, err)
+			assert.False(t, applied)
+			assert.EqualValues(t, 10, sum)
+
+			results, err := backend.Query(ctx, []string{"a", "b", "c"})
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{6, 4, 1}, results)
+		})
+
+		t.Run("concurrent IncrementIfBelow", func(t *testing.T) {
+			backend := factory().(rangecounter.ConditionalIncrementBackend)
+			limit := int64(concurrentWorkers * concurrentIncrements / 2)
+			runConcurrently(func(worker, i int) {
+				_, _, err := backend.IncrementIfBelow(ctx, []string{"quota"}, 1, limit, []string{"quota"}, []int64{1})
+				assert.NoError(t, err)
+			})
+			results, err := backend.Query(ctx, []string{"quota"})
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{limit}, results, "the limit is never exceeded")
+		})
+	}
+}
+
+// TestIntRangeCounter check that counter sum the increments between from and to, inclusive. Indexes are kept
+// between 0 and a few thousands, so counters that only support positive or dense indexes can be tested.
+func TestIntRangeCounter(t *testing.T, factory func() rangecounter.IntRangeCounter) {
+	ctx := context.Background()
+
+	type increment struct {
+		at int64
+		by int64
+	}
+	type query struct {
+		from     int64
+		to       int64
+		expected int64
+	}
+	tests := []struct {
+		name       string
+		increments []increment
+		queries    []query
+	}{
+		{
+			name:    "empty counter",
+			queries: []query{{0, 0, 0}, {0, 1000, 0}},
+		},
+		{
+			name:       "single index",
+			increments: []increment{{5, 2}, {5, 3}},
+			queries:    []query{{5, 5, 5}, {4, 4, 0}, {6, 6, 0}, {0, 10, 5}, {5, 100, 5}},
+		},
+		{
+			name:       "negative and zero increments",
+			increments: []increment{{1, 5}, {2, -3}, {3, 0}, {1, -1}},
+			queries:    []query{{1, 1, 4}, {1, 2, 1}, {2, 3, -3}, {0, 3, 1}},
+		},
+		{
+			name:       "power of two boundaries",
+			increments: []increment{{255, 1}, {256, 2}, {511, 4}, {512, 8}, {1023, 16}, {1024, 32}},
+			queries: []query{
+				{255, 256, 3}, {256, 511, 6}, {0, 255, 1}, {256, 1023, 30}, {511, 512, 12},
+				{1024, 1024, 32}, {0, 2047, 63}, {257, 510, 0},
+			},
+		},
+		{
+			name:       "out of order increments",
+			increments: []increment{{100, 1}, {3, 2}, {50, 4}, {99, 8}, {0, 16}},
+			queries:    []query{{0, 100, 31}, {0, 49, 18}, {50, 99, 12}, {100, 200, 1}, {4, 49, 0}},
+		},
+	}
+	for _, d := range tests {
+		t.Run(d.name, func(t *testing.T) {
+			counter := factory()
+			for _, inc := range d.increments {
+				assert.NoError(t, counter.Increment(ctx, inc.at, inc.by))
+			}
+			for _, q := range d.queries {
+				sum, err := counter.QuerySum(ctx, q.from, q.to)
+				assert.NoError(t, err)
+				assert.EqualValues(t, q.expected, sum, "%v to %v", q.from, q.to)
+			}
+		})
+	}
+
+	t.Run("differential", func(t *testing.T) {
+		t.Logf("seed %v", Seed)
+		random := rand.New(rand.NewSource(Seed))
+		counter := factory()
+		model := map[int64]int64{}
+		for round := 0; round < differentialRounds; round++ {
+			at := random.Int63n(2000)
+			by := random.Int63n(20) - 5
+			model[at] += by
+			assert.NoError(t, counter.Increment(ctx, at, by))
+
+			from := random.Int63n(2000)
+			to := from + random.Int63n(300)
+			expected := int64(0)
+			for idx, value := range model {
+				if idx >= from && idx <= to {
+					expected += value
+				}
+			}
+			sum, err := counter.QuerySum(ctx, from, to)
+			assert.NoError(t, err)
+			if !assert.EqualValues(t, expected, sum, "round %v, %v to %v", round, from, to) {
+				return
+			}
+		}
+	})
+
+	t.Run("concurrent increments", func(t *testing.T) {
+		counter := factory()
+		runConcurrently(func(worker, i int) {
+			assert.NoError(t, counter.Increment(ctx, int64(i%10), 1))
+		})
+		sum, err := counter.QuerySum(ctx, 0, 9)
+		assert.NoError(t, err)
+		assert.EqualValues(t, concurrentWorkers*concurrentIncrements, sum)
+		sum, err = counter.QuerySum(ctx, 3, 3)
+		assert.NoError(t, err)
+		assert.EqualValues(t, concurrentWorkers*concurrentIncrements/10, sum)
+	})
+}
+
+// TestDateRangeCounter check that the counter made for each of Seconds, Minute and Hour sum the increments of the
+// bucketCount buckets up to at, inclusive, each bucket being aligned to its range.
+func TestDateRangeCounter(t *testing.T, factory func(dateRange rangecounter.DateRange) rangecounter.DateRangeCounter) {
+	ctx := context.Background()
+	baseDate := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, drange := range []rangecounter.DateRange{rangecounter.Seconds, rangecounter.Minute, rangecounter.Hour} {
+		duration := dateRangeDuration(drange)
+
+		t.Run(drange.String(), func(t *testing.T) {
+			t.Run("empty counter", func(t *testing.T) {
+				sum, err := factory(drange).QuerySum(ctx, baseDate, 100)
+				assert.NoError(t, err)
+				assert.EqualValues(t, 0, sum)
+			})
+
+			t.Run("same bucket", func(t *testing.T) {
+				counter := factory(drange)
+				assert.NoError(t, counter.Increment(ctx, baseDate, 1))
+				assert.NoError(t, counter.Increment(ctx, baseDate.Add(duration-time.Nanosecond), 2))
+				assert.NoError(t, counter.Increment(ctx, baseDate.Add(duration), 4))
+
+				sum, err := counter.QuerySum(ctx, baseDate.Add(duration/2), 1)
+				assert.NoError(t, err)
+				assert.EqualValues(t, 3, sum)
+				sum, err = counter.QuerySum(ctx, baseDate.Add(duration), 2)
+				assert.NoError(t, err)
+				assert.EqualValues(t, 7, sum)
+				sum, err = counter.QuerySum(ctx, baseDate.Add(-time.Nanosecond), 1)
+				assert.NoError(t, err)
+				assert.EqualValues(t, 0, sum)
+			})
+
+			t.Run("bucket count", func(t *testing.T) {
+				counter := factory(drange)
+				for i := 0; i < 10; i++ {
+					assert.NoError(t, counter.Increment(ctx, baseDate.Add(duration*time.Duration(i)), int64(i)))
+				}
+				for bucketCount, expected := range map[int]int64{1: 9, 2: 17, 5: 35, 10: 45, 20: 45} {
+					sum, err := counter.QuerySum(ctx, baseDate.Add(duration*9), bucketCount)
+					assert.NoError(t, err)
+					assert.EqualValues(t, expected, sum, "bucket count %v", bucketCount)
+				}
+			})
+
+			t.Run("differential", func(t *testing.T) {
+				t.Logf("seed %v", Seed)
+				random := rand.New(rand.NewSource(Seed))
+				counter := factory(drange)
+				model := map[int64]int64{}
+				span := int64(duration) * 500
+				for round := 0; round < differentialRounds; round++ {
+					at := baseDate.Add(time.Duration(random.Int63n(span)))
+					by := random.Int63n(10)
+					model[at.Sub(baseDate).Nanoseconds()/int64(duration)] += by
+					assert.NoError(t, counter.Increment(ctx, at, by))
+
+					queryAt := baseDate.Add(time.Duration(random.Int63n(span)))
+					bucketCount := random.Intn(60) + 1
+					end := queryAt.Sub(baseDate).Nanoseconds() / int64(duration)
+					expected := int64(0)
+					for bucket, value := range model {
+						if bucket > end-int64(bucketCount) && bucket <= end {
+							expected += value
+						}
+					}
+					sum, err := counter.QuerySum(ctx, queryAt, bucketCount)
+					assert.NoError(t, err)
+					if !assert.EqualValues(t, expected, sum, "round %v, %v buckets to %v", round, bucketCount, queryAt) {
+						return
+					}
+				}
+			})
+
+			t.Run("concurrent increments", func(t *testing.T) {
+				counter := factory(drange)
+				runConcurrently(func(worker, i int) {
+					assert.NoError(t, counter.Increment(ctx, baseDate.Add(duration*time.Duration(i%3)), 1))
+				})
+				sum, err := counter.QuerySum(ctx, baseDate.Add(duration*2), 3)
+				assert.NoError(t, err)
+				assert.EqualValues(t, concurrentWorkers*concurrentIncrements, sum)
+			})
+		})
+	}
+}
+
+func dateRangeDuration(drange rangecounter.DateRange) time.Duration {
+	switch drange {
+	case rangecounter.Seconds:
+		return time.Second
+	case rangecounter.Minute:
+		return time.Minute
+	case rangecounter.Hour:
+		return time.Hour
+	}
+	panic("unknown date range")
+}
+
+// runConcurrently run concurrentIncrements calls of call on each of concurrentWorkers goroutines
+func runConcurrently(call func(worker, i int)) {
+	wg := sync.WaitGroup{}
+	for worker := 0; worker < concurrentWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < concurrentIncrements; i++ {
+				call(worker, i)
+			}
+		}(worker)
+	}
+	wg.Wait()
+}
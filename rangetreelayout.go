@@ -0,0 +1,141 @@
+package rangecounter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rangeTreeLayout is the key layout of a segment tree over int64 indexes.
+// Each index is split into heightLimit paths of bitLength bits, except the top path which takes the remaining bits.
+// A node's key is its path from the root, so the keys of a leaf's ancestors are prefixes of its key.
+type rangeTreeLayout struct {
+	heightLimit int
+	bitLength   uint
+}
+
+func (rtl rangeTreeLayout) determineSumKeys(from, to int64) []string {
+	frompath := rtl.getTreePath(uint64(from))
+	frompathKeys := rtl.getTreePathKeys(frompath)
+
+	if from == to {
+		return []string{frompathKeys[len(frompathKeys)-1]}
+	}
+
+	topath := rtl.getTreePath(uint64(to))
+	topathKeys := rtl.getTreePathKeys(topath)
+	maxPath := uint64(1 << rtl.bitLength)
+
+	nonCommonIdx := 0
+	for i := range frompath {
+		if frompath[i] != topath[i] {
+			nonCommonIdx = i
+			break
+		}
+	}
+
+	parentKey := ""
+	if nonCommonIdx != 0 {
+		parentKey = frompathKeys[nonCommonIdx-1]
+	}
+
+	inBetweenKeys := []string{}
+	for i := frompath[nonCommonIdx] + 1; i < topath[nonCommonIdx]; i++ {
+		inBetweenKeys = append(inBetweenKeys, rtl.appendKey(parentKey, i))
+	}
+
+	fromSubtreeKey := make([]string, 0, rtl.heightLimit*int(maxPath))
+	for i := nonCommonIdx + 1; i < len(frompath); i++ {
+		parentKey := ""
+		if i != 0 {
+			parentKey = frompathKeys[i-1]
+		}
+
+		for nextPath := frompath[i] + 1; nextPath < maxPath; nextPath++ {
+			fromSubtreeKey = append(fromSubtreeKey, rtl.appendKey(parentKey, nextPath))
+		}
+	}
+	fromSubtreeKey = append(fromSubtreeKey, frompathKeys[len(frompathKeys)-1])
+
+	toSubtreeKey := make([]string, 0, rtl.heightLimit*int(maxPath))
+	for i := nonCommonIdx + 1; i < len(topath); i++ {
+		parentKey := ""
+		if i != 0 {
+			parentKey = topathKeys[i-1]
+		}
+
+		for beforePath := uint64(0); beforePath < topath[i]; beforePath++ {
+			toSubtreeKey = append(toSubtreeKey, rtl.appendKey(parentKey, beforePath))
+		}
+	}
+	toSubtreeKey = append(toSubtreeKey, topathKeys[len(topathKeys)-1])
+
+	keys := make([]string, 0, len(fromSubtreeKey)+len(inBetweenKeys)+len(toSubtreeKey))
+	keys = append(keys, fromSubtreeKey...)
+	keys = append(keys, inBetweenKeys...)
+	keys = append(keys, toSubtreeKey...)
+	return keys
+}
+
+// incrementKeys is the keys of the leaf at idx and all its ancestors
+func (rtl rangeTreeLayout) incrementKeys(idx int64) []string {
+	return rtl.getTreePathKeys(rtl.getTreePath(uint64(idx)))
+}
+
+func (rtl rangeTreeLayout) getTreePathKeys(paths []uint64) []string {
+	builder := strings.Builder{}
+	keys := []string{}
+	for _, path := range paths {
+		builder.WriteRune(':')
+		builder.WriteString(strconv.FormatUint(path, 10))
+		keys = append(keys, builder.String())
+	}
+	return keys
+}
+
+// KeySpan returns the first and last index under the node of key
+func (rtl rangeTreeLayout) KeySpan(key string) (int64, int64, bool) {
+	if !strings.HasPrefix(key, ":") {
+		return 0, 0, false
+	}
+	paths := strings.Split(key[1:], ":")
+	if len(paths) > rtl.heightLimit {
+		return 0, 0, false
+	}
+
+	idx := uint64(0)
+	for i, it := range paths {
+		path, err := strconv.ParseUint(it, 10, 64)
+		if err != nil || strconv.FormatUint(path, 10) != it || (i > 0 && path >= 1<<rtl.bitLength) {
+			return 0, 0, false
+		}
+		idx = idx<<rtl.bitLength | path
+	}
+	shift := rtl.bitLength * uint(rtl.heightLimit-len(paths))
+	from := idx << shift
+	return int64(from), int64(from + (1 << shift) - 1), true
+}
+
+func (rtl rangeTreeLayout) appendKey(parent string, idx uint64) string {
+	return parent + ":" + fmt.Sprint(idx)
+}
+
+func (rtl rangeTreeLayout) getTreePath(idx uint64) []uint64 {
+	lowerMask := uint64((1 << (rtl.bitLength)) - 1)
+	treePath := []uint64{}
+
+	for i := 0; i < (rtl.heightLimit)-1; i++ {
+		cPath := idx & lowerMask
+		treePath = append(treePath, cPath)
+		idx = idx >> rtl.bitLength
+	}
+
+	treePath = append(treePath, idx)
+
+	reversed := make([]uint64, len(treePath))
+	for i := 0; i < len(treePath); i++ {
+		reversed[i] = treePath[len(treePath)-i-1]
+	}
+
+	return reversed
+}
@@ -0,0 +1,323 @@
+package rangecounter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RateLimitAlgorithm determine how a RateLimiter count the requests in its window
+type RateLimitAlgorithm int
+
+const (
+	// FixedWindow count the requests in windows aligned to windowBuckets buckets. It only touch one key, but allow
+	// up to twice the limit around the window boundary.
+	FixedWindow RateLimitAlgorithm = iota
+	// SlidingWindowApproximation count the current fixed window plus the previous fixed window weighted by how much
+	// of it still overlap the sliding window. It touch two keys.
+	SlidingWindowApproximation
+	// SlidingWindowBuckets sum the last windowBuckets buckets, like DateRangeCounter.QuerySum. It is exact to the
+	// bucket, but read windowBuckets keys.
+	SlidingWindowBuckets
+)
+
+func (a RateLimitAlgorithm) String() string {
+	switch a {
+	case FixedWindow:
+		return "fixedWindow"
+	case SlidingWindowApproximation:
+		return "slidingWindowApproximation"
+	case SlidingWindowBuckets:
+		return "slidingWindowBuckets"
+	}
+	return "unknown algorithm"
+}
+
+// RateLimitResult is the outcome of RateLimiter.Allow
+// Remaining is the quota left in the window after this call. RetryAfter is only set when the call is not allowed,
+// and is how long until the same call would be allowed, assuming no other request come in.
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int64
+	RetryAfter time.Duration
+}
+
+// RateLimiter check if n more events for key at `at` stay within the limit, and count them if it does.
+// Denied calls are not counted. n must be positive.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, at time.Time, n int64) (RateLimitResult, error)
+}
+
+type rateLimiter struct {
+	backend       Backend
+	algorithm     RateLimitAlgorithm
+	drange        DateRange
+	windowBuckets int
+	limit         int64
+}
+
+// NewRateLimiter creates a RateLimiter allowing `limit` events per window of windowBuckets `drange`.
+// If the backend is a ConditionalIncrementBackend, FixedWindow and SlidingWindowBuckets check and increment atomically
+// in a single round trip. Otherwise if the backend is a QueryIncrementBackend, the check and the increment is done in
+// a single round trip, and the increment is reverted if it turns out to be over the limit. Until it is reverted,
+// concurrent calls count it and may be denied, and if the revert fail, its error is returned and the quota it took is
+// lost until the window pass. Otherwise the counters are queried then incremented, so concurrent calls may all be
+// allowed past the limit. NewLockingConditionalBackend serialize them within this process.
+func NewRateLimiter(backend Backend, algorithm RateLimitAlgorithm, drange DateRange, windowBuckets int, limit int64) RateLimiter {
+	if windowBuckets <= 0 {
+		panic("windowBuckets must be positive")
+	}
+	if limit <= 0 {
+		panic("limit must be positive")
+	}
+	return &rateLimiter{
+		backend:       backend,
+		algorithm:     algorithm,
+		drange:        drange,
+		windowBuckets: windowBuckets,
+		limit:         limit,
+	}
+}
+
+// rateLimitPlan is the keys an Allow call need to read and write, and how to judge the values read.
+// judge receive the values of queryKeys, excluding the pending increment.
+// sumLimited is set if judge simply allow when the sum of the values plus n is within the limit.
+type rateLimitPlan struct {
+	queryKeys    []string
+	incrementKey string
+	judge        func(values []int64) RateLimitResult
+	sumLimited   bool
+}
+
+func (rl *rateLimiter) Allow(ctx context.Context, key string, at time.Time, n int64) (RateLimitResult, error) {
+	if n <= 0 {
+		return RateLimitResult{}, errors.Errorf("requested %v which is not positive", n)
+	}
+	if n > rl.limit {
+		return RateLimitResult{}, errors.Errorf("requested %v which is more than the limit %v", n, rl.limit)
+	}
+
+	plan, err := rl.plan(key, at, n)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	if cib, ok := rl.backend.(ConditionalIncrementBackend); ok && plan.sumLimited {
+		applied, sum, err := cib.IncrementIfBelow(ctx, plan.queryKeys, n, rl.limit, []string{plan.incrementKey}, []int64{n})
+		if err != nil {
+			return RateLimitResult{}, errors.Wrap(err, "unable to conditionally increment counters")
+		}
+		if applied {
+			return RateLimitResult{Allowed: true, Remaining: rl.limit - sum - n}, nil
+		}
+		if len(plan.queryKeys) == 1 {
+			return plan.judge([]int64{sum}), nil
+		}
+
+		// The retry time depends on the value of each bucket
+		values, err := queryBackend(ctx, rl.backend, plan.queryKeys)
+		if err != nil {
+			return RateLimitResult{}, errors.Wrap(err, "unable to query counters")
+		}
+		result := plan.judge(values)
+		if result.Allowed {
+			// Some bucket expired in between
+			return RateLimitResult{Remaining: max64(rl.limit-sum, 0)}, nil
+		}
+		return result, nil
+	}
+
+	if qib, ok := rl.backend.(QueryIncrementBackend); ok {
+		values, err := qib.QueryIncrement(ctx, plan.queryKeys, []string{plan.incrementKey}, []int64{n})
+		if err != nil {
+			return RateLimitResult{}, errors.Wrap(err, "unable to query and increment counters")
+		}
+		for i, queryKey := range plan.queryKeys {
+			if queryKey == plan.incrementKey {
+				values[i] -= n
+			}
+		}
+
+		result := plan.judge(values)
+		if !result.Allowed {
+			err = rl.backend.Increment(ctx, []string{plan.incrementKey}, []int64{-n})
+			if err != nil {
+				return RateLimitResult{}, errors.Wrap(err, "unable to revert increment")
+			}
+		}
+		return result, nil
+	}
+
+	values, err := queryBackend(ctx, rl.backend, plan.queryKeys)
+	if err != nil {
+		return RateLimitResult{}, errors.Wrap(err, "unable to query counters")
+	}
+
+	result := plan.judge(values)
+	if result.Allowed {
+		err = rl.backend.Increment(ctx, []string{plan.incrementKey}, []int64{n})
+		if err != nil {
+			return RateLimitResult{}, errors.Wrap(err, "unable to increment counter")
+		}
+	}
+	return result, nil
+}
+
+func (rl *rateLimiter) plan(key string, at time.Time, n int64) (*rateLimitPlan, error) {
+	switch rl.algorithm {
+	case FixedWindow:
+		return rl.planFixedWindow(key, at, n)
+	case SlidingWindowApproximation:
+		return rl.planSlidingWindowApproximation(key, at, n)
+	case SlidingWindowBuckets:
+		return rl.planSlidingWindowBuckets(key, at, n)
+	}
+	return nil, errors.Errorf("unknown algorithm: %v", rl.algorithm)
+}
+
+func (rl *rateLimiter) planFixedWindow(key string, at time.Time, n int64) (*rateLimitPlan, error) {
+	layout := rl.windowLayout(key)
+	start, err := rl.windowStart(at)
+	if err != nil {
+		return nil, err
+	}
+	windowKey := layout.getKey(start)
+	end := start.Add(rl.windowDuration())
+
+	return &rateLimitPlan{
+		queryKeys:    []string{windowKey},
+		incrementKey: windowKey,
+		judge: func(values []int64) RateLimitResult {
+			count := values[0]
+			if count+n <= rl.limit {
+				return RateLimitResult{Allowed: true, Remaining: rl.limit - count - n}
+			}
+			return RateLimitResult{Remaining: max64(rl.limit-count, 0), RetryAfter: end.Sub(at)}
+		},
+		sumLimited: true,
+	}, nil
+}
+
+func (rl *rateLimiter) planSlidingWindowApproximation(key string, at time.Time, n int64) (*rateLimitPlan, error) {
+	layout := rl.windowLayout(key)
+	start, err := rl.windowStart(at)
+	if err != nil {
+		return nil, err
+	}
+	window := rl.windowDuration()
+	previousStart := start.Add(-window)
+	currentKey := layout.getKey(start)
+	elapsed := float64(at.Sub(start)) / float64(window)
+
+	return &rateLimitPlan{
+		queryKeys:    []string{currentKey, layout.getKey(previousStart)},
+		incrementKey: currentKey,
+		judge: func(values []int64) RateLimitResult {
+			current, previous := values[0], values[1]
+			estimate := float64(previous)*(1-elapsed) + float64(current)
+			if estimate+float64(n) <= float64(rl.limit) {
+				return RateLimitResult{Allowed: true, Remaining: int64(math.Floor(float64(rl.limit) - estimate - float64(n)))}
+			}
+
+			// The previous window weight need to drop to `allowedWeight` for this call to fit
+			var retryAt time.Time
+			if current+n <= rl.limit {
+				allowedWeight := float64(rl.limit-current-n) / float64(previous)
+				retryAt = start.Add(time.Duration(math.Ceil((1 - allowedWeight) * float64(window))))
+			} else {
+				// The current window becomes the previous window
+				allowedWeight := float64(rl.limit-n) / float64(current)
+				retryAt = start.Add(window).Add(time.Duration(math.Ceil((1 - allowedWeight) * float64(window))))
+			}
+			return RateLimitResult{
+				Remaining:  max64(int64(math.Floor(float64(rl.limit)-estimate)), 0),
+				RetryAfter: retryAt.Sub(at),
+			}
+		},
+	}, nil
+}
+
+func (rl *rateLimiter) planSlidingWindowBuckets(key string, at time.Time, n int64) (*rateLimitPlan, error) {
+	layout := bucketDateLayout{
+		drange: rl.drange,
+		prefix: rateLimitKey(key) + ":",
+	}
+	keys, err := layout.queryKeys(at, rl.windowBuckets)
+	if err != nil {
+		return nil, err
+	}
+	aligned, err := rl.drange.alignDate(at)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to align date")
+	}
+
+	return &rateLimitPlan{
+		queryKeys:    keys,
+		incrementKey: keys[0],
+		judge: func(values []int64) RateLimitResult {
+			sum := int64(0)
+			for _, value := range values {
+				sum += value
+			}
+			if sum+n <= rl.limit {
+				return RateLimitResult{Allowed: true, Remaining: rl.limit - sum - n}
+			}
+
+			// Values are newest first, so the buckets expire from the end
+			expired := int64(0)
+			retryAfter := time.Duration(0)
+			for i := len(values) - 1; i >= 0; i-- {
+				expired += values[i]
+				if sum-expired+n <= rl.limit {
+					retryAt := rl.drange.incrementDateForce(rl.windowBuckets-i, aligned)
+					retryAfter = retryAt.Sub(at)
+					break
+				}
+			}
+			return RateLimitResult{Remaining: max64(rl.limit-sum, 0), RetryAfter: retryAfter}
+		},
+		sumLimited: true,
+	}, nil
+}
+
+// rateLimitKey prefix key with its length, so that a key containing ":" cannot collide with the keys of another
+func rateLimitKey(key string) string {
+	return fmt.Sprintf("%v:%v", len(key), key)
+}
+
+// windowLayout is the layout used to build keys for the fixed window algorithms.
+func (rl *rateLimiter) windowLayout(key string) bucketDateLayout {
+	return bucketDateLayout{
+		drange: rl.drange,
+		prefix: fmt.Sprintf("%v:window%v:", rateLimitKey(key), rl.windowBuckets),
+	}
+}
+
+// windowStart align at to the start of its window of windowBuckets buckets
+func (rl *rateLimiter) windowStart(at time.Time) (time.Time, error) {
+	aligned, err := rl.drange.alignDate(at)
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "unable to align date")
+	}
+
+	bucketNumber := aligned.Unix() / int64(rl.drange.getDuration()/time.Second)
+	offset := bucketNumber % int64(rl.windowBuckets)
+	if offset < 0 {
+		offset += int64(rl.windowBuckets)
+	}
+	return rl.drange.incrementDate(-int(offset), aligned)
+}
+
+func (rl *rateLimiter) windowDuration() time.Duration {
+	return rl.drange.getDuration() * time.Duration(rl.windowBuckets)
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
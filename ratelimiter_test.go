@@ -0,0 +1,136 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+
+	type allowReq struct {
+		offset     time.Duration
+		n          int64
+		allowed    bool
+		remaining  int64
+		retryAfter time.Duration
+	}
+	tests := []struct {
+		name          string
+		algorithm     RateLimitAlgorithm
+		windowBuckets int
+		limit         int64
+		requests      []allowReq
+	}{
+		{
+			name:          "fixed window",
+			algorithm:     FixedWindow,
+			windowBuckets: 1,
+			limit:         2,
+			requests: []allowReq{
+				{0, 1, true, 1, 0},
+				{10 * time.Second, 1, true, 0, 0},
+				{20 * time.Second, 1, false, 0, 40 * time.Second},
+				{60 * time.Second, 2, true, 0, 0},
+			},
+		},
+		{
+			name:          "fixed window of multiple buckets",
+			algorithm:     FixedWindow,
+			windowBuckets: 5,
+			limit:         2,
+			requests: []allowReq{
+				{0, 2, true, 0, 0},
+				{4 * time.Minute, 1, false, 0, time.Minute},
+				{5 * time.Minute, 1, true, 1, 0},
+			},
+		},
+		{
+			name:          "sliding window approximation",
+			algorithm:     SlidingWindowApproximation,
+			windowBuckets: 1,
+			limit:         4,
+			requests: []allowReq{
+				{0, 4, true, 0, 0},
+				{60 * time.Second, 1, false, 0, 15 * time.Second},
+				{75 * time.Second, 1, true, 0, 0},
+				{90 * time.Second, 1, true, 0, 0},
+				{100 * time.Second, 1, false, 0, 5 * time.Second},
+			},
+		},
+		{
+			name:          "sliding window buckets",
+			algorithm:     SlidingWindowBuckets,
+			windowBuckets: 3,
+			limit:         3,
+			requests: []allowReq{
+				{0, 1, true, 2, 0},
+				{time.Minute, 2, true, 0, 0},
+				{2 * time.Minute, 1, false, 0, time.Minute},
+				{3 * time.Minute, 1, true, 0, 0},
+				{3 * time.Minute, 1, false, 0, time.Minute},
+			},
+		},
+	}
+
+	backendToTest := map[string]func() Backend{
+		"inMemory": func() Backend {
+			return NewInMemoryBackend()
+		},
+		"queryIncrementOnly": func() Backend {
+			return queryIncrementOnlyBackend{NewInMemoryBackend().(QueryIncrementBackend)}
+		},
+		"withoutQueryIncrement": func() Backend {
+			return NewBenchmarkBackend()
+		},
+	}
+	for backendName, backendFactory := range backendToTest {
+		t.Run("backend "+backendName, func(t *testing.T) {
+			for _, d := range tests {
+				t.Run(d.name, func(t *testing.T) {
+					limiter := NewRateLimiter(backendFactory(), d.algorithm, Minute, d.windowBuckets, d.limit)
+					ctx := context.Background()
+					for _, req := range d.requests {
+						result, err := limiter.Allow(ctx, "user", baseDate.Add(req.offset), req.n)
+						assert.NoError(t, err)
+						assert.Equal(t, req.allowed, result.Allowed)
+						assert.Equal(t, req.remaining, result.Remaining)
+						assert.Equal(t, req.retryAfter, result.RetryAfter)
+					}
+
+					result, err := limiter.Allow(ctx, "other user", baseDate, 1)
+					assert.NoError(t, err)
+					assert.True(t, result.Allowed)
+				})
+			}
+		})
+	}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	backend := NewInMemoryBackend()
+	fixed := NewRateLimiter(backend, FixedWindow, Minute, 3, 1)
+	buckets := NewRateLimiter(backend, SlidingWindowBuckets, Minute, 3, 1)
+
+	result, err := fixed.Allow(ctx, "a", at, 1)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = buckets.Allow(ctx, "a:window3", at, 1)
+	assert.NoError(t, err)
+	assert.True(t, result.Allowed, "a key containing \":\" does not share the counters of another")
+
+	for _, n := range []int64{0, -1} {
+		_, err = fixed.Allow(ctx, "b", at, n)
+		assert.Error(t, err, "n must be positive")
+	}
+}
+
+// queryIncrementOnlyBackend hide the ConditionalIncrementBackend capability of the in memory backend
+type queryIncrementOnlyBackend struct {
+	QueryIncrementBackend
+}
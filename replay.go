@@ -0,0 +1,158 @@
+package rangecounter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LatencyPercentiles is the latency distribution of a kind of call.
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P90 time.Duration
+	P99 time.Duration
+	Max time.Duration
+}
+
+func newLatencyPercentiles(latencies []time.Duration) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	at := func(quantile float64) time.Duration {
+		return latencies[int(quantile*float64(len(latencies)-1))]
+	}
+	return LatencyPercentiles{
+		P50: at(0.5),
+		P90: at(0.9),
+		P99: at(0.99),
+		Max: latencies[len(latencies)-1],
+	}
+}
+
+// ReplayReport is the backend usage and latency of replaying a trace. Keys is the number of distinct keys
+// incremented, and IndexesWritten the number of distinct index incremented.
+type ReplayReport struct {
+	Increments int
+	Queries    int
+
+	ReadsPerQuery      float64
+	WritesPerIncrement float64
+	Keys               int
+	IndexesWritten     int
+
+	IncrementLatency LatencyPercentiles
+	QueryLatency     LatencyPercentiles
+}
+
+// KeysPerIndex is the number of keys used per index written.
+func (r ReplayReport) KeysPerIndex() float64 {
+	if r.IndexesWritten == 0 {
+		return 0
+	}
+	return float64(r.Keys) / float64(r.IndexesWritten)
+}
+
+// countingBackend count the keys touched by a counter, like benchmarkingInMemoryBackend but over any backend
+type countingBackend struct {
+	backend Backend
+
+	lock                sync.Mutex
+	queryKeyTouched     int64
+	incrementKeyTouched int64
+	keys                map[string]bool
+}
+
+func (c *countingBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	c.lock.Lock()
+	c.queryKeyTouched += int64(len(keys))
+	c.lock.Unlock()
+	return c.backend.Query(ctx, keys)
+}
+
+func (c *countingBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	c.lock.Lock()
+	c.incrementKeyTouched += int64(len(keys))
+	for _, key := range keys {
+		c.keys[key] = true
+	}
+	c.lock.Unlock()
+	return c.backend.Increment(ctx, keys, values)
+}
+
+// ReplayIntRangeTrace run the trace, in order, against the counter made by factory over backend, or an in memory
+// backend if nil. The reads include the keys a counter read while incrementing.
+func ReplayIntRangeTrace(ctx context.Context, trace []IntRangeOperation, backend Backend, factory func(backend Backend) IntRangeCounter) (ReplayReport, error) {
+	counting := newCountingBackend(backend)
+	counter := factory(counting)
+	return replay(counting, len(trace), func(i int) (bool, int64, error) {
+		operation := trace[i]
+		if operation.Op == IncrementOperation {
+			return true, operation.At, counter.Increment(ctx, operation.At, operation.By)
+		}
+		_, err := counter.QuerySum(ctx, operation.From, operation.To)
+		return false, 0, err
+	})
+}
+
+// ReplayDateRangeTrace is ReplayIntRangeTrace for a date range counter. IndexesWritten count the distinct buckets of
+// drange written.
+func ReplayDateRangeTrace(ctx context.Context, trace []DateRangeOperation, drange DateRange, backend Backend, factory func(backend Backend) DateRangeCounter) (ReplayReport, error) {
+	counting := newCountingBackend(backend)
+	counter := factory(counting)
+	return replay(counting, len(trace), func(i int) (bool, int64, error) {
+		operation := trace[i]
+		if operation.Op == IncrementOperation {
+			return true, drange.toIndex(operation.At), counter.Increment(ctx, operation.At, operation.By)
+		}
+		_, err := counter.QuerySum(ctx, operation.At, operation.BucketCount)
+		return false, 0, err
+	})
+}
+
+func newCountingBackend(backend Backend) *countingBackend {
+	if backend == nil {
+		backend = NewInMemoryBackend()
+	}
+	return &countingBackend{backend: backend, keys: map[string]bool{}}
+}
+
+// replay time each of the operations, which returns whether it is an increment and at which index
+func replay(counting *countingBackend, length int, operation func(i int) (bool, int64, error)) (ReplayReport, error) {
+	written := map[int64]bool{}
+	incrementLatencies := []time.Duration{}
+	queryLatencies := []time.Duration{}
+	for i := 0; i < length; i++ {
+		start := time.Now()
+		increment, index, err := operation(i)
+		latency := time.Since(start)
+		if err != nil {
+			return ReplayReport{}, errors.Wrapf(err, "unable to replay operation %v", i)
+		}
+		if increment {
+			incrementLatencies = append(incrementLatencies, latency)
+			written[index] = true
+		} else {
+			queryLatencies = append(queryLatencies, latency)
+		}
+	}
+
+	report := ReplayReport{
+		Increments:       len(incrementLatencies),
+		Queries:          len(queryLatencies),
+		Keys:             len(counting.keys),
+		IndexesWritten:   len(written),
+		IncrementLatency: newLatencyPercentiles(incrementLatencies),
+		QueryLatency:     newLatencyPercentiles(queryLatencies),
+	}
+	if report.Queries != 0 {
+		report.ReadsPerQuery = float64(counting.queryKeyTouched) / float64(report.Queries)
+	}
+	if report.Increments != 0 {
+		report.WritesPerIncrement = float64(counting.incrementKeyTouched) / float64(report.Increments)
+	}
+	return report, nil
+}
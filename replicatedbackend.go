@@ -0,0 +1,383 @@
+package rangecounter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Consistency is the number of replicas a replicated backend call must reach.
+type Consistency int
+
+const (
+	// ConsistencyQuorum need a majority of the replicas. It is the default.
+	ConsistencyQuorum Consistency = iota
+	// ConsistencyOne need a single replica
+	ConsistencyOne
+	// ConsistencyAll need every replica
+	ConsistencyAll
+)
+
+func (c Consistency) required(replicas int) int {
+	switch c {
+	case ConsistencyOne:
+		return 1
+	case ConsistencyAll:
+		return replicas
+	}
+	return replicas/2 + 1
+}
+
+// ReplicatedBackend is a Backend over several replicas, which keep the increments a replica failed to apply to
+// deliver them later.
+type ReplicatedBackend interface {
+	Backend
+	// Redeliver send the pending increments of every replica, and returns how many are still pending
+	Redeliver(ctx context.Context) (int, error)
+}
+
+// ReplicatedBackendOptions configure NewReplicatedBackend.
+type ReplicatedBackendOptions struct {
+	ReadConsistency  Consistency
+	WriteConsistency Consistency
+
+	// ReadRepair check the values of the replicas on Query, and when one disagree with the majority, increment it to
+	// the majority value. This is only right if every write to the replicas go through this backend, as the writes of
+	// another process look like divergence.
+	ReadRepair bool
+	// OnRepair is called for each repaired key with the replica index and the increment that repaired it
+	OnRepair func(key string, replica int, delta int64)
+}
+
+type pendingIncrement struct {
+	keys     []string
+	values   []int64
+	inFlight bool
+}
+
+type replicaState struct {
+	backend Backend
+
+	lock    sync.Mutex
+	pending map[uint64]*pendingIncrement
+}
+
+type replicatedBackend struct {
+	replicas []*replicaState
+	options  ReplicatedBackendOptions
+	nextOp   uint64
+
+	// repairLock is held shared by writes, and exclusively by read repair so that no write is in flight while it
+	// compare the replicas
+	repairLock sync.RWMutex
+}
+
+// NewReplicatedBackend write every increment to all replicas, and read from as many as the read consistency need.
+// A quorum or all read returns an error for a key whose value is not the same on that many replicas.
+// An increment a replica failed is logged for that replica under its operation id, and delivered with the next
+// increment or on Redeliver. Reads add the pending increments of a replica to its values, so a lagging replica still
+// read its writes. Delivery is at least once: a replica which applied an increment but still returned an error, like
+// on a timeout, count it twice, on reads while it is pending and for good once delivered. ReadRepair bring such a
+// replica back to the majority.
+//
+// An Increment which did not reach the write consistency returns an error but is still logged, so it must not be
+// retried by the caller. Wrap the replicas with NewResilientBackend to time out or skip an unhealthy replica.
+func NewReplicatedBackend(replicas []Backend, options ReplicatedBackendOptions) ReplicatedBackend {
+	if len(replicas) == 0 {
+		panic("replicas must not be empty")
+	}
+	replicated := &replicatedBackend{
+		options: options,
+	}
+	for _, backend := range replicas {
+		replicated.replicas = append(replicated.replicas, &replicaState{
+			backend: backend,
+			pending: map[uint64]*pendingIncrement{},
+		})
+	}
+	return replicated
+}
+
+// deliver send the pending increments of the replica which are not already being sent, after logging increment
+// if not nil
+func (p *replicaState) deliver(ctx context.Context, op uint64, increment *pendingIncrement) error {
+	p.lock.Lock()
+	if increment != nil {
+		p.pending[op] = increment
+	}
+	ops := []uint64{}
+	for id, it := range p.pending {
+		if !it.inFlight {
+			ops = append(ops, id)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i] < ops[j]
+	})
+	keys := []string{}
+	values := []int64{}
+	for _, id := range ops {
+		p.pending[id].inFlight = true
+		keys = append(keys, p.pending[id].keys...)
+		values = append(values, p.pending[id].values...)
+	}
+	p.lock.Unlock()
+
+	if len(ops) == 0 {
+		return nil
+	}
+	err := p.backend.Increment(ctx, keys, values)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, id := range ops {
+		if err == nil {
+			delete(p.pending, id)
+		} else {
+			p.pending[id].inFlight = false
+		}
+	}
+	return err
+}
+
+// pendingDeltas returns the sum of the pending increments of each key
+func (p *replicaState) pendingDeltas(keys []string) []int64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	deltas := make([]int64, len(keys))
+	if len(p.pending) == 0 {
+		return deltas
+	}
+	perKey := map[string]int64{}
+	for _, it := range p.pending {
+		for i, key := range it.keys {
+			perKey[key] += it.values[i]
+		}
+	}
+	for i, key := range keys {
+		deltas[i] = perKey[key]
+	}
+	return deltas
+}
+
+func (p *replicaState) pendingCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.pending)
+}
+
+// query returns the values of the replica with its pending increments added
+func (p *replicaState) query(ctx context.Context, keys []string) ([]int64, error) {
+	values, err := queryBackend(ctx, p.backend, keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, delta := range p.pendingDeltas(keys) {
+		values[i] += delta
+	}
+	return values, nil
+}
+
+// each call call on every replica concurrently, and returns the error of each
+func (r *replicatedBackend) each(call func(i int, replica *replicaState) error) []error {
+	errs := make([]error, len(r.replicas))
+	wg := sync.WaitGroup{}
+	for i, replica := range r.replicas {
+		wg.Add(1)
+		go func(i int, replica *replicaState) {
+			defer wg.Done()
+			errs[i] = call(i, replica)
+		}(i, replica)
+	}
+	wg.Wait()
+	return errs
+}
+
+// reached returns an error if less than required of errs are nil
+func reached(errs []error, required int, operation string) error {
+	var firstErr error
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if succeeded < required {
+		return errors.Wrapf(firstErr, "%v reached %v of %v replicas, %v required", operation, succeeded, len(errs), required)
+	}
+	return nil
+}
+
+func (r *replicatedBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	r.repairLock.RLock()
+	defer r.repairLock.RUnlock()
+
+	op := atomic.AddUint64(&r.nextOp, 1)
+	errs := r.each(func(i int, replica *replicaState) error {
+		return replica.deliver(ctx, op, &pendingIncrement{keys: keys, values: values})
+	})
+	return reached(errs, r.options.WriteConsistency.required(len(r.replicas)), "increment")
+}
+
+func (r *replicatedBackend) Redeliver(ctx context.Context) (int, error) {
+	r.repairLock.RLock()
+	defer r.repairLock.RUnlock()
+
+	errs := r.each(func(i int, replica *replicaState) error {
+		return replica.deliver(ctx, 0, nil)
+	})
+	pending := 0
+	for _, replica := range r.replicas {
+		pending += replica.pendingCount()
+	}
+	return pending, reached(errs, len(r.replicas), "redelivery")
+}
+
+func (r *replicatedBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	if r.options.ReadConsistency == ConsistencyOne {
+		return r.queryOne(ctx, keys)
+	}
+
+	required := r.options.ReadConsistency.required(len(r.replicas))
+	r.repairLock.RLock()
+	replicaValues, errs := r.queryReplicas(ctx, keys)
+	r.repairLock.RUnlock()
+	if err := reached(errs, required, "query"); err != nil {
+		return nil, err
+	}
+
+	values := make([]int64, len(keys))
+	var quorumErr error
+	diverged := false
+	for k, key := range keys {
+		value, count, answered := majority(replicaValues, k)
+		values[k] = value
+		diverged = diverged || count != answered
+		if count < required && quorumErr == nil {
+			quorumErr = errors.Errorf("value of key %v is on %v replicas, %v required", key, count, required)
+		}
+	}
+	if diverged && r.options.ReadRepair {
+		return r.repair(ctx, keys)
+	}
+	if quorumErr != nil {
+		return nil, quorumErr
+	}
+	return values, nil
+}
+
+// queryOne query the replicas one at a time, the ones without pending increments first, until one answer
+func (r *replicatedBackend) queryOne(ctx context.Context, keys []string) ([]int64, error) {
+	order := make([]int, len(r.replicas))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return r.replicas[order[i]].pendingCount() == 0 && r.replicas[order[j]].pendingCount() != 0
+	})
+
+	errs := []error{}
+	for _, i := range order {
+		values, err := r.replicas[i].query(ctx, keys)
+		if err == nil {
+			return values, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, reached(errs, 1, "query")
+}
+
+func (r *replicatedBackend) queryReplicas(ctx context.Context, keys []string) ([][]int64, []error) {
+	replicaValues := make([][]int64, len(r.replicas))
+	errs := r.each(func(i int, replica *replicaState) error {
+		values, err := replica.query(ctx, keys)
+		replicaValues[i] = values
+		return err
+	})
+	return replicaValues, errs
+}
+
+// majority returns the most common value of the key at k among the replicas which answered, how many have it, and how
+// many answered
+func majority(replicaValues [][]int64, k int) (int64, int, int) {
+	counts := map[int64]int{}
+	answered := 0
+	best, bestCount := int64(0), 0
+	for _, values := range replicaValues {
+		if values == nil {
+			continue
+		}
+		answered++
+		counts[values[k]]++
+		if counts[values[k]] > bestCount {
+			best, bestCount = values[k], counts[values[k]]
+		}
+	}
+	return best, bestCount, answered
+}
+
+// repair query every replica with no write in flight, and increment the replicas which disagree with the majority.
+// A key without a majority among the replicas which answered cannot be repaired, and fail the query if it does not
+// have the value of enough replicas either.
+func (r *replicatedBackend) repair(ctx context.Context, keys []string) ([]int64, error) {
+	r.repairLock.Lock()
+	defer r.repairLock.Unlock()
+
+	required := r.options.ReadConsistency.required(len(r.replicas))
+	replicaValues, errs := r.queryReplicas(ctx, keys)
+	if err := reached(errs, required, "query"); err != nil {
+		return nil, err
+	}
+
+	values := make([]int64, len(keys))
+	repairs := make([]*pendingIncrement, len(r.replicas))
+	repaired := map[string]bool{}
+	for k, key := range keys {
+		value, count, answered := majority(replicaValues, k)
+		values[k] = value
+		if count*2 <= answered && count < required {
+			return nil, errors.Errorf("value of key %v is on %v replicas, %v required", key, count, required)
+		}
+		if count == answered || count*2 <= answered || repaired[key] {
+			continue
+		}
+		repaired[key] = true
+
+		for i, replicaValue := range replicaValues {
+			if replicaValue == nil || replicaValue[k] == value {
+				continue
+			}
+			if repairs[i] == nil {
+				repairs[i] = &pendingIncrement{}
+			}
+			repairs[i].keys = append(repairs[i].keys, key)
+			repairs[i].values = append(repairs[i].values, value-replicaValue[k])
+		}
+	}
+
+	for i, repair := range repairs {
+		if repair == nil {
+			continue
+		}
+		op := atomic.AddUint64(&r.nextOp, 1)
+		err := r.replicas[i].deliver(ctx, op, repair)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to repair replica %v", i)
+		}
+		if r.options.OnRepair != nil {
+			for j, key := range repair.keys {
+				r.options.OnRepair(key, i, repair.values[j])
+			}
+		}
+	}
+	return values, nil
+}
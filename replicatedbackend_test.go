@@ -0,0 +1,208 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestReplicas returns in memory replicas, and the flags that make each of them fail every call
+func newTestReplicas(count int) ([]Backend, []Backend, []bool) {
+	stores := []Backend{}
+	replicas := []Backend{}
+	down := make([]bool, count)
+	for i := 0; i < count; i++ {
+		i := i
+		stores = append(stores, NewInMemoryBackend())
+		replicas = append(replicas, NewFaultInjectingBackend(stores[i], func(call FaultCall) Fault {
+			if down[i] {
+				return Fault{Err: ErrInjectedFault}
+			}
+			return Fault{}
+		}))
+	}
+	return stores, replicas, down
+}
+
+func TestReplicatedBackendConsistency(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name             string
+		readConsistency  Consistency
+		writeConsistency Consistency
+		down             int
+		writeErr         bool
+		readErr          bool
+	}{
+		{
+			name: "quorum with one down",
+			down: 1,
+		},
+		{
+			name:     "quorum with two down",
+			down:     2,
+			writeErr: true,
+			readErr:  true,
+		},
+		{
+			name:             "one with two down",
+			readConsistency:  ConsistencyOne,
+			writeConsistency: ConsistencyOne,
+			down:             2,
+		},
+		{
+			name:             "all with one down",
+			readConsistency:  ConsistencyAll,
+			writeConsistency: ConsistencyAll,
+			down:             1,
+			writeErr:         true,
+			readErr:          true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stores, replicas, down := newTestReplicas(3)
+			backend := NewReplicatedBackend(replicas, ReplicatedBackendOptions{
+				ReadConsistency:  test.readConsistency,
+				WriteConsistency: test.writeConsistency,
+			})
+			for i := 0; i < test.down; i++ {
+				down[i] = true
+			}
+
+			err := backend.Increment(ctx, []string{"a"}, []int64{1})
+			assert.Equal(t, test.writeErr, err != nil, "write error %v", err)
+			results, err := backend.Query(ctx, []string{"a"})
+			assert.Equal(t, test.readErr, err != nil, "read error %v", err)
+			if err == nil {
+				assert.Equal(t, []int64{1}, results)
+			}
+
+			for i := range down {
+				down[i] = false
+			}
+			pending, err := backend.Redeliver(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, 0, pending)
+			for i, store := range stores {
+				results, err := store.Query(ctx, []string{"a"})
+				assert.NoError(t, err)
+				assert.Equal(t, []int64{1}, results, "replica %v applied the increment exactly once", i)
+			}
+		})
+	}
+}
+
+func TestReplicatedBackendPendingLog(t *testing.T) {
+	ctx := context.Background()
+	stores, replicas, down := newTestReplicas(3)
+	backend := NewReplicatedBackend(replicas, ReplicatedBackendOptions{ReadConsistency: ConsistencyOne})
+
+	down[0] = true
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, backend.Increment(ctx, []string{"a", "b"}, []int64{1, 2}))
+	}
+	pending, err := backend.Redeliver(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 5, pending)
+
+	down[0] = false
+	down[1], down[2] = true, true
+	results, err := backend.Query(ctx, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5, 10}, results, "the lagging replica read with its pending increments")
+
+	down[1], down[2] = false, false
+	assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{1}), "deliver the log with the next increment")
+	results, err = stores[0].Query(ctx, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{6, 10}, results)
+	pending, err = backend.Redeliver(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pending)
+}
+
+func TestReplicatedBackendReadRepair(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		replicas int
+		value    int64
+		err      bool
+		repaired []int64
+	}{
+		{
+			name:     "majority",
+			replicas: 3,
+			value:    3,
+			repaired: []int64{3, 3, 3},
+		},
+		{
+			name:     "tie",
+			replicas: 2,
+			err:      true,
+			repaired: []int64{3, 13},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stores, replicas, _ := newTestReplicas(test.replicas)
+			repairs := 0
+			backend := NewReplicatedBackend(replicas, ReplicatedBackendOptions{
+				ReadRepair: true,
+				OnRepair: func(key string, replica int, delta int64) {
+					assert.Equal(t, "a", key)
+					assert.Equal(t, test.replicas-1, replica)
+					assert.EqualValues(t, -10, delta)
+					repairs++
+				},
+			})
+
+			assert.NoError(t, backend.Increment(ctx, []string{"a"}, []int64{3}))
+			assert.NoError(t, stores[test.replicas-1].Increment(ctx, []string{"a"}, []int64{10}))
+
+			results, err := backend.Query(ctx, []string{"a", "a"})
+			if test.err {
+				assert.Error(t, err, "no value is on a quorum of replicas")
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, []int64{test.value, test.value}, results)
+			}
+
+			for i, store := range stores {
+				results, err := store.Query(ctx, []string{"a"})
+				assert.NoError(t, err)
+				assert.Equal(t, test.repaired[i], results[0], "replica %v", i)
+			}
+			if test.replicas > 2 {
+				assert.Equal(t, 1, repairs, "a key queried twice is repaired once")
+			}
+		})
+	}
+}
+
+func TestReplicatedBackendQuorumAgreement(t *testing.T) {
+	ctx := context.Background()
+	for _, readRepair := range []bool{false, true} {
+		stores, replicas, down := newTestReplicas(3)
+		backend := NewReplicatedBackend(replicas, ReplicatedBackendOptions{ReadRepair: readRepair})
+		assert.NoError(t, stores[1].Increment(ctx, []string{"a", "b"}, []int64{5, 1}))
+		assert.NoError(t, stores[2].Increment(ctx, []string{"a", "b"}, []int64{7, 1}))
+		down[0] = true
+
+		_, err := backend.Query(ctx, []string{"b", "a"})
+		assert.Error(t, err, "the two replicas answering disagree on a, read repair %v", readRepair)
+		results, err := backend.Query(ctx, []string{"b"})
+		assert.NoError(t, err, "read repair %v", readRepair)
+		assert.Equal(t, []int64{1}, results)
+
+		for i, expected := range []int64{5, 7} {
+			results, err := stores[i+1].Query(ctx, []string{"a"})
+			assert.NoError(t, err)
+			assert.Equal(t, []int64{expected}, results, "replica %v is not repaired", i+1)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrCircuitOpen is returned by a resilient backend without calling the backend, while its circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitState is the state of a resilient backend's circuit breaker.
+type CircuitState int
+
+const (
+	// CircuitClosed let every call through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen fail every call with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen let a single call through, to test whether the backend recovered
+	CircuitHalfOpen
+)
+
+func (c CircuitState) String() string {
+	switch c {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown circuit state"
+}
+
+// ResilientBackendOptions configure NewResilientBackend. Zero values disable the corresponding feature.
+type ResilientBackendOptions struct {
+	// Timeout is the limit of each attempt, within the deadline of the context. An attempt which timed out is
+	// abandoned rather than stopped: if the backend ignores its context, its goroutine leaks until the backend
+	// returns, and an Increment may still be applied after its timeout error is returned. So a timed out
+	// Increment, QueryIncrement or IncrementIfBelow is never retried.
+	Timeout time.Duration
+
+	// QueryRetries is the number of times a failed Query is retried
+	QueryRetries int
+	// IncrementRetries is the number of times a failed Increment is retried, if RetryIncrementIf returns true.
+	// Timeouts are never retried, and as other failed Increments may still be applied too, it should only return true
+	// for errors which are known to happen before the backend applied it, like a refused connection.
+	IncrementRetries int
+	RetryIncrementIf func(err error) bool
+	// InitialBackoff is the maximum wait before the first retry, which doubles each retry up to MaxBackoff.
+	// The actual wait is random, up to that maximum.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// FailureThreshold is the number of consecutive failed attempts which open the circuit breaker
+	FailureThreshold int
+	// OpenDuration is how long the circuit breaker stays open before letting a call through
+	OpenDuration time.Duration
+	// OnStateChange is called on each transition of the circuit breaker, while it is locked
+	OnStateChange func(from, to CircuitState)
+
+	now   func() time.Time
+	sleep func(ctx context.Context, duration time.Duration) error
+}
+
+type resilientBackend struct {
+	backend Backend
+	options ResilientBackendOptions
+
+	lock                sync.Mutex
+	state               CircuitState
+	consecutiveFailures int
+	openedAt            time.Time
+	halfOpenInFlight    bool
+}
+
+// NewResilientBackend add timeouts, retries and a circuit breaker to backend. Query is retried as it is idempotent,
+// while Increment, QueryIncrement and IncrementIfBelow are only retried according to RetryIncrementIf.
+// The capabilities of backend are kept by KeepCapabilities.
+func NewResilientBackend(backend Backend, options ResilientBackendOptions) Backend {
+	if options.now == nil {
+		options.now = time.Now
+	}
+	if options.sleep == nil {
+		options.sleep = sleepContext
+	}
+	return KeepCapabilities(backend, &resilientBackend{
+		backend: backend,
+		options: options,
+	})
+}
+
+func (r *resilientBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	return resilientCall(ctx, r, r.options.QueryRetries, retryAlways, func(ctx context.Context) ([]int64, error) {
+		return r.backend.Query(ctx, keys)
+	})
+}
+
+func (r *resilientBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	_, err := resilientCall(ctx, r, r.options.IncrementRetries, r.retryIncrementIf, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, r.backend.Increment(ctx, keys, values)
+	})
+	return err
+}
+
+func retryAlways(err error) bool {
+	return true
+}
+
+// retryIncrementIf is RetryIncrementIf, except that an increment which timed out may have been applied
+func (r *resilientBackend) retryIncrementIf(err error) bool {
+	return r.options.RetryIncrementIf != nil && !errors.Is(err, context.DeadlineExceeded) && r.options.RetryIncrementIf(err)
+}
+
+// resilientCall call attempt up to retries+1 times, while retryIf, which never retry if nil, allows it
+func resilientCall[T any](ctx context.Context, r *resilientBackend, retries int, retryIf func(err error) bool, attempt func(ctx context.Context) (T, error)) (T, error) {
+	var lastErr error
+	for try := 0; ; try++ {
+		result, err := resilientAttempt(ctx, r, attempt)
+		if errors.Is(err, ErrCircuitOpen) && lastErr != nil {
+			// the previous attempts opened the circuit, their error is the more useful one
+			return result, lastErr
+		}
+		if err == nil || errors.Is(err, ErrCircuitOpen) || ctx.Err() != nil || try >= retries || retryIf == nil || !retryIf(err) {
+			return result, err
+		}
+		lastErr = err
+
+		backoff := r.backoff(try)
+		if backoff > 0 && r.options.sleep(ctx, time.Duration(rand.Int63n(int64(backoff)+1))) != nil {
+			return result, err
+		}
+	}
+}
+
+// backoff returns the maximum wait after the given try, InitialBackoff doubled each try up to MaxBackoff, without
+// overflowing
+func (r *resilientBackend) backoff(try int) time.Duration {
+	backoff := r.options.InitialBackoff
+	for ; try > 0 && backoff <= math.MaxInt64/2; try-- {
+		if r.options.MaxBackoff > 0 && backoff >= r.options.MaxBackoff {
+			break
+		}
+		backoff *= 2
+	}
+	if r.options.MaxBackoff > 0 && backoff > r.options.MaxBackoff {
+		backoff = r.options.MaxBackoff
+	}
+	return backoff
+}
+
+// resilientAttempt call once through the circuit breaker and within the timeout
+func resilientAttempt[T any](ctx context.Context, r *resilientBackend, attempt func(ctx context.Context) (T, error)) (T, error) {
+	halfOpen, err := r.allow()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	result, err := withTimeout(ctx, r.options.Timeout, attempt)
+	if ctx.Err() != nil {
+		// a cancelled caller says nothing about the backend health
+		r.release(halfOpen)
+		return result, err
+	}
+	r.record(err != nil, halfOpen)
+	return result, err
+}
+
+// withTimeout returns once the attempt is done, or the timeout or context expires, even if the attempt ignores
+// its context.
+func withTimeout[T any](ctx context.Context, timeout time.Duration, attempt func(ctx context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return attempt(ctx)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	type outcome struct {
+		result T
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := attempt(ctx)
+		done <- outcome{result, err}
+	}()
+	select {
+	case it := <-done:
+		return it.result, it.err
+	case <-ctx.Done():
+		var zero T
+		return zero, errors.Wrap(ctx.Err(), "backend call timed out")
+	}
+}
+
+// allow returns ErrCircuitOpen if the call should not be made, and whether it is the half open trial call
+func (r *resilientBackend) allow() (bool, error) {
+	if r.options.FailureThreshold <= 0 {
+		return false, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch r.state {
+	case CircuitOpen:
+		if r.options.now().Sub(r.openedAt) < r.options.OpenDuration {
+			return false, ErrCircuitOpen
+		}
+		r.transition(CircuitHalfOpen)
+		r.halfOpenInFlight = true
+		return true, nil
+	case CircuitHalfOpen:
+		if r.halfOpenInFlight {
+			return false, ErrCircuitOpen
+		}
+		r.halfOpenInFlight = true
+		return true, nil
+	}
+	return false, nil
+}
+
+// release end a call without recording its outcome, letting another half open trial call be made
+func (r *resilientBackend) release(halfOpen bool) {
+	if !halfOpen {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.halfOpenInFlight = false
+}
+
+func (r *resilientBackend) record(failed bool, halfOpen bool) {
+	if r.options.FailureThreshold <= 0 {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if halfOpen {
+		r.halfOpenInFlight = false
+	}
+
+	if !failed {
+		r.consecutiveFailures = 0
+		if r.state != CircuitClosed {
+			r.transition(CircuitClosed)
+		}
+		return
+	}
+
+	r.consecutiveFailures++
+	if r.state == CircuitHalfOpen || (r.state == CircuitClosed && r.consecutiveFailures >= r.options.FailureThreshold) {
+		r.openedAt = r.options.now()
+		r.transition(CircuitOpen)
+	}
+}
+
+func (r *resilientBackend) transition(to CircuitState) {
+	from := r.state
+	r.state = to
+	if r.options.OnStateChange != nil {
+		r.options.OnStateChange(from, to)
+	}
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *resilientBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	return resilientCall(ctx, r, r.options.IncrementRetries, r.retryIncrementIf, func(ctx context.Context) ([]int64, error) {
+		return r.backend.(QueryIncrementBackend).QueryIncrement(ctx, queryKeys, incrementKeys, values)
+	})
+}
+
+func (r *resilientBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	type outcome struct {
+		applied bool
+		sum     int64
+	}
+	result, err := resilientCall(ctx, r, r.options.IncrementRetries, r.retryIncrementIf, func(ctx context.Context) (outcome, error) {
+		applied, sum, err := r.backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+		return outcome{applied, sum}, err
+	})
+	return result.applied, result.sum, err
+}
@@ -0,0 +1,288 @@
+package rangecounter
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+var errUnavailable = errors.New("unavailable")
+
+// flakyBackend fail the next `failures` calls, then delegate to backend
+type flakyBackend struct {
+	Backend
+	failures int
+	calls    int
+	block    chan struct{}
+}
+
+func (f *flakyBackend) fail() error {
+	f.calls++
+	if f.block != nil {
+		<-f.block
+	}
+	if f.failures > 0 {
+		f.failures--
+		return errUnavailable
+	}
+	return nil
+}
+
+func (f *flakyBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Backend.Query(ctx, keys)
+}
+
+func (f *flakyBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Backend.Increment(ctx, keys, values)
+}
+
+func noSleep(ctx context.Context, duration time.Duration) error {
+	return nil
+}
+
+func TestResilientBackendRetries(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		failures  int
+		options   ResilientBackendOptions
+		increment bool
+		calls     int
+		err       bool
+	}{
+		{
+			name:     "query retried",
+			failures: 2,
+			options:  ResilientBackendOptions{QueryRetries: 2},
+			calls:    3,
+		},
+		{
+			name:     "query out of retries",
+			failures: 3,
+			options:  ResilientBackendOptions{QueryRetries: 2},
+			calls:    3,
+			err:      true,
+		},
+		{
+			name:      "increment not retried without policy",
+			failures:  1,
+			options:   ResilientBackendOptions{IncrementRetries: 2},
+			increment: true,
+			calls:     1,
+			err:       true,
+		},
+		{
+			name:     "increment retried by policy",
+			failures: 1,
+			options: ResilientBackendOptions{IncrementRetries: 2, RetryIncrementIf: func(err error) bool {
+				return errors.Is(err, errUnavailable)
+			}},
+			increment: true,
+			calls:     2,
+		},
+		{
+			name:     "increment policy refuse",
+			failures: 1,
+			options: ResilientBackendOptions{IncrementRetries: 2, RetryIncrementIf: func(err error) bool {
+				return false
+			}},
+			increment: true,
+			calls:     1,
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flaky := &flakyBackend{Backend: NewInMemoryBackend(), failures: test.failures}
+			test.options.InitialBackoff = time.Millisecond
+			test.options.sleep = noSleep
+			backend := NewResilientBackend(flaky, test.options)
+
+			var err error
+			if test.increment {
+				err = backend.Increment(ctx, []string{"a"}, []int64{1})
+			} else {
+				_, err = backend.Query(ctx, []string{"a"})
+			}
+			assert.Equal(t, test.err, err != nil, "error %v", err)
+			assert.Equal(t, test.calls, flaky.calls)
+		})
+	}
+}
+
+func TestResilientBackendTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	backend := NewResilientBackend(&flakyBackend{Backend: NewInMemoryBackend(), block: block}, ResilientBackendOptions{
+		Timeout: 10 * time.Millisecond,
+	})
+
+	_, err := backend.Query(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "a backend ignoring its context still time out")
+}
+
+func TestResilientBackendCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var transitions []string
+
+	flaky := &flakyBackend{Backend: NewInMemoryBackend(), failures: 3}
+	backend := NewResilientBackend(flaky, ResilientBackendOptions{
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+		now: func() time.Time {
+			return now
+		},
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := backend.Query(ctx, []string{"a"})
+		assert.ErrorIs(t, err, errUnavailable)
+	}
+	_, err := backend.Query(ctx, []string{"a"})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, flaky.calls, "an open circuit does not call the backend")
+
+	now = now.Add(time.Minute)
+	_, err = backend.Query(ctx, []string{"a"})
+	assert.ErrorIs(t, err, errUnavailable, "the half open trial failed")
+	_, err = backend.Query(ctx, []string{"a"})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	now = now.Add(time.Minute)
+	_, err = backend.Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	_, err = backend.Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, flaky.calls)
+
+	assert.Equal(t, []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}, transitions)
+}
+
+func TestResilientBackendCancelledCalls(t *testing.T) {
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	var transitions []string
+	flaky := &flakyBackend{Backend: NewInMemoryBackend()}
+	backend := NewResilientBackend(flaky, ResilientBackendOptions{
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		OnStateChange: func(from, to CircuitState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		},
+		now: func() time.Time {
+			return now
+		},
+	})
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	flaky.failures = 1
+	_, err := backend.Query(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, errUnavailable)
+	_, err = backend.Query(cancelled, []string{"a"})
+	assert.NoError(t, err, "the backend ignore the context")
+	flaky.failures = 1
+	_, err = backend.Query(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, errUnavailable)
+	assert.Equal(t, []string{"closed->open"}, transitions, "the cancelled call did not reset the failures")
+
+	now = now.Add(time.Minute)
+	_, err = backend.Query(cancelled, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"closed->open", "open->half-open"}, transitions, "the cancelled trial did not close it")
+	_, err = backend.Query(context.Background(), []string{"a"})
+	assert.NoError(t, err, "another trial is let through")
+	assert.Equal(t, []string{"closed->open", "open->half-open", "half-open->closed"}, transitions)
+}
+
+func TestResilientBackendRetryOpenCircuit(t *testing.T) {
+	flaky := &flakyBackend{Backend: NewInMemoryBackend(), failures: 5}
+	backend := NewResilientBackend(flaky, ResilientBackendOptions{
+		QueryRetries:     2,
+		FailureThreshold: 1,
+		OpenDuration:     time.Minute,
+		sleep:            noSleep,
+	})
+
+	_, err := backend.Query(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, errUnavailable, "the error which opened the circuit")
+	assert.Equal(t, 1, flaky.calls)
+
+	_, err = backend.Query(context.Background(), []string{"a"})
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+}
+
+func TestResilientBackendCapabilities(t *testing.T) {
+	ctx := context.Background()
+	backend := NewResilientBackend(NewInMemoryBackend(), ResilientBackendOptions{})
+
+	_, ok := backend.(QueryIncrementBackend)
+	assert.True(t, ok)
+	conditional, ok := backend.(ConditionalIncrementBackend)
+	assert.True(t, ok)
+
+	applied, sum, err := conditional.IncrementIfBelow(ctx, []string{"a"}, 2, 3, []string{"a"}, []int64{2})
+	assert.NoError(t, err)
+	assert.True(t, applied)
+	assert.EqualValues(t, 0, sum, "the sum before the increment")
+
+	_, ok = NewResilientBackend(&flakyBackend{Backend: NewInMemoryBackend()}, ResilientBackendOptions{}).(ConditionalIncrementBackend)
+	assert.False(t, ok)
+}
+
+func TestResilientBackendNoIncrementRetryAfterTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	var calls int32
+	flaky := &flakyBackend{Backend: NewInMemoryBackend(), block: block}
+	backend := NewResilientBackend(&incrementCountingBackend{Backend: flaky, calls: &calls}, ResilientBackendOptions{
+		Timeout:          10 * time.Millisecond,
+		IncrementRetries: 3,
+		RetryIncrementIf: retryAlways,
+		sleep:            noSleep,
+	})
+
+	err := backend.Increment(context.Background(), []string{"a"}, []int64{1})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "a timed out increment may still be applied")
+}
+
+// incrementCountingBackend count the Increment calls, which may be abandoned while still running
+type incrementCountingBackend struct {
+	Backend
+	calls *int32
+}
+
+func (c *incrementCountingBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	atomic.AddInt32(c.calls, 1)
+	return c.Backend.Increment(ctx, keys, values)
+}
+
+func TestResilientBackendBackoff(t *testing.T) {
+	backend := &resilientBackend{options: ResilientBackendOptions{InitialBackoff: time.Millisecond}}
+	assert.Equal(t, 4*time.Millisecond, backend.backoff(2))
+	assert.Greater(t, backend.backoff(1000), time.Duration(0), "the backoff does not overflow")
+
+	backend.options.MaxBackoff = time.Second
+	assert.Equal(t, time.Second, backend.backoff(1000))
+}
@@ -86,6 +86,16 @@ func TestDateRangeBehaviour(t *testing.T) {
 		"intRangeTreeBacked4": func(dateRange DateRange) DateRangeCounter {
 			return NewIntBackedDateRange(NewIntRangeTranslator(NewRangeTreeIntCounter(NewInMemoryBackend(), 16, 3), dateRange, Seconds), dateRange)
 		},
+		"hybrid": func(dateRange DateRange) DateRangeCounter {
+			return NewIntBackedDateRange(NewHybridIntRangeCounter(NewInMemoryBackend(), 8, 1, []int{2, 4, 6}), dateRange)
+		},
+		"prefixSum": func(dateRange DateRange) DateRangeCounter {
+			return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(NewInMemoryBackend(), "prefixsum", nil), dateRange)
+		},
+		"prefixSumOverlay": func(dateRange DateRange) DateRangeCounter {
+			backend := NewInMemoryBackend()
+			return NewIntBackedDateRange(NewPrefixSumIntRangeCounter(backend, "prefixsum", NewRangeTreeIntCounter(backend, 8, 1)), dateRange)
+		},
 	}
 	rangeToTests := []DateRange{Hour}
 	for counterName, dateCounterFactory := range counterToTest {
@@ -231,6 +241,19 @@ func TestIntRangeCounterBehavior(t *testing.T) {
 		"intRangeTreeBacked5": func() IntRangeCounter {
 			return NewRangeTreeIntCounter(NewInMemoryBackend(), 50, 1)
 		},
+		"hybrid": func() IntRangeCounter {
+			return NewHybridIntRangeCounter(NewInMemoryBackend(), 8, 1, []int{2, 4, 6})
+		},
+		"hybridNoLevel": func() IntRangeCounter {
+			return NewHybridIntRangeCounter(NewInMemoryBackend(), 4, 2, nil)
+		},
+		"prefixSum": func() IntRangeCounter {
+			return NewPrefixSumIntRangeCounter(NewInMemoryBackend(), "prefixsum", nil)
+		},
+		"prefixSumOverlay": func() IntRangeCounter {
+			backend := NewInMemoryBackend()
+			return NewPrefixSumIntRangeCounter(backend, "prefixsum", NewBasicIntRangeCounter(backend))
+		},
 	}
 	for counterName, intCounterFactory := range counterToTest {
 		t.Run("counter "+counterName, func(t *testing.T) {
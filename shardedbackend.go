@@ -0,0 +1,306 @@
+package rangecounter
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ShardedBackendOptions configure NewShardedBackend.
+type ShardedBackendOptions struct {
+	// VirtualNodes is the number of points of each shard on the hash ring, 100 if zero. More points spread the keys
+	// more evenly.
+	VirtualNodes int
+	// ShardKey returns the part of a key which pick its shard, so keys with the same shard key are co-located.
+	// HashTag if nil.
+	ShardKey func(key string) string
+}
+
+type ringPoint struct {
+	hash  uint64
+	shard int
+}
+
+type shardedBackend struct {
+	names    []string
+	shards   []Backend
+	ring     []ringPoint
+	shardKey func(key string) string
+
+	// conditionalLock serialize the conditional increments spread over several shards
+	conditionalLock sync.Mutex
+}
+
+// NewShardedBackend spread the keys over shards by consistent hashing of their name, so adding or removing a shard
+// only move the keys of that shard. Each call is split per shard, and the shards are called concurrently.
+// The returned backend is a QueryIncrementBackend or a ConditionalIncrementBackend if all shards are. A conditional
+// increment whose keys are all on one shard is atomic on that shard, otherwise it is only atomic against the other
+// conditional increments of the returned backend.
+//
+// An Increment or QueryIncrement spread over several shards which returns an error may still be applied on the other
+// shards, so it must not be retried by the caller.
+func NewShardedBackend(shards map[string]Backend, options ShardedBackendOptions) Backend {
+	if len(shards) == 0 {
+		panic("shards must not be empty")
+	}
+	if options.VirtualNodes <= 0 {
+		options.VirtualNodes = 100
+	}
+	if options.ShardKey == nil {
+		options.ShardKey = HashTag
+	}
+
+	sharded := &shardedBackend{
+		shardKey: options.ShardKey,
+	}
+	for name := range shards {
+		sharded.names = append(sharded.names, name)
+	}
+	sort.Strings(sharded.names)
+	for i, name := range sharded.names {
+		sharded.shards = append(sharded.shards, shards[name])
+		for v := 0; v < options.VirtualNodes; v++ {
+			sharded.ring = append(sharded.ring, ringPoint{
+				hash:  ringHash(name + "#" + strconv.Itoa(v)),
+				shard: i,
+			})
+		}
+	}
+	sort.Slice(sharded.ring, func(i, j int) bool {
+		return sharded.ring[i].hash < sharded.ring[j].hash
+	})
+
+	isQueryIncrement, isConditional := true, true
+	for _, shard := range sharded.shards {
+		_, ok := shard.(QueryIncrementBackend)
+		isQueryIncrement = isQueryIncrement && ok
+		_, ok = shard.(ConditionalIncrementBackend)
+		isConditional = isConditional && ok
+	}
+	return withCapabilities(sharded, isQueryIncrement, isConditional)
+}
+
+// HashTag returns the part of key between the first "{" and the next "}", like a redis cluster hash tag, or the whole
+// key if there is no such non empty part.
+func HashTag(key string) string {
+	start := strings.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+	end := strings.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+	return key[start+1 : start+1+end]
+}
+
+func ringHash(value string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(value))
+	// fnv alone spread keys which differ by their last characters poorly, so mix it like splitmix64
+	x := hash.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// shardOf returns the index of the shard of key, which is the first point of the ring at or after its hash
+func (s *shardedBackend) shardOf(key string) int {
+	hash := ringHash(s.shardKey(key))
+	i := sort.Search(len(s.ring), func(i int) bool {
+		return s.ring[i].hash >= hash
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].shard
+}
+
+// shardBatch is the part of a call for one shard, with the position of its query keys in the call
+type shardBatch struct {
+	queryKeys      []string
+	queryPositions []int
+	incrementKeys  []string
+	values         []int64
+	results        []int64
+}
+
+func (s *shardedBackend) split(queryKeys []string, incrementKeys []string, values []int64) (map[int]*shardBatch, error) {
+	if len(values) != len(incrementKeys) {
+		return nil, errors.Errorf("got %v values for %v keys", len(values), len(incrementKeys))
+	}
+
+	batches := map[int]*shardBatch{}
+	batchOf := func(key string) *shardBatch {
+		shard := s.shardOf(key)
+		batch, ok := batches[shard]
+		if !ok {
+			batch = &shardBatch{}
+			batches[shard] = batch
+		}
+		return batch
+	}
+
+	for i, key := range queryKeys {
+		batch := batchOf(key)
+		batch.queryKeys = append(batch.queryKeys, key)
+		batch.queryPositions = append(batch.queryPositions, i)
+	}
+	for i, key := range incrementKeys {
+		batch := batchOf(key)
+		batch.incrementKeys = append(batch.incrementKeys, key)
+		batch.values = append(batch.values, values[i])
+	}
+	return batches, nil
+}
+
+// call run call for each batch, concurrently if there is more than one, and returns the results in the order of
+// queryKeys
+func (s *shardedBackend) call(ctx context.Context, queryKeys []string, batches map[int]*shardBatch, call func(ctx context.Context, shard int, batch *shardBatch) error) ([]int64, error) {
+	run := func(shard int, batch *shardBatch) error {
+		err := call(ctx, shard, batch)
+		if err != nil {
+			return errors.Wrapf(err, "shard %v failed", s.names[shard])
+		}
+		if len(batch.results) != len(batch.queryKeys) {
+			return errors.Errorf("shard %v returned %v results for %v keys", s.names[shard], len(batch.results), len(batch.queryKeys))
+		}
+		return nil
+	}
+
+	if len(batches) == 1 {
+		for shard, batch := range batches {
+			if err := run(shard, batch); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		errs := make(chan error, len(batches))
+		wg := sync.WaitGroup{}
+		for shard, batch := range batches {
+			wg.Add(1)
+			go func(shard int, batch *shardBatch) {
+				defer wg.Done()
+				errs <- run(shard, batch)
+			}(shard, batch)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	results := make([]int64, len(queryKeys))
+	for _, batch := range batches {
+		for i, position := range batch.queryPositions {
+			results[position] = batch.results[i]
+		}
+	}
+	return results, nil
+}
+
+func (s *shardedBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	batches, err := s.split(keys, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.call(ctx, keys, batches, func(ctx context.Context, shard int, batch *shardBatch) error {
+		results, err := queryBackend(ctx, s.shards[shard], batch.queryKeys)
+		batch.results = results
+		return err
+	})
+}
+
+func (s *shardedBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	batches, err := s.split(nil, keys, values)
+	if err != nil {
+		return err
+	}
+	_, err = s.call(ctx, nil, batches, func(ctx context.Context, shard int, batch *shardBatch) error {
+		return s.shards[shard].Increment(ctx, batch.incrementKeys, batch.values)
+	})
+	return err
+}
+
+func (s *shardedBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	batches, err := s.split(queryKeys, incrementKeys, values)
+	if err != nil {
+		return nil, err
+	}
+	return s.call(ctx, queryKeys, batches, func(ctx context.Context, shard int, batch *shardBatch) error {
+		if len(batch.queryKeys) == 0 {
+			return s.shards[shard].Increment(ctx, batch.incrementKeys, batch.values)
+		}
+		results, err := s.shards[shard].(QueryIncrementBackend).QueryIncrement(ctx, batch.queryKeys, batch.incrementKeys, batch.values)
+		batch.results = results
+		return err
+	})
+}
+
+func (s *shardedBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	batches, err := s.split(sumKeys, keys, values)
+	if err != nil {
+		return false, 0, err
+	}
+	if len(batches) == 1 {
+		for shard := range batches {
+			applied, sum, err := s.shards[shard].(ConditionalIncrementBackend).IncrementIfBelow(ctx, sumKeys, delta, limit, keys, values)
+			if err != nil {
+				return false, 0, errors.Wrapf(err, "shard %v failed", s.names[shard])
+			}
+			return applied, sum, nil
+		}
+	}
+
+	return lockedIncrementIfBelow[int64](ctx, &s.conditionalLock, s, sumKeys, delta, limit, keys, values)
+}
+
+type hashTaggedBackend struct {
+	backend Backend
+	prefix  string
+}
+
+// NewHashTaggedBackend prefix every key with tag in braces, so that all the keys of a counter using it are on the same
+// shard of a sharded backend, or of a redis cluster. It also keep the keys of counters with different tags apart.
+// The capabilities of backend are kept by KeepCapabilities.
+func NewHashTaggedBackend(backend Backend, tag string) Backend {
+	return KeepCapabilities(backend, &hashTaggedBackend{
+		backend: backend,
+		prefix:  "{" + tag + "}",
+	})
+}
+
+func (h *hashTaggedBackend) tag(keys []string) []string {
+	tagged := make([]string, len(keys))
+	for i, key := range keys {
+		tagged[i] = h.prefix + key
+	}
+	return tagged
+}
+
+func (h *hashTaggedBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	return h.backend.Query(ctx, h.tag(keys))
+}
+
+func (h *hashTaggedBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	return h.backend.Increment(ctx, h.tag(keys), values)
+}
+
+func (h *hashTaggedBackend) QueryIncrement(ctx context.Context, queryKeys []string, incrementKeys []string, values []int64) ([]int64, error) {
+	return h.backend.(QueryIncrementBackend).QueryIncrement(ctx, h.tag(queryKeys), h.tag(incrementKeys), values)
+}
+
+func (h *hashTaggedBackend) IncrementIfBelow(ctx context.Context, sumKeys []string, delta int64, limit int64, keys []string, values []int64) (bool, int64, error) {
+	return h.backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, h.tag(sumKeys), delta, limit, h.tag(keys), values)
+}
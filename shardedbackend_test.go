@@ -0,0 +1,177 @@
+package rangecounter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestShards(names ...string) map[string]Backend {
+	shards := map[string]Backend{}
+	for _, name := range names {
+		shards[name] = NewInMemoryBackend()
+	}
+	return shards
+}
+
+// shardOf returns the name of the shard of key
+func shardOf(backend Backend, key string) string {
+	var sharded *shardedBackend
+	switch it := backend.(type) {
+	case *shardedBackend:
+		sharded = it
+	case struct{ Backend }:
+		sharded = it.Backend.(*shardedBackend)
+	}
+	return sharded.names[sharded.shardOf(key)]
+}
+
+func TestHashTag(t *testing.T) {
+	tests := []struct {
+		key string
+		tag string
+	}{
+		{"plain", "plain"},
+		{"{user:1}:0:1", "user:1"},
+		{"prefix{user:1}suffix", "user:1"},
+		{"{}:0:1", "{}:0:1"},
+		{"{unclosed:0", "{unclosed:0"},
+		{"{a}{b}", "a"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.tag, HashTag(test.key), test.key)
+	}
+}
+
+func TestShardedBackendDistribution(t *testing.T) {
+	ctx := context.Background()
+	shards := newTestShards("a", "b", "c", "d")
+	backend := NewShardedBackend(shards, ShardedBackendOptions{})
+
+	keys := make([]string, 10000)
+	values := make([]int64, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+		values[i] = int64(i)
+	}
+	assert.NoError(t, backend.Increment(ctx, keys, values))
+
+	results, err := backend.Query(ctx, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, values, results, "results are in the order of the keys")
+
+	perShard := map[string]int{}
+	for i, key := range keys {
+		name := shardOf(backend, key)
+		perShard[name]++
+
+		stored, err := shards[name].Query(ctx, []string{key})
+		assert.NoError(t, err)
+		assert.Equal(t, values[i], stored[0], "key %v is stored on its shard", key)
+	}
+	for name, count := range perShard {
+		assert.InDelta(t, 2500, count, 750, "shard %v", name)
+	}
+}
+
+func TestShardedBackendAddingShard(t *testing.T) {
+	before := NewShardedBackend(newTestShards("a", "b", "c", "d"), ShardedBackendOptions{})
+	after := NewShardedBackend(newTestShards("a", "b", "c", "d", "e"), ShardedBackendOptions{})
+
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprint("key", i)
+		if shardOf(before, key) != shardOf(after, key) {
+			moved++
+			assert.Equal(t, "e", shardOf(after, key), "keys only move to the new shard")
+		}
+	}
+	assert.InDelta(t, 2000, moved, 600)
+}
+
+func TestShardedBackendColocation(t *testing.T) {
+	ctx := context.Background()
+	calls := map[string]int{}
+	shards := map[string]Backend{}
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		shards[name] = NewFaultInjectingBackend(NewInMemoryBackend(), func(call FaultCall) Fault {
+			calls[name]++
+			return Fault{}
+		})
+	}
+	sharded := NewShardedBackend(shards, ShardedBackendOptions{})
+
+	counter := NewRangeTreeIntCounter(NewHashTaggedBackend(sharded, "user:1"), 8, 1)
+	for i := int64(0); i < 100; i++ {
+		assert.NoError(t, counter.Increment(ctx, i*7, 1))
+	}
+	sum, err := counter.QuerySum(ctx, 0, 1000)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, sum)
+	assert.Len(t, calls, 1, "every call go to the same shard")
+	assert.Equal(t, 101, calls[shardOf(sharded, "{user:1}")])
+
+	other, err := NewRangeTreeIntCounter(NewHashTaggedBackend(sharded, "user:2"), 8, 1).QuerySum(ctx, 0, 1000)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, other, "tags keep counters apart")
+}
+
+func TestShardedBackendIncrementIfBelow(t *testing.T) {
+	ctx := context.Background()
+	backend := NewShardedBackend(newTestShards("a", "b", "c"), ShardedBackendOptions{}).(ConditionalIncrementBackend)
+
+	crossShard := []string{}
+	for i := 0; len(crossShard) < 2; i++ {
+		key := fmt.Sprint("key", i)
+		if len(crossShard) == 0 || shardOf(backend, key) != shardOf(backend, crossShard[0]) {
+			crossShard = append(crossShard, key)
+		}
+	}
+	sameShard := []string{"{quota}:a", "{quota}:b"}
+
+	for _, keys := range [][]string{crossShard, sameShard} {
+		applied, sum, err := backend.IncrementIfBelow(ctx, keys, 2, 3, keys, []int64{1, 1})
+		assert.NoError(t, err)
+		assert.True(t, applied)
+		assert.EqualValues(t, 0, sum)
+
+		applied, sum, err = backend.IncrementIfBelow(ctx, keys, 2, 3, keys, []int64{1, 1})
+		assert.NoError(t, err)
+		assert.False(t, applied)
+		assert.EqualValues(t, 2, sum)
+	}
+}
+
+func TestShardedBackendShardError(t *testing.T) {
+	ctx := context.Background()
+	shards := newTestShards("a", "b")
+	shards["b"] = NewFaultInjectingBackend(shards["b"], func(call FaultCall) Fault {
+		return Fault{Err: ErrInjectedFault}
+	})
+	backend := NewShardedBackend(shards, ShardedBackendOptions{})
+
+	keys := []string{}
+	for i := 0; i < 10; i++ {
+		keys = append(keys, fmt.Sprint("key", i))
+	}
+	_, err := backend.Query(ctx, keys)
+	assert.ErrorIs(t, err, ErrInjectedFault)
+	assert.Contains(t, err.Error(), "shard b")
+
+	_, ok := backend.(ConditionalIncrementBackend)
+	assert.False(t, ok, "not every shard is conditional")
+}
+
+func TestShardedBackendMismatchedValues(t *testing.T) {
+	ctx := context.Background()
+	backend := NewShardedBackend(newTestShards("a", "b"), ShardedBackendOptions{})
+
+	assert.Error(t, backend.Increment(ctx, []string{"a", "b"}, []int64{1}))
+	_, err := backend.(QueryIncrementBackend).QueryIncrement(ctx, []string{"a"}, []string{"a"}, nil)
+	assert.Error(t, err)
+	_, _, err = backend.(ConditionalIncrementBackend).IncrementIfBelow(ctx, []string{"a"}, 1, 10, []string{"a", "b"}, []int64{1, 2, 3})
+	assert.Error(t, err)
+}
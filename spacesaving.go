@@ -0,0 +1,158 @@
+package rangecounter
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+type spaceSavingCounter struct {
+	count int64
+	error int64
+}
+
+// spaceSaving is a Space-Saving heavy hitter summary, which track at most capacity items. An item's count may be
+// overestimated by up to its error, and an untracked item has a count of at most minCount.
+// Summaries merge by adding counters, charging the other summary's minCount to items it does not track.
+type spaceSaving struct {
+	capacity int
+	counters map[string]spaceSavingCounter
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		counters: map[string]spaceSavingCounter{},
+	}
+}
+
+// minCount is the most an untracked item could have been counted
+func (s *spaceSaving) minCount() int64 {
+	if len(s.counters) < s.capacity {
+		return 0
+	}
+	smallest := int64(-1)
+	for _, counter := range s.counters {
+		if smallest == -1 || counter.count < smallest {
+			smallest = counter.count
+		}
+	}
+	return smallest
+}
+
+func (s *spaceSaving) mergeSummary(other *spaceSaving) {
+	selfMin := s.minCount()
+	otherMin := other.minCount()
+
+	for item, counter := range s.counters {
+		if _, ok := other.counters[item]; !ok {
+			counter.count += otherMin
+			counter.error += otherMin
+			s.counters[item] = counter
+		}
+	}
+	for item, otherCounter := range other.counters {
+		counter, ok := s.counters[item]
+		if !ok {
+			counter = spaceSavingCounter{count: selfMin, error: selfMin}
+		}
+		counter.count += otherCounter.count
+		counter.error += otherCounter.error
+		s.counters[item] = counter
+	}
+
+	sorted := s.sorted()
+	for _, item := range sorted[min(len(sorted), s.capacity):] {
+		delete(s.counters, item.Item)
+	}
+}
+
+// sorted returns the tracked items by descending count
+func (s *spaceSaving) sorted() []TopKItem {
+	items := make([]TopKItem, 0, len(s.counters))
+	for item, counter := range s.counters {
+		items = append(items, TopKItem{Item: item, Count: counter.count, Error: counter.error})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Item < items[j].Item
+	})
+	return items
+}
+
+// marshal encode the summary as its capacity, then each item, count and error
+func (s *spaceSaving) marshal() []byte {
+	encoded := binary.AppendUvarint(nil, uint64(s.capacity))
+	encoded = binary.AppendUvarint(encoded, uint64(len(s.counters)))
+	for _, item := range s.sorted() {
+		encoded = binary.AppendUvarint(encoded, uint64(len(item.Item)))
+		encoded = append(encoded, item.Item...)
+		encoded = binary.AppendVarint(encoded, item.Count)
+		encoded = binary.AppendVarint(encoded, item.Error)
+	}
+	return encoded
+}
+
+func unmarshalSpaceSaving(encoded []byte) (*spaceSaving, error) {
+	capacity, n := binary.Uvarint(encoded)
+	if n <= 0 {
+		return nil, errors.New("invalid space saving capacity")
+	}
+	encoded = encoded[n:]
+	length, n := binary.Uvarint(encoded)
+	if n <= 0 {
+		return nil, errors.New("invalid space saving length")
+	}
+	encoded = encoded[n:]
+
+	summary := newSpaceSaving(int(capacity))
+	for i := uint64(0); i < length; i++ {
+		itemLength, n := binary.Uvarint(encoded)
+		if n <= 0 || uint64(len(encoded)-n) < itemLength {
+			return nil, errors.New("invalid space saving item")
+		}
+		item := string(encoded[n : n+int(itemLength)])
+		encoded = encoded[n+int(itemLength):]
+
+		count, n := binary.Varint(encoded)
+		if n <= 0 {
+			return nil, errors.New("invalid space saving count")
+		}
+		encoded = encoded[n:]
+		countError, n := binary.Varint(encoded)
+		if n <= 0 {
+			return nil, errors.New("invalid space saving error")
+		}
+		encoded = encoded[n:]
+
+		summary.counters[item] = spaceSavingCounter{count: count, error: countError}
+	}
+	if len(encoded) != 0 {
+		return nil, errors.Errorf("%v trailing bytes after space saving", len(encoded))
+	}
+	return summary, nil
+}
+
+// SpaceSavingMerge is the BytesMergeFunc for the summaries stored by TopKRangeCounter.
+func SpaceSavingMerge(stored, value []byte) ([]byte, error) {
+	summary, err := unmarshalSpaceSaving(value)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return summary.marshal(), nil
+	}
+
+	storedSummary, err := unmarshalSpaceSaving(stored)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode stored space saving")
+	}
+	if storedSummary.capacity != summary.capacity {
+		return nil, errors.Errorf("unable to merge space saving of capacity %v into capacity %v", summary.capacity, storedSummary.capacity)
+	}
+	storedSummary.mergeSummary(summary)
+	return storedSummary.marshal(), nil
+}
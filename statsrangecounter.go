@@ -0,0 +1,101 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Stats summarize the values recorded within a range of time. Variance is the population variance.
+// Sum is exact as long as it stay below 2^53.
+type Stats struct {
+	Count    int64
+	Sum      float64
+	Mean     float64
+	Variance float64
+}
+
+func (s Stats) StdDev() float64 {
+	return math.Sqrt(s.Variance)
+}
+
+// StatsRangeCounter record values, such as latency, and query their count, sum, mean and variance over a range of time.
+type StatsRangeCounter interface {
+	Record(ctx context.Context, at time.Time, value int64) error
+	QueryStats(ctx context.Context, at time.Time, bucketCount int) (Stats, error)
+}
+
+type statsRangeCounter struct {
+	layout  DateLayout
+	backend GenericBackend[float64]
+}
+
+// NewStatsRangeCounter store the count, sum and sum of squares per key of the layout, written in a single
+// Increment. They are float64 as the sum of squares of an int64 would overflow once a bucket has recorded a few
+// values in the billions. The variance is the mean of the squares minus the square of the mean, so its relative error
+// is about 1e-16*(mean/stddev)^2, and it is meaningless once the standard deviation is below about 1e-8 of the mean.
+func NewStatsRangeCounter(layout DateLayout, backend GenericBackend[float64]) StatsRangeCounter {
+	return &statsRangeCounter{
+		layout:  layout,
+		backend: backend,
+	}
+}
+
+func (s *statsRangeCounter) Record(ctx context.Context, at time.Time, value int64) error {
+	keys, err := s.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+
+	incrementKeys := make([]string, 0, len(keys)*3)
+	increments := make([]float64, 0, len(keys)*3)
+	for _, key := range keys {
+		incrementKeys = append(incrementKeys, "count"+key, "sum"+key, "sumsq"+key)
+		increments = append(increments, 1, float64(value), float64(value)*float64(value))
+	}
+
+	err = s.backend.Increment(ctx, incrementKeys, increments)
+	if err != nil {
+		return errors.Wrap(err, "unable to increment counters")
+	}
+	return nil
+}
+
+func (s *statsRangeCounter) QueryStats(ctx context.Context, at time.Time, bucketCount int) (Stats, error) {
+	keys, err := s.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	queryKeys := make([]string, 0, len(keys)*3)
+	for _, key := range keys {
+		queryKeys = append(queryKeys, "count"+key, "sum"+key, "sumsq"+key)
+	}
+
+	results, err := queryBackend(ctx, s.backend, queryKeys)
+	if err != nil {
+		return Stats{}, errors.Wrap(err, "unable to query counters")
+	}
+
+	count, sumOfSquares := 0.0, 0.0
+	stats := Stats{}
+	for i := 0; i < len(results); i += 3 {
+		count += results[i]
+		stats.Sum += results[i+1]
+		sumOfSquares += results[i+2]
+	}
+	stats.Count = int64(count)
+	if stats.Count == 0 {
+		return stats, nil
+	}
+
+	stats.Mean = stats.Sum / count
+	stats.Variance = math.Max(sumOfSquares/count-stats.Mean*stats.Mean, 0)
+	return stats, nil
+}
+
+func (s *statsRangeCounter) String() string {
+	return "statsRangeCounter(" + s.layout.String() + ")"
+}
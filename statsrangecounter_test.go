@@ -0,0 +1,83 @@
+package rangecounter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatsRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type inputReq struct {
+		dateOffset int
+		value      int64
+	}
+	type queryReq struct {
+		dateOffset  int
+		bucketCount int
+		expected    Stats
+	}
+	inputs := []inputReq{
+		{0, 2},
+		{0, 4},
+		{1, 4},
+		{1, 4},
+		{3, 5},
+		{3, 5},
+		{3, 7},
+		{3, 9},
+	}
+	queries := []queryReq{
+		{0, 1, Stats{Count: 2, Sum: 6, Mean: 3, Variance: 1}},
+		{1, 2, Stats{Count: 4, Sum: 14, Mean: 3.5, Variance: 0.75}},
+		{2, 1, Stats{}},
+		{3, 4, Stats{Count: 8, Sum: 40, Mean: 5, Variance: 4}},
+		{10, 5, Stats{}},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket":  NewBucketDateLayout(Hour),
+		"tree-8":  NewRangeTreeDateLayout(Hour, 8, 1),
+		"tree-16": NewRangeTreeDateLayout(Hour, 16, 3),
+	}
+	for layoutName, layout := range layoutToTest {
+		t.Run("layout "+layoutName, func(t *testing.T) {
+			ctx := context.Background()
+			backend := NewGenericBenchmarkBackend[float64]()
+			counter := NewStatsRangeCounter(layout, backend)
+
+			for _, inp := range inputs {
+				err := counter.Record(ctx, Hour.incrementDateForce(inp.dateOffset, baseDate), inp.value)
+				assert.NoError(t, err)
+			}
+			assert.EqualValues(t, len(inputs), backend.incrementCall, "a single increment per record")
+
+			for _, q := range queries {
+				stats, err := counter.QueryStats(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount)
+				assert.NoError(t, err)
+				assert.Equal(t, q.expected.Count, stats.Count)
+				assert.Equal(t, q.expected.Sum, stats.Sum)
+				assert.InDelta(t, q.expected.Mean, stats.Mean, 1e-9)
+				assert.InDelta(t, q.expected.Variance, stats.Variance, 1e-9)
+			}
+		})
+	}
+}
+
+func TestStatsRangeCounterLargeValues(t *testing.T) {
+	ctx := context.Background()
+	at := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	counter := NewStatsRangeCounter(NewRangeTreeDateLayout(Hour, 8, 1), NewGenericInMemoryBackend[float64]())
+
+	// the square of each overflow an int64
+	for _, value := range []int64{4e9, 6e9, 4e9, 6e9} {
+		assert.NoError(t, counter.Record(ctx, at, value))
+	}
+	stats, err := counter.QueryStats(ctx, at, 1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5e9, stats.Mean)
+	assert.InEpsilon(t, 1e18, stats.Variance, 1e-9)
+}
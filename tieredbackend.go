@@ -0,0 +1,231 @@
+package rangecounter
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TieredBackend is a Backend over a hot and a cold tier, which can move the keys that aged out of the hot tier.
+type TieredBackend interface {
+	Backend
+	// Migrate move the keys written to the hot tier by this backend, which are now older than HotFor, to the cold
+	// tier. It returns the number of keys moved. A key is added to the cold tier before being cleared from the hot
+	// one, so if clearing fails it is counted twice until the clear is retried by the next Migrate.
+	Migrate(ctx context.Context) (int, error)
+}
+
+// TieredBackendOptions configure NewTieredBackend.
+type TieredBackendOptions struct {
+	// KeySpan returns the time covered by a key, usually the KeySpan of the layout or counter using the backend.
+	// Keys it does not know stay in the hot tier.
+	KeySpan func(key string) (time.Time, time.Time, bool)
+	// HotFor is how long after the end of its span a key belong to the hot tier
+	HotFor time.Duration
+
+	now func() time.Time
+}
+
+type tieredBackend struct {
+	hot     Backend
+	cold    Backend
+	options TieredBackendOptions
+
+	// lock is held shared by queries and increments, and exclusively by migrations, so that a query never see a
+	// key half moved
+	lock sync.RWMutex
+	// hotKeys is the end of the span of each key written to the hot tier which is due to migrate
+	hotKeys     map[string]time.Time
+	hotKeysLock sync.Mutex
+	// pendingClears is the value to add to each key already moved to the cold tier whose clear from the hot tier
+	// failed, guarded by lock
+	pendingClears map[string]int64
+}
+
+// NewTieredBackend write the keys covering recent time to hot, and older ones to cold. A key is queried from both
+// tiers once it is old enough to have been migrated, and only from hot before. Migrations are only atomic within this
+// process, and only know the keys written since it started, so a key left in hot by a restart is still queried
+// correctly, from both tiers, but stays there.
+func NewTieredBackend(hot, cold Backend, options TieredBackendOptions) TieredBackend {
+	if options.KeySpan == nil {
+		panic("KeySpan must be set")
+	}
+	if options.now == nil {
+		options.now = time.Now
+	}
+	return &tieredBackend{
+		hot:           hot,
+		cold:          cold,
+		options:       options,
+		hotKeys:       map[string]time.Time{},
+		pendingClears: map[string]int64{},
+	}
+}
+
+// isCold returns whether key is old enough to be in the cold tier, and the end of its span
+func (t *tieredBackend) isCold(key string, now time.Time) (bool, time.Time) {
+	_, end, ok := t.options.KeySpan(key)
+	if !ok {
+		return false, time.Time{}
+	}
+	return !end.Add(t.options.HotFor).After(now), end
+}
+
+func (t *tieredBackend) Query(ctx context.Context, keys []string) ([]int64, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	now := t.options.now()
+	coldKeys := []string{}
+	coldPositions := []int{}
+	for i, key := range keys {
+		if cold, _ := t.isCold(key, now); cold {
+			coldKeys = append(coldKeys, key)
+			coldPositions = append(coldPositions, i)
+		}
+	}
+
+	results, err := queryBackend(ctx, t.hot, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query hot tier")
+	}
+	if len(coldKeys) == 0 {
+		return results, nil
+	}
+	coldResults, err := queryBackend(ctx, t.cold, coldKeys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query cold tier")
+	}
+	for i, position := range coldPositions {
+		results[position] += coldResults[i]
+	}
+	return results, nil
+}
+
+func (t *tieredBackend) Increment(ctx context.Context, keys []string, values []int64) error {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	now := t.options.now()
+	hotKeys, hotValues := []string{}, []int64{}
+	coldKeys, coldValues := []string{}, []int64{}
+	spanEnds := map[string]time.Time{}
+	for i, key := range keys {
+		cold, end := t.isCold(key, now)
+		if cold {
+			coldKeys = append(coldKeys, key)
+			coldValues = append(coldValues, values[i])
+			continue
+		}
+		hotKeys = append(hotKeys, key)
+		hotValues = append(hotValues, values[i])
+		if !end.IsZero() {
+			spanEnds[key] = end
+		}
+	}
+
+	if len(hotKeys) > 0 {
+		t.hotKeysLock.Lock()
+		for key, end := range spanEnds {
+			t.hotKeys[key] = end
+		}
+		t.hotKeysLock.Unlock()
+
+		err := t.hot.Increment(ctx, hotKeys, hotValues)
+		if err != nil {
+			return errors.Wrap(err, "unable to increment hot tier")
+		}
+	}
+	if len(coldKeys) > 0 {
+		err := t.cold.Increment(ctx, coldKeys, coldValues)
+		if err != nil {
+			return errors.Wrap(err, "unable to increment cold tier")
+		}
+	}
+	return nil
+}
+
+func (t *tieredBackend) Migrate(ctx context.Context) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	err := t.clearHot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := t.options.now()
+	keys := []string{}
+	t.hotKeysLock.Lock()
+	for key, end := range t.hotKeys {
+		if !end.Add(t.options.HotFor).After(now) {
+			keys = append(keys, key)
+		}
+	}
+	t.hotKeysLock.Unlock()
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	sort.Strings(keys)
+
+	values, err := queryBackend(ctx, t.hot, keys)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to query hot tier")
+	}
+	moveKeys, moveValues := []string{}, []int64{}
+	for i, key := range keys {
+		if values[i] != 0 {
+			moveKeys = append(moveKeys, key)
+			moveValues = append(moveValues, values[i])
+		}
+	}
+
+	if len(moveKeys) > 0 {
+		err = t.cold.Increment(ctx, moveKeys, moveValues)
+		if err != nil {
+			return 0, errors.Wrap(err, "unable to increment cold tier")
+		}
+	}
+
+	// the keys are in the cold tier now, moving them again would count them once more
+	t.hotKeysLock.Lock()
+	for _, key := range keys {
+		delete(t.hotKeys, key)
+	}
+	t.hotKeysLock.Unlock()
+	for i, key := range moveKeys {
+		t.pendingClears[key] -= moveValues[i]
+	}
+	err = t.clearHot(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(moveKeys), nil
+}
+
+// clearHot negate in the hot tier the keys moved to the cold tier. The backend cannot delete, so the hot key is left
+// at zero.
+func (t *tieredBackend) clearHot(ctx context.Context) error {
+	if len(t.pendingClears) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(t.pendingClears))
+	for key := range t.pendingClears {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		values[i] = t.pendingClears[key]
+	}
+
+	err := t.hot.Increment(ctx, keys, values)
+	if err != nil {
+		return errors.Wrap(err, "unable to clear hot tier, the keys are counted twice until the next migration")
+	}
+	t.pendingClears = map[string]int64{}
+	return nil
+}
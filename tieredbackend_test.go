@@ -0,0 +1,149 @@
+package rangecounter
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredBackendMigration(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	hot, cold := NewInMemoryBackend(), NewInMemoryBackend()
+	layout := NewBucketDateLayout(Minute)
+	backend := NewTieredBackend(hot, cold, TieredBackendOptions{
+		KeySpan: layout.KeySpan,
+		HotFor:  10 * time.Minute,
+		now: func() time.Time {
+			return now
+		},
+	})
+	counter := NewBasicDateCounter(Minute, backend)
+
+	recent, old := now.Add(-time.Minute), now.Add(-time.Hour)
+	recentKeys, _ := layout.incrementKeys(recent)
+	oldKeys, _ := layout.incrementKeys(old)
+	recentKey, oldKey := recentKeys[0], oldKeys[0]
+	assert.NoError(t, counter.Increment(ctx, recent, 2))
+	assert.NoError(t, counter.Increment(ctx, old, 3), "a late write go straight to the cold tier")
+	assert.NoError(t, backend.Increment(ctx, []string{"unknown"}, []int64{4}))
+
+	hotValues, _ := hot.Query(ctx, []string{recentKey, oldKey, "unknown"})
+	assert.Equal(t, []int64{2, 0, 4}, hotValues)
+	coldValues, _ := cold.Query(ctx, []string{recentKey, oldKey, "unknown"})
+	assert.Equal(t, []int64{0, 3, 0}, coldValues)
+
+	sum, err := counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, sum)
+
+	moved, err := backend.Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved, "still hot")
+
+	now = now.Add(30 * time.Minute)
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, sum, "aged but not migrated")
+
+	moved, err = backend.Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	hotValues, _ = hot.Query(ctx, []string{recentKey, "unknown"})
+	assert.Equal(t, []int64{0, 4}, hotValues)
+	coldValues, _ = cold.Query(ctx, []string{recentKey, oldKey})
+	assert.Equal(t, []int64{2, 3}, coldValues)
+
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, sum)
+
+	moved, err = backend.Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
+
+func TestTieredBackendTreeCounter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	hot, cold := NewInMemoryBackend(), NewInMemoryBackend()
+	backend := NewTieredBackend(hot, cold, TieredBackendOptions{
+		KeySpan: NewRangeTreeDateLayout(Minute, 8, 2).KeySpan,
+		HotFor:  time.Hour,
+		now: func() time.Time {
+			return now
+		},
+	})
+	counter := NewIntBackedDateRange(NewRangeTreeIntCounter(backend, 8, 2), Minute)
+	reference := NewIntBackedDateRange(NewRangeTreeIntCounter(NewInMemoryBackend(), 8, 2), Minute)
+
+	random := rand.New(rand.NewSource(1))
+	for step := 0; step < 500; step++ {
+		now = now.Add(time.Duration(random.Int63n(int64(time.Minute))))
+		at := now.Add(-time.Duration(random.Int63n(int64(3 * time.Hour))))
+		assert.NoError(t, counter.Increment(ctx, at, 1))
+		assert.NoError(t, reference.Increment(ctx, at, 1))
+
+		if step%50 == 0 {
+			_, err := backend.Migrate(ctx)
+			assert.NoError(t, err)
+		}
+		window := random.Intn(600) + 1
+		expected, err := reference.QuerySum(ctx, now, window)
+		assert.NoError(t, err)
+		sum, err := counter.QuerySum(ctx, now, window)
+		assert.NoError(t, err)
+		if !assert.Equal(t, expected, sum, "step %v window %v", step, window) {
+			return
+		}
+	}
+
+	moved, err := backend.Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, moved, 0)
+}
+
+func TestTieredBackendFailedClear(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	failClear := false
+	store, cold := NewInMemoryBackend(), NewInMemoryBackend()
+	hot := NewFaultInjectingBackend(store, func(call FaultCall) Fault {
+		if failClear && call.Op == IncrementOperation {
+			return Fault{Err: ErrInjectedFault}
+		}
+		return Fault{}
+	})
+	layout := NewBucketDateLayout(Minute)
+	backend := NewTieredBackend(hot, cold, TieredBackendOptions{
+		KeySpan: layout.KeySpan,
+		HotFor:  10 * time.Minute,
+		now: func() time.Time {
+			return now
+		},
+	})
+	counter := NewBasicDateCounter(Minute, backend)
+	assert.NoError(t, counter.Increment(ctx, now, 2))
+
+	now = now.Add(time.Hour)
+	failClear = true
+	_, err := backend.Migrate(ctx)
+	assert.ErrorIs(t, err, ErrInjectedFault)
+	sum, err := counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 4, sum, "counted twice until cleared")
+
+	_, err = backend.Migrate(ctx)
+	assert.ErrorIs(t, err, ErrInjectedFault, "the clear is retried")
+
+	failClear = false
+	moved, err := backend.Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved, "the key was already moved")
+	sum, err = counter.QuerySum(ctx, now, 120)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, sum)
+}
@@ -0,0 +1,99 @@
+package rangecounter
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TopKItem is an item's estimated count. The actual count is between Count-Error and Count.
+type TopKItem struct {
+	Item  string
+	Count int64
+	Error int64
+}
+
+// TopKRangeCounter estimate the most frequent items within a range of time.
+type TopKRangeCounter interface {
+	Increment(ctx context.Context, at time.Time, item string, by int64) error
+	QueryTopK(ctx context.Context, at time.Time, bucketCount int, k int) ([]TopKItem, error)
+}
+
+type spaceSavingRangeCounter struct {
+	layout   DateLayout
+	backend  BytesBackend
+	capacity int
+}
+
+// NewTopKRangeCounter store a Space-Saving summary of up to capacity items per key of the layout. The backend must
+// merge the summary with SpaceSavingMerge. The capacity should be a few times larger than the k queried, as the
+// error grows as summaries are merged.
+func NewTopKRangeCounter(layout DateLayout, backend BytesBackend, capacity int) TopKRangeCounter {
+	if capacity <= 0 {
+		panic("capacity must be positive")
+	}
+	return &spaceSavingRangeCounter{
+		layout:   layout,
+		backend:  backend,
+		capacity: capacity,
+	}
+}
+
+func (s *spaceSavingRangeCounter) Increment(ctx context.Context, at time.Time, item string, by int64) error {
+	if by <= 0 {
+		return errors.Errorf("top k counter can only be incremented by a positive value, got %v", by)
+	}
+	keys, err := s.layout.incrementKeys(at)
+	if err != nil {
+		return err
+	}
+
+	summary := newSpaceSaving(s.capacity)
+	summary.counters[item] = spaceSavingCounter{count: by}
+	update := summary.marshal()
+	values := make([][]byte, len(keys))
+	for i := range values {
+		values[i] = update
+	}
+
+	err = s.backend.MergeBytes(ctx, keys, values)
+	if err != nil {
+		return errors.Wrap(err, "unable to merge summaries")
+	}
+	return nil
+}
+
+func (s *spaceSavingRangeCounter) QueryTopK(ctx context.Context, at time.Time, bucketCount int, k int) ([]TopKItem, error) {
+	if k < 0 {
+		return nil, errors.Errorf("k must not be negative, got %v", k)
+	}
+	keys, err := s.layout.queryKeys(at, bucketCount)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := s.backend.QueryBytes(ctx, keys)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to query summaries")
+	}
+
+	summary := newSpaceSaving(s.capacity)
+	for i, result := range results {
+		if result == nil {
+			continue
+		}
+		other, err := unmarshalSpaceSaving(result)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decode summary of key %v", keys[i])
+		}
+		summary.mergeSummary(other)
+	}
+
+	items := summary.sorted()
+	return items[:min(len(items), k)], nil
+}
+
+func (s *spaceSavingRangeCounter) String() string {
+	return "spaceSavingRangeCounter(" + s.layout.String() + ")"
+}
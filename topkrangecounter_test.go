@@ -0,0 +1,74 @@
+package rangecounter
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopKRangeCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+
+	type queryReq struct {
+		dateOffset  int
+		bucketCount int
+		k           int
+		expected    []string
+	}
+	queries := []queryReq{
+		{9, 10, 3, []string{"endpoint-0", "endpoint-1", "endpoint-2"}},
+		{0, 1, 2, []string{"endpoint-0", "endpoint-1"}},
+		{5, 3, 1, []string{"endpoint-0"}},
+		{30, 5, 3, []string{}},
+	}
+
+	layoutToTest := map[string]DateLayout{
+		"bucket":  NewBucketDateLayout(Hour),
+		"tree-8":  NewRangeTreeDateLayout(Hour, 8, 1),
+		"tree-16": NewRangeTreeDateLayout(Hour, 16, 3),
+	}
+	for layoutName, layout := range layoutToTest {
+		t.Run("layout "+layoutName, func(t *testing.T) {
+			ctx := context.Background()
+			counter := NewTopKRangeCounter(layout, NewInMemoryBytesBackend(SpaceSavingMerge), 16)
+
+			// endpoint-j is called 3*1000/(j+1)^2 times per bucket
+			actual := map[string]int64{}
+			for offset := 0; offset < 10; offset++ {
+				for j := 0; j < 20; j++ {
+					item := fmt.Sprint("endpoint-", j)
+					for round := 0; round < 3; round++ {
+						err := counter.Increment(ctx, Hour.incrementDateForce(offset, baseDate), item, int64(1000/((j+1)*(j+1))))
+						assert.NoError(t, err)
+					}
+					if offset == 0 {
+						actual[item] = int64(3 * (1000 / ((j + 1) * (j + 1))))
+					}
+				}
+			}
+
+			for _, q := range queries {
+				items, err := counter.QueryTopK(ctx, Hour.incrementDateForce(q.dateOffset, baseDate), q.bucketCount, q.k)
+				assert.NoError(t, err)
+				names := []string{}
+				for _, item := range items {
+					names = append(names, item.Item)
+					actualCount := actual[item.Item] * int64(q.bucketCount)
+					assert.LessOrEqual(t, item.Count-item.Error, actualCount)
+					assert.GreaterOrEqual(t, item.Count, actualCount)
+				}
+				assert.Equal(t, q.expected, names)
+			}
+		})
+	}
+
+	empty := NewTopKRangeCounter(NewBucketDateLayout(Hour), NewInMemoryBytesBackend(SpaceSavingMerge), 8)
+	items, err := empty.QueryTopK(context.Background(), baseDate, 1, 1)
+	assert.NoError(t, err)
+	assert.Empty(t, items, "nothing was added")
+	_, err = empty.QueryTopK(context.Background(), baseDate, 1, -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+package rangecounter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DateRangeOperation is a single Increment or QuerySum call on a DateRangeCounter, as recorded in a trace.
+type DateRangeOperation struct {
+	Op          string    `json:"op"`
+	At          time.Time `json:"at"`
+	By          int64     `json:"by,omitempty"`
+	BucketCount int       `json:"bucketCount,omitempty"`
+}
+
+// BackendOperation is a single Increment or Query call on a Backend, as recorded in a trace.
+type BackendOperation struct {
+	Op     string   `json:"op"`
+	Keys   []string `json:"keys"`
+	Values []int64  `json:"values,omitempty"`
+}
+
+// ReadDateRangeTrace read a trace of one DateRangeOperation in JSON per line.
+func ReadDateRangeTrace(reader io.Reader) ([]DateRangeOperation, error) {
+	trace := []DateRangeOperation{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		operation := DateRangeOperation{}
+		err := json.Unmarshal(scanner.Bytes(), &operation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid operation on line %v", line)
+		}
+		if operation.Op != IncrementOperation && operation.Op != QueryOperation {
+			return nil, errors.Errorf("unknown operation %v on line %v", operation.Op, line)
+		}
+		trace = append(trace, operation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read trace")
+	}
+	return trace, nil
+}
+
+// ReadBackendTrace read a trace of one BackendOperation in JSON per line.
+func ReadBackendTrace(reader io.Reader) ([]BackendOperation, error) {
+	trace := []BackendOperation{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		operation := BackendOperation{}
+		err := json.Unmarshal(scanner.Bytes(), &operation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid operation on line %v", line)
+		}
+		switch {
+		case operation.Op == QueryOperation:
+		case operation.Op == IncrementOperation && len(operation.Keys) == len(operation.Values):
+		case operation.Op == IncrementOperation:
+			return nil, errors.Errorf("%v keys incremented by %v values on line %v", len(operation.Keys), len(operation.Values), line)
+		default:
+			return nil, errors.Errorf("unknown operation %v on line %v", operation.Op, line)
+		}
+		trace = append(trace, operation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read trace")
+	}
+	return trace, nil
+}
+
+// IntRangeTrace convert a date trace to the indexes of drange, as an int backed date range counter would.
+func IntRangeTrace(trace []DateRangeOperation, drange DateRange) []IntRangeOperation {
+	converted := make([]IntRangeOperation, 0, len(trace))
+	for _, operation := range trace {
+		index := drange.toIndex(operation.At)
+		if operation.Op == IncrementOperation {
+			converted = append(converted, IntRangeOperation{Op: IncrementOperation, At: index, By: operation.By})
+			continue
+		}
+		converted = append(converted, IntRangeOperation{Op: QueryOperation, From: index - int64(operation.BucketCount) + 1, To: index})
+	}
+	return converted
+}
+
+// DateRangeTrace convert an int trace to dates of drange, the reverse of IntRangeTrace.
+func DateRangeTrace(trace []IntRangeOperation, drange DateRange) []DateRangeOperation {
+	duration := drange.getDuration()
+	converted := make([]DateRangeOperation, 0, len(trace))
+	for _, operation := range trace {
+		if operation.Op == IncrementOperation {
+			converted = append(converted, DateRangeOperation{Op: IncrementOperation, At: time.Unix(0, operation.At*int64(duration)), By: operation.By})
+			continue
+		}
+		converted = append(converted, DateRangeOperation{
+			Op:          QueryOperation,
+			At:          time.Unix(0, operation.To*int64(duration)),
+			BucketCount: int(operation.To - operation.From + 1),
+		})
+	}
+	return converted
+}
+
+// traceWriter write one operation in JSON per line, safe for concurrent use. The errors writing it are given to
+// onError, if not nil, so that the call recorded still returns its own result.
+type traceWriter struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+	onError func(err error)
+}
+
+func newTraceWriter(writer io.Writer, onError func(err error)) *traceWriter {
+	return &traceWriter{
+		encoder: json.NewEncoder(writer),
+		onError: onError,
+	}
+}
+
+func (t *traceWriter) write(operation interface{}) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	err := t.encoder.Encode(operation)
+	if err != nil && t.onError != nil {
+		t.onError(errors.Wrap(err, "unable to record trace"))
+	}
+}
+
+type intRangeCounterRecorder struct {
+	counter IntRangeCounter
+	writer  *traceWriter
+}
+
+// NewIntRangeCounterRecorder record each successful call to counter in writer, to be read by ReadIntRangeTrace.
+// A call which could not be recorded still returns its own result, and the error recording it is given to onError,
+// if not nil.
+func NewIntRangeCounterRecorder(counter IntRangeCounter, writer io.Writer, onError func(err error)) IntRangeCounter {
+	return &intRangeCounterRecorder{
+		counter: counter,
+		writer:  newTraceWriter(writer, onError),
+	}
+}
+
+func (i *intRangeCounterRecorder) QuerySum(ctx context.Context, from, to int64) (int64, error) {
+	sum, err := i.counter.QuerySum(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	i.writer.write(IntRangeOperation{Op: QueryOperation, From: from, To: to})
+	return sum, nil
+}
+
+func (i *intRangeCounterRecorder) Increment(ctx context.Context, at int64, by int64) error {
+	err := i.counter.Increment(ctx, at, by)
+	if err != nil {
+		return err
+	}
+	i.writer.write(IntRangeOperation{Op: IncrementOperation, At: at, By: by})
+	return nil
+}
+
+type dateRangeCounterRecorder struct {
+	counter DateRangeCounter
+	writer  *traceWriter
+}
+
+// NewDateRangeCounterRecorder record each successful call to counter in writer, to be read by ReadDateRangeTrace.
+// Errors recording are given to onError, as with NewIntRangeCounterRecorder.
+func NewDateRangeCounterRecorder(counter DateRangeCounter, writer io.Writer, onError func(err error)) DateRangeCounter {
+	return &dateRangeCounterRecorder{
+		counter: counter,
+		writer:  newTraceWriter(writer, onError),
+	}
+}
+
+func (d *dateRangeCounterRecorder) QuerySum(ctx context.Context, at time.Time, bucketCount int) (int64, error) {
+	sum, err := d.counter.QuerySum(ctx, at, bucketCount)
+	if err != nil {
+		return 0, err
+	}
+	d.writer.write(DateRangeOperation{Op: QueryOperation, At: at, BucketCount: bucketCount})
+	return sum, nil
+}
+
+func (d *dateRangeCounterRecorder) Increment(ctx context.Context, at time.Time, by int64) error {
+	err := d.counter.Increment(ctx, at, by)
+	if err != nil {
+		return err
+	}
+	d.writer.write(DateRangeOperation{Op: IncrementOperation, At: at, By: by})
+	return nil
+}
+
+type backendRecorder struct {
+	backend Backend
+	writer  *traceWriter
+}
+
+// NewBackendRecorder record each successful call to backend in writer, to be read by ReadBackendTrace. It is a plain
+// Backend, whatever backend implement, so that every call go through it. Errors recording are given to onError, as
+// with NewIntRangeCounterRecorder.
+func NewBackendRecorder(backend Backend, writer io.Writer, onError func(err error)) Backend {
+	return &backendRecorder{
+		backend: backend,
+		writer:  newTraceWriter(writer, onError),
+	}
+}
+
+func (b *backendRecorder) Query(ctx context.Context, keys []string) ([]int64, error) {
+	results, err := b.backend.Query(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	b.writer.write(BackendOperation{Op: QueryOperation, Keys: keys})
+	return results, nil
+}
+
+func (b *backendRecorder) Increment(ctx context.Context, keys []string, values []int64) error {
+	err := b.backend.Increment(ctx, keys, values)
+	if err != nil {
+		return err
+	}
+	b.writer.write(BackendOperation{Op: IncrementOperation, Keys: keys, Values: values})
+	return nil
+}
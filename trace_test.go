@@ -0,0 +1,138 @@
+package rangecounter
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntRangeCounterRecorderReplay(t *testing.T) {
+	ctx := context.Background()
+	buffer := &bytes.Buffer{}
+	counter := NewIntRangeCounterRecorder(NewBasicIntRangeCounter(NewInMemoryBackend()), buffer, nil)
+
+	assert.NoError(t, counter.Increment(ctx, 3, 2))
+	assert.NoError(t, counter.Increment(ctx, 5, 1))
+	sum, err := counter.QuerySum(ctx, 0, 4)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, sum)
+
+	trace, err := ReadIntRangeTrace(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, []IntRangeOperation{
+		{Op: IncrementOperation, At: 3, By: 2},
+		{Op: IncrementOperation, At: 5, By: 1},
+		{Op: QueryOperation, From: 0, To: 4},
+	}, trace)
+
+	report, err := ReplayIntRangeTrace(ctx, trace, nil, func(backend Backend) IntRangeCounter {
+		return NewRangeTreeIntCounter(backend, 4, 1)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Increments)
+	assert.Equal(t, 1, report.Queries)
+	assert.EqualValues(t, 4, report.WritesPerIncrement)
+	assert.EqualValues(t, 4, report.ReadsPerQuery)
+	assert.Equal(t, 2, report.IndexesWritten)
+	assert.Equal(t, 7, report.Keys)
+	assert.EqualValues(t, 3.5, report.KeysPerIndex())
+	assert.LessOrEqual(t, report.QueryLatency.P50, report.QueryLatency.Max)
+}
+
+func TestDateRangeCounterRecorderReplay(t *testing.T) {
+	ctx := context.Background()
+	baseDate := time.Date(2019, 1, 1, 1, 0, 0, 0, time.UTC)
+	buffer := &bytes.Buffer{}
+	counter := NewDateRangeCounterRecorder(NewBasicDateCounter(Minute, NewInMemoryBackend()), buffer, nil)
+
+	assert.NoError(t, counter.Increment(ctx, baseDate, 1))
+	assert.NoError(t, counter.Increment(ctx, baseDate.Add(time.Minute), 1))
+	_, err := counter.QuerySum(ctx, baseDate.Add(time.Minute), 2)
+	assert.NoError(t, err)
+
+	trace, err := ReadDateRangeTrace(buffer)
+	assert.NoError(t, err)
+	assert.Len(t, trace, 3)
+	assert.True(t, trace[1].At.Equal(baseDate.Add(time.Minute)))
+	assert.Equal(t, 2, trace[2].BucketCount)
+
+	factory := func(backend Backend) DateRangeCounter {
+		return NewBasicDateCounter(Minute, backend)
+	}
+	report, err := ReplayDateRangeTrace(ctx, trace, Minute, nil, factory)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, report.ReadsPerQuery)
+	assert.EqualValues(t, 1, report.WritesPerIncrement)
+	assert.Equal(t, 2, report.IndexesWritten)
+
+	report, err = ReplayDateRangeTrace(ctx, []DateRangeOperation{
+		{Op: IncrementOperation, At: baseDate, By: 1},
+		{Op: IncrementOperation, At: baseDate.Add(30 * time.Second), By: 1},
+	}, Minute, nil, factory)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.IndexesWritten, "both dates are in the same minute")
+
+	intTrace := IntRangeTrace(trace, Minute)
+	index := Minute.toIndex(baseDate)
+	assert.Equal(t, []IntRangeOperation{
+		{Op: IncrementOperation, At: index, By: 1},
+		{Op: IncrementOperation, At: index + 1, By: 1},
+		{Op: QueryOperation, From: index, To: index + 1},
+	}, intTrace)
+
+	roundTrip := DateRangeTrace(intTrace, Minute)
+	for i := range trace {
+		assert.True(t, trace[i].At.Equal(roundTrip[i].At))
+		assert.Equal(t, trace[i].BucketCount, roundTrip[i].BucketCount)
+	}
+}
+
+func TestBackendRecorder(t *testing.T) {
+	ctx := context.Background()
+	buffer := &bytes.Buffer{}
+	backend := NewBackendRecorder(NewInMemoryBackend(), buffer, nil)
+
+	assert.NoError(t, backend.Increment(ctx, []string{"a", "b"}, []int64{1, 2}))
+	results, err := backend.Query(ctx, []string{"b", "c"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 0}, results)
+
+	trace, err := ReadBackendTrace(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, []BackendOperation{
+		{Op: IncrementOperation, Keys: []string{"a", "b"}, Values: []int64{1, 2}},
+		{Op: QueryOperation, Keys: []string{"b", "c"}},
+	}, trace)
+
+	_, err = ReadBackendTrace(bytes.NewBufferString(`{"op":"increment","keys":["a"],"values":[]}`))
+	assert.Error(t, err)
+}
+
+// failingWriter fail every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestRecorderFailure(t *testing.T) {
+	ctx := context.Background()
+	recordErrors := []error{}
+	backend := NewInMemoryBackend()
+	recorder := NewBackendRecorder(backend, failingWriter{}, func(err error) {
+		recordErrors = append(recordErrors, err)
+	})
+
+	assert.NoError(t, recorder.Increment(ctx, []string{"a"}, []int64{1}), "the increment itself succeeded")
+	results, err := recorder.Query(ctx, []string{"a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, results)
+	assert.Len(t, recordErrors, 2)
+
+	counter := NewIntRangeCounterRecorder(NewBasicIntRangeCounter(backend), failingWriter{}, nil)
+	assert.NoError(t, counter.Increment(ctx, 1, 1), "errors are dropped without onError")
+}
@@ -0,0 +1,144 @@
+package rangecounter
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+// IndexDistribution draw an index for an operation, given how far into the trace it is, from 0 to 1. It may keep
+// state built from the random it is given, so it is not safe for concurrent use.
+type IndexDistribution func(random *rand.Rand, progress float64) int64
+
+// UniformIndexes draw any of the span indexes from start with the same probability.
+func UniformIndexes(start, span int64) IndexDistribution {
+	return func(random *rand.Rand, progress float64) int64 {
+		return start + random.Int63n(span)
+	}
+}
+
+// ZipfIndexes draw the span indexes from start with a Zipf distribution of exponent s, which must be above 1.
+// The hottest indexes are scattered through the span rather than being the first.
+func ZipfIndexes(start, span int64, s float64) IndexDistribution {
+	if s <= 1 {
+		panic("s must be above 1")
+	}
+	if span <= 0 {
+		panic("span must be positive")
+	}
+	// multiplying the ranks by a number coprime with the span, modulo the span, scatter them without collision
+	multiplier := uint64(0x9E3779B97F4A7C15) % uint64(span)
+	for gcd(multiplier, uint64(span)) != 1 {
+		multiplier++
+	}
+	var source *rand.Rand
+	var zipf *rand.Zipf
+	return func(random *rand.Rand, progress float64) int64 {
+		if random != source {
+			source = random
+			zipf = rand.NewZipf(random, s, 1, uint64(span-1))
+		}
+		hi, lo := bits.Mul64(zipf.Uint64(), multiplier)
+		return start + int64(bits.Rem64(hi, lo, uint64(span)))
+	}
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// BurstyIndexes draw burstFraction of the indexes from a few bursts of burstWidth indexes, placed at random in the
+// span, and the rest uniformly.
+func BurstyIndexes(start, span int64, bursts int, burstWidth int64, burstFraction float64) IndexDistribution {
+	if bursts <= 0 || burstWidth <= 0 || burstWidth > span {
+		panic("bursts and burstWidth must be positive, and burstWidth within span")
+	}
+	var source *rand.Rand
+	burstStarts := []int64{}
+	return func(random *rand.Rand, progress float64) int64 {
+		if random != source {
+			source = random
+			burstStarts = burstStarts[:0]
+			for i := 0; i < bursts; i++ {
+				burstStarts = append(burstStarts, start+random.Int63n(span-burstWidth+1))
+			}
+		}
+		if random.Float64() < burstFraction {
+			return burstStarts[random.Intn(bursts)] + random.Int63n(burstWidth)
+		}
+		return start + random.Int63n(span)
+	}
+}
+
+// RecentHeavyIndexes draw indexes shortly before "now", which move from start to start+span through the trace. The
+// age is exponentially distributed with a mean of meanAge indexes.
+func RecentHeavyIndexes(start, span int64, meanAge float64) IndexDistribution {
+	return func(random *rand.Rand, progress float64) int64 {
+		now := start + int64(progress*float64(span-1))
+		age := int64(math.Floor(random.ExpFloat64() * meanAge))
+		return max(start, now-age)
+	}
+}
+
+// TraceGenerator generate a trace of Writes increments by 1 interleaved evenly with Queries queries. Each query
+// ends at an index drawn from QueryIndexes, and its width is drawn from QueryWidths, by relative frequency.
+type TraceGenerator struct {
+	Writes       int
+	Queries      int
+	WriteIndexes IndexDistribution
+	QueryIndexes IndexDistribution
+	QueryWidths  map[int64]float64
+	Seed         int64
+}
+
+// Generate returns the trace, the same for the same seed.
+func (g TraceGenerator) Generate() []IntRangeOperation {
+	random := rand.New(rand.NewSource(g.Seed))
+	pickWidth := newQueryWidthPicker(g.QueryWidths)
+
+	total := g.Writes + g.Queries
+	trace := make([]IntRangeOperation, 0, total)
+	for i := 0; i < total; i++ {
+		progress := float64(i) / float64(total)
+		if (i+1)*g.Writes/total > i*g.Writes/total {
+			trace = append(trace, IntRangeOperation{Op: IncrementOperation, At: g.WriteIndexes(random, progress), By: 1})
+			continue
+		}
+
+		width := pickWidth(random)
+		to := g.QueryIndexes(random, progress)
+		trace = append(trace, IntRangeOperation{Op: QueryOperation, From: to - width + 1, To: to})
+	}
+	return trace
+}
+
+// newQueryWidthPicker returns a function drawing a width from queryWidths, by relative frequency, or 1 if it is empty
+func newQueryWidthPicker(queryWidths map[int64]float64) func(random *rand.Rand) int64 {
+	widths := make([]int64, 0, len(queryWidths))
+	for width := range queryWidths {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	totalWeight := 0.0
+	for _, width := range widths {
+		totalWeight += queryWidths[width]
+	}
+
+	return func(random *rand.Rand) int64 {
+		if len(widths) == 0 {
+			return 1
+		}
+		pick := random.Float64() * totalWeight
+		for _, width := range widths {
+			pick -= queryWidths[width]
+			if pick < 0 {
+				return width
+			}
+		}
+		return widths[len(widths)-1]
+	}
+}
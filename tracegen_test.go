@@ -0,0 +1,98 @@
+package rangecounter
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceGenerator(t *testing.T) {
+	generator := TraceGenerator{
+		Writes:       300,
+		Queries:      100,
+		WriteIndexes: RecentHeavyIndexes(1000, 1000, 5),
+		QueryIndexes: UniformIndexes(1000, 1000),
+		QueryWidths:  map[int64]float64{5: 1, 60: 3},
+		Seed:         1,
+	}
+	trace := generator.Generate()
+	assert.Equal(t, trace, generator.Generate())
+
+	writes, widths := 0, map[int64]int{}
+	lastWrite := int64(0)
+	for i, it := range trace {
+		if it.Op == IncrementOperation {
+			writes++
+			assert.True(t, it.At >= 1000 && it.At < 2000)
+			if i > 200 {
+				assert.Greater(t, it.At, lastWrite-100, "recent heavy writes follow the trace")
+			}
+			lastWrite = it.At
+			continue
+		}
+		widths[it.To-it.From+1]++
+		assert.True(t, it.To >= 1000 && it.To < 2000)
+	}
+	assert.Equal(t, 300, writes)
+	assert.Len(t, widths, 2)
+	assert.Greater(t, widths[60], widths[5])
+}
+
+func TestIndexDistributions(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution IndexDistribution
+		// minTopShare is the least share of draws of the 10 most drawn indexes
+		minTopShare float64
+	}{
+		{"uniform", UniformIndexes(0, 10000), 0},
+		{"zipf", ZipfIndexes(0, 10000, 1.5), 0.5},
+		{"bursty", BurstyIndexes(0, 10000, 2, 5, 0.8), 0.75},
+	}
+	for _, d := range tests {
+		t.Run(d.name, func(t *testing.T) {
+			random := rand.New(rand.NewSource(0))
+			counts := map[int64]int{}
+			for i := 0; i < 10000; i++ {
+				index := d.distribution(random, float64(i)/10000)
+				assert.True(t, index >= 0 && index < 10000)
+				counts[index]++
+			}
+
+			sorted := []int{}
+			for _, count := range counts {
+				sorted = append(sorted, count)
+			}
+			top := 0
+			for i := 0; i < 10 && len(sorted) > 0; i++ {
+				largest := 0
+				for j := range sorted {
+					if sorted[j] > sorted[largest] {
+						largest = j
+					}
+				}
+				top += sorted[largest]
+				sorted = append(sorted[:largest], sorted[largest+1:]...)
+			}
+			assert.GreaterOrEqual(t, float64(top)/10000, d.minTopShare)
+		})
+	}
+}
+
+func TestZipfIndexesReachEveryIndex(t *testing.T) {
+	for _, span := range []int64{1, 12, 64, 1000} {
+		distribution := ZipfIndexes(10, span, 1.1)
+		random := rand.New(rand.NewSource(0))
+		drawn := map[int64]bool{}
+		for i := 0; i < 200000; i++ {
+			index := distribution(random, 0)
+			assert.True(t, index >= 10 && index < 10+span)
+			drawn[index] = true
+		}
+		assert.Len(t, drawn, int(span), "span %v", span)
+	}
+
+	assert.Panics(t, func() { ZipfIndexes(10, 0, 1.1) })
+	assert.Panics(t, func() { ZipfIndexes(10, -5, 1.1) })
+}
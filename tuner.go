@@ -0,0 +1,292 @@
+package rangecounter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	IncrementOperation = "increment"
+	QueryOperation     = "query"
+)
+
+// IntRangeOperation is a single Increment or QuerySum call on an IntRangeCounter, as recorded in a trace.
+type IntRangeOperation struct {
+	Op   string `json:"op"`
+	At   int64  `json:"at,omitempty"`
+	By   int64  `json:"by,omitempty"`
+	From int64  `json:"from,omitempty"`
+	To   int64  `json:"to,omitempty"`
+}
+
+// ReadIntRangeTrace read a trace of one IntRangeOperation in JSON per line.
+func ReadIntRangeTrace(reader io.Reader) ([]IntRangeOperation, error) {
+	trace := []IntRangeOperation{}
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		operation := IntRangeOperation{}
+		err := json.Unmarshal(scanner.Bytes(), &operation)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid operation on line %v", line)
+		}
+		if operation.Op != IncrementOperation && operation.Op != QueryOperation {
+			return nil, errors.Errorf("unknown operation %v on line %v", operation.Op, line)
+		}
+		trace = append(trace, operation)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to read trace")
+	}
+	return trace, nil
+}
+
+// Workload describe how a counter is used, for Tune. The costs weight the keys read per query, the keys written per
+// query and the keys stored per index written.
+type Workload struct {
+	// QueryWidths is the relative frequency of each query width, in number of indexes
+	QueryWidths map[int64]float64
+	// WritesPerQuery is the number of Increment per QuerySum
+	WritesPerQuery float64
+	// StartIndex and IndexSpan is the range of indexes written and queried
+	StartIndex int64
+	IndexSpan  int64
+
+	ReadCost  float64
+	WriteCost float64
+	KeyCost   float64
+
+	// Trace, if set, is replayed by the simulation instead of random operations
+	Trace []IntRangeOperation
+}
+
+// WorkloadFromTrace describe the workload of a trace, with a cost of 1 for reads and writes and 0 for keys.
+func WorkloadFromTrace(trace []IntRangeOperation) Workload {
+	workload := Workload{
+		QueryWidths: map[int64]float64{},
+		ReadCost:    1,
+		WriteCost:   1,
+		Trace:       trace,
+	}
+
+	lowest, highest := int64(math.MaxInt64), int64(math.MinInt64)
+	writes, queries := 0, 0
+	for _, operation := range trace {
+		if operation.Op == IncrementOperation {
+			writes++
+			lowest, highest = min(lowest, operation.At), max(highest, operation.At)
+			continue
+		}
+		queries++
+		workload.QueryWidths[operation.To-operation.From+1]++
+		lowest, highest = min(lowest, operation.From), max(highest, operation.To)
+	}
+
+	if queries != 0 {
+		workload.WritesPerQuery = float64(writes) / float64(queries)
+	}
+	if lowest <= highest {
+		workload.StartIndex = lowest
+		workload.IndexSpan = highest - lowest + 1
+	}
+	return workload
+}
+
+// TuneCandidate is a heightLimit and bitLength of NewRangeTreeIntCounter. A heightLimit of 1 is the bucket scheme.
+type TuneCandidate struct {
+	HeightLimit int
+	BitLength   uint
+}
+
+// DefaultTuneCandidates is the bucket scheme and the trees of height 2 to 16 with 2 to 16 child per node.
+var DefaultTuneCandidates = []TuneCandidate{
+	{1, 1},
+	{2, 1}, {2, 2}, {2, 4},
+	{4, 1}, {4, 2}, {4, 4},
+	{8, 1}, {8, 2}, {8, 4},
+	{16, 1}, {16, 2},
+}
+
+// TuneOptions configure Tune. Zero values use the defaults.
+type TuneOptions struct {
+	Candidates []TuneCandidate
+	// Queries is the number of QuerySum simulated when the workload has no trace, 10000 by default
+	Queries int
+	Seed    int64
+}
+
+// Recommendation is the expected reads and writes per operation and keys per index written of a candidate, both as
+// estimated analytically and as measured by simulation. Cost is from the simulated numbers.
+type Recommendation struct {
+	TuneCandidate
+
+	EstimatedReads  float64
+	EstimatedWrites float64
+	EstimatedKeys   float64
+
+	Reads  float64
+	Writes float64
+	Keys   float64
+
+	Cost float64
+}
+
+// Tune evaluate each candidate against the workload and returns them from the cheapest.
+func Tune(ctx context.Context, workload Workload, options TuneOptions) ([]Recommendation, error) {
+	if len(workload.Trace) == 0 {
+		if len(workload.QueryWidths) == 0 {
+			return nil, errors.New("workload must have a trace or query widths")
+		}
+		if workload.IndexSpan <= 0 {
+			return nil, errors.New("workload index span must be positive")
+		}
+		for width := range workload.QueryWidths {
+			if width <= 0 {
+				return nil, errors.Errorf("query width must be positive, got %v", width)
+			}
+		}
+	}
+	if options.Candidates == nil {
+		options.Candidates = DefaultTuneCandidates
+	}
+	if options.Queries == 0 {
+		options.Queries = 10000
+	}
+
+	recommendations := make([]Recommendation, 0, len(options.Candidates))
+	for _, candidate := range options.Candidates {
+		if candidate.HeightLimit <= 0 || candidate.BitLength == 0 {
+			return nil, errors.Errorf("invalid candidate %+v", candidate)
+		}
+		recommendation := Recommendation{TuneCandidate: candidate}
+		recommendation.EstimatedReads, recommendation.EstimatedWrites, recommendation.EstimatedKeys = estimateTreeCost(workload, candidate)
+
+		var err error
+		recommendation.Reads, recommendation.Writes, recommendation.Keys, err = simulateTreeCost(ctx, workload, candidate, options)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to simulate %+v", candidate)
+		}
+		recommendation.Cost = workload.ReadCost*recommendation.Reads +
+			workload.WriteCost*workload.WritesPerQuery*recommendation.Writes +
+			workload.KeyCost*recommendation.Keys
+		recommendations = append(recommendations, recommendation)
+	}
+
+	sort.SliceStable(recommendations, func(i, j int) bool {
+		return recommendations[i].Cost < recommendations[j].Cost
+	})
+	return recommendations, nil
+}
+
+// estimateTreeCost assume a query of width w fully use the lowest levels whose node are smaller than w, reading on
+// average half of the 2^p-1 siblings on each side of each of those levels, and the nodes in between above them.
+// The keys assume every index in the span is written.
+func estimateTreeCost(workload Workload, candidate TuneCandidate) (float64, float64, float64) {
+	branching := math.Pow(2, float64(candidate.BitLength))
+
+	totalWeight, reads := 0.0, 0.0
+	for width, weight := range workload.QueryWidths {
+		levels := math.Floor(math.Log(float64(width)) / math.Log(branching))
+		levels = math.Max(0, math.Min(levels, float64(candidate.HeightLimit-1)))
+		nodes := (branching-1)*levels + float64(width)/math.Pow(branching, levels)
+		reads += weight * math.Min(nodes, float64(width))
+		totalWeight += weight
+	}
+	if totalWeight != 0 {
+		reads /= totalWeight
+	}
+
+	keys := 0.0
+	for level := 0; level < candidate.HeightLimit; level++ {
+		keys += 1 / math.Pow(branching, float64(level))
+	}
+	return reads, float64(candidate.HeightLimit), keys
+}
+
+// simulateTreeCost replay the trace, or random writes then random queries, against the benchmarking backend
+func simulateTreeCost(ctx context.Context, workload Workload, candidate TuneCandidate, options TuneOptions) (float64, float64, float64, error) {
+	backend := NewBenchmarkBackend()
+	counter := NewRangeTreeIntCounter(backend, candidate.HeightLimit, candidate.BitLength)
+
+	trace := workload.Trace
+	if len(trace) == 0 {
+		trace = generateWorkloadTrace(workload, options)
+	}
+
+	writes, queries := 0, 0
+	written := map[int64]bool{}
+	for _, operation := range trace {
+		if operation.Op == IncrementOperation {
+			err := counter.Increment(ctx, operation.At, operation.By)
+			if err != nil {
+				return 0, 0, 0, err
+			}
+			writes++
+			written[operation.At] = true
+			continue
+		}
+		_, err := counter.QuerySum(ctx, operation.From, operation.To)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		queries++
+	}
+
+	reads, writeKeys, keys := 0.0, 0.0, 0.0
+	if queries != 0 {
+		reads = float64(backend.queryKeyTouched) / float64(queries)
+	}
+	if writes != 0 {
+		writeKeys = float64(backend.incrementKeyTouched) / float64(writes)
+		keys = float64(len(backend.store)) / float64(len(written))
+	}
+	return reads, writeKeys, keys, nil
+}
+
+// generateWorkloadTrace is the writes uniformly within the span, followed by the queries of random width and start
+func generateWorkloadTrace(workload Workload, options TuneOptions) []IntRangeOperation {
+	random := rand.New(rand.NewSource(options.Seed))
+
+	widths := make([]int64, 0, len(workload.QueryWidths))
+	for width := range workload.QueryWidths {
+		widths = append(widths, width)
+	}
+	sort.Slice(widths, func(i, j int) bool { return widths[i] < widths[j] })
+	totalWeight := 0.0
+	for _, width := range widths {
+		totalWeight += workload.QueryWidths[width]
+	}
+
+	writes := int(math.Round(workload.WritesPerQuery * float64(options.Queries)))
+	trace := make([]IntRangeOperation, 0, writes+options.Queries)
+	for i := 0; i < writes; i++ {
+		trace = append(trace, IntRangeOperation{
+			Op: IncrementOperation,
+			At: workload.StartIndex + random.Int63n(workload.IndexSpan),
+			By: 1,
+		})
+	}
+	for i := 0; i < options.Queries; i++ {
+		width := widths[len(widths)-1]
+		pick := random.Float64() * totalWeight
+		for _, it := range widths {
+			pick -= workload.QueryWidths[it]
+			if pick < 0 {
+				width = it
+				break
+			}
+		}
+		from := workload.StartIndex + random.Int63n(workload.IndexSpan)
+		trace = append(trace, IntRangeOperation{Op: QueryOperation, From: from, To: from + width - 1})
+	}
+	return trace
+}
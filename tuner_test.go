@@ -0,0 +1,93 @@
+package rangecounter
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTuneRanksByCost(t *testing.T) {
+	ctx := context.Background()
+	candidates := []TuneCandidate{{1, 1}, {8, 1}}
+
+	tests := []struct {
+		name     string
+		workload Workload
+		best     TuneCandidate
+	}{
+		{
+			name: "long queries favour the tree",
+			workload: Workload{
+				QueryWidths:    map[int64]float64{100: 1},
+				WritesPerQuery: 1,
+				IndexSpan:      10000,
+				ReadCost:       1,
+				WriteCost:      1,
+			},
+			best: TuneCandidate{8, 1},
+		},
+		{
+			name: "writes favour the buckets",
+			workload: Workload{
+				QueryWidths:    map[int64]float64{5: 1},
+				WritesPerQuery: 10,
+				IndexSpan:      10000,
+				ReadCost:       1,
+				WriteCost:      1,
+			},
+			best: TuneCandidate{1, 1},
+		},
+	}
+	for _, d := range tests {
+		t.Run(d.name, func(t *testing.T) {
+			recommendations, err := Tune(ctx, d.workload, TuneOptions{Candidates: candidates, Queries: 1000})
+			assert.NoError(t, err)
+			assert.Len(t, recommendations, 2)
+			assert.Equal(t, d.best, recommendations[0].TuneCandidate)
+			assert.LessOrEqual(t, recommendations[0].Cost, recommendations[1].Cost)
+		})
+	}
+}
+
+func TestTuneEstimateMatchSimulation(t *testing.T) {
+	recommendations, err := Tune(context.Background(), Workload{
+		QueryWidths:    map[int64]float64{1: 1, 64: 1},
+		WritesPerQuery: 1,
+		IndexSpan:      1000,
+		ReadCost:       1,
+	}, TuneOptions{Candidates: []TuneCandidate{{1, 1}, {4, 2}}, Queries: 1000})
+	assert.NoError(t, err)
+
+	for _, it := range recommendations {
+		assert.Equal(t, it.EstimatedWrites, it.Writes)
+		assert.InDelta(t, it.EstimatedReads, it.Reads, it.EstimatedReads*0.5, "%+v", it.TuneCandidate)
+	}
+}
+
+func TestTuneFromTrace(t *testing.T) {
+	trace, err := ReadIntRangeTrace(strings.NewReader(`{"op":"increment","at":10,"by":1}
+{"op":"increment","at":11,"by":2}
+
+{"op":"query","from":8,"to":11}
+{"op":"query","from":11,"to":11}
+`))
+	assert.NoError(t, err)
+	assert.Len(t, trace, 4)
+
+	workload := WorkloadFromTrace(trace)
+	assert.Equal(t, map[int64]float64{4: 1, 1: 1}, workload.QueryWidths)
+	assert.EqualValues(t, 1, workload.WritesPerQuery)
+	assert.EqualValues(t, 8, workload.StartIndex)
+	assert.EqualValues(t, 4, workload.IndexSpan)
+
+	recommendations, err := Tune(context.Background(), workload, TuneOptions{Candidates: []TuneCandidate{{1, 1}}})
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2.5, recommendations[0].Reads)
+	assert.EqualValues(t, 1, recommendations[0].Writes)
+	assert.EqualValues(t, 1, recommendations[0].Keys)
+
+	_, err = ReadIntRangeTrace(strings.NewReader(`{"op":"delete"}`))
+	assert.Error(t, err)
+}
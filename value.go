@@ -0,0 +1,103 @@
+package rangecounter
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Value is what a counter count in. Use Decimal instead of float64 for amounts that must sum exactly,
+// such as currency.
+type Value interface {
+	~int64 | ~float64
+}
+
+// DecimalScale is the number of decimal digits of a Decimal
+const DecimalScale = 6
+
+const decimalUnit = 1000000
+
+// Decimal is a fixed point number with DecimalScale decimal digits, stored as an int64 of 10^-DecimalScale units,
+// so it sum exactly and can be stored in any int64 backend through NewDecimalBackend. It range up to about
+// 9.2*10^12, and a sum going past it wrap around silently, like an int64.
+type Decimal int64
+
+// NewDecimal creates a Decimal of units + micros*10^-6
+func NewDecimal(units int64, micros int64) Decimal {
+	return Decimal(units*decimalUnit + micros)
+}
+
+// DecimalFromFloat round value to the nearest Decimal
+func DecimalFromFloat(value float64) Decimal {
+	return Decimal(math.Round(value * decimalUnit))
+}
+
+// ParseDecimal parse a decimal string such as "-12.345", with at most DecimalScale decimal digits
+func ParseDecimal(value string) (Decimal, error) {
+	unsigned := value
+	negative := false
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		negative = value[0] == '-'
+		unsigned = value[1:]
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(unsigned, ".")
+	if integerPart == "" && fractionPart == "" {
+		return 0, errors.Errorf("invalid decimal %q", value)
+	}
+	if len(fractionPart) > DecimalScale {
+		return 0, errors.Errorf("decimal %q has more than %v decimal digits", value, DecimalScale)
+	}
+
+	units := uint64(0)
+	if integerPart != "" {
+		parsed, err := strconv.ParseUint(integerPart, 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("invalid decimal %q", value)
+		}
+		units = parsed
+	}
+	micros := uint64(0)
+	if fractionPart != "" {
+		parsed, err := strconv.ParseUint(fractionPart+strings.Repeat("0", DecimalScale-len(fractionPart)), 10, 64)
+		if err != nil {
+			return 0, errors.Errorf("invalid decimal %q", value)
+		}
+		micros = parsed
+	}
+
+	// the magnitude of a negative decimal can be one more than the max, for math.MinInt64
+	limit := uint64(math.MaxInt64)
+	if negative {
+		limit++
+	}
+	if units > (limit-micros)/decimalUnit {
+		return 0, errors.Errorf("decimal %q is out of range", value)
+	}
+	magnitude := units*decimalUnit + micros
+	if negative {
+		return Decimal(-magnitude), nil
+	}
+	return Decimal(magnitude), nil
+}
+
+func (d Decimal) Float64() float64 {
+	return float64(d) / decimalUnit
+}
+
+func (d Decimal) String() string {
+	sign := ""
+	magnitude := uint64(d)
+	if d < 0 {
+		sign = "-"
+		magnitude = uint64(-d)
+	}
+
+	fraction := strings.TrimRight(strconv.FormatUint(decimalUnit+magnitude%decimalUnit, 10)[1:], "0")
+	if fraction == "" {
+		return sign + strconv.FormatUint(magnitude/decimalUnit, 10)
+	}
+	return sign + strconv.FormatUint(magnitude/decimalUnit, 10) + "." + fraction
+}
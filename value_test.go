@@ -0,0 +1,91 @@
+package rangecounter
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimalBehaviour(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Decimal
+		output   string
+	}{
+		{"0", 0, "0"},
+		{"1", NewDecimal(1, 0), "1"},
+		{"12.5", NewDecimal(12, 500000), "12.5"},
+		{"-0.000001", -1, "-0.000001"},
+		{".25", NewDecimal(0, 250000), "0.25"},
+		{"+3.140000", NewDecimal(3, 140000), "3.14"},
+		{"-9223372036854.775807", Decimal(-9223372036854775807), "-9223372036854.775807"},
+		{"9223372036854.775807", Decimal(math.MaxInt64), "9223372036854.775807"},
+		{"-9223372036854.775808", Decimal(math.MinInt64), "-9223372036854.775808"},
+	}
+	for _, d := range tests {
+		t.Run(d.input, func(t *testing.T) {
+			decimal, err := ParseDecimal(d.input)
+			assert.NoError(t, err)
+			assert.Equal(t, d.expected, decimal)
+			assert.Equal(t, d.output, decimal.String())
+		})
+	}
+
+	for _, invalid := range []string{
+		"", "-", ".", "1.0000001", "abc", "1.-1", "99999999999999", "-+1", "+-1", "--1", "1.+5",
+		"9223372036854.775808", "9223372036854.999999", "-9223372036854.775809", "-9223372036855",
+		"18446744073709551616",
+	} {
+		_, err := ParseDecimal(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	assert.Equal(t, NewDecimal(0, 300000), DecimalFromFloat(0.1)+DecimalFromFloat(0.2))
+}
+
+func TestGenericCounterBehaviour(t *testing.T) {
+	baseDate := time.Date(2019, 1, 1, 1, 1, 1, 1, time.Local)
+	ctx := context.Background()
+
+	floatCounters := map[string]GenericDateRangeCounter[float64]{
+		"dateRange": NewGenericBasicDateCounter[float64](Hour, NewGenericInMemoryBackend[float64]()),
+		"intBacked": NewGenericIntBackedDateRange[float64](NewGenericBasicIntRangeCounter[float64](NewGenericInMemoryBackend[float64]()), Hour),
+		"intRangeTreeBacked": NewGenericIntBackedDateRange[float64](
+			NewGenericIntRangeTranslator[float64](NewGenericRangeTreeIntCounter[float64](NewGenericBenchmarkBackend[float64](), 16, 3), Hour, Seconds), Hour),
+	}
+	for counterName, counter := range floatCounters {
+		t.Run("float64 "+counterName, func(t *testing.T) {
+			assert.NoError(t, counter.Increment(ctx, baseDate, 0.5))
+			assert.NoError(t, counter.Increment(ctx, Hour.incrementDateForce(1, baseDate), 1.25))
+			assert.NoError(t, counter.Increment(ctx, Hour.incrementDateForce(3, baseDate), 2))
+
+			sum, err := counter.QuerySum(ctx, Hour.incrementDateForce(3, baseDate), 4)
+			assert.NoError(t, err)
+			assert.InDelta(t, 3.75, sum, 1e-9)
+		})
+	}
+
+	decimalCounters := map[string]GenericConditionalDateRangeCounter[Decimal]{
+		"dateRange":          NewGenericBasicDateCounter[Decimal](Hour, NewGenericInMemoryBackend[Decimal]()),
+		"intRangeTreeBacked": NewGenericIntBackedDateRange[Decimal](NewGenericRangeTreeIntCounter[Decimal](NewGenericInMemoryBackend[Decimal](), 8, 1), Hour),
+		"int64Backend":       NewGenericBasicDateCounter[Decimal](Hour, NewDecimalBackend(NewInMemoryBackend())),
+	}
+	for counterName, counter := range decimalCounters {
+		t.Run("decimal "+counterName, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, counter.Increment(ctx, baseDate, DecimalFromFloat(0.1)))
+			}
+			sum, err := counter.QuerySum(ctx, baseDate, 1)
+			assert.NoError(t, err)
+			assert.Equal(t, "1", sum.String())
+
+			applied, current, err := counter.IncrementIfBelow(ctx, baseDate, NewDecimal(0, 500000), 1, NewDecimal(1, 400000))
+			assert.NoError(t, err)
+			assert.False(t, applied)
+			assert.Equal(t, NewDecimal(1, 0), current)
+		})
+	}
+}